@@ -0,0 +1,457 @@
+package coinapi
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// address kinds
+const (
+	AddressKindP2PKH   = "p2pkh"
+	AddressKindP2SH    = "p2sh"
+	AddressKindWitness = "witness"
+	AddressKindEVM     = "evm"
+)
+
+const cashAddrPrefixBCH = "bitcoincash"
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2BC830A3
+)
+
+var reEVMAddress = regexp.MustCompile("^0x[0-9a-fA-F]{40}$")
+
+var errEIP55Checksum = errors.New("EIP-55 checksum mismatch")
+
+//AddressInfo decoded address
+type AddressInfo struct {
+	Coin    string
+	Address string // normalized form
+	Kind    string
+
+	WitnessVersion int
+	Hash           []byte // hash160, witness program or evm address bytes
+}
+
+//EIP55Address returns checksummed form of 20 byte hex address
+func EIP55Address(address string) string {
+	lower := strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(address, "0x"), "0X"))
+
+	hash := hex.EncodeToString(crypto.Keccak256([]byte(lower)))
+
+	result := []byte(lower)
+
+	for i, c := range result {
+		if c >= 'a' && c <= 'f' && hash[i] >= '8' {
+			result[i] = c - 'a' + 'A'
+		}
+	}
+
+	return "0x" + string(result)
+}
+
+func parseEVMAddress(address string) (*AddressInfo, error) {
+	if !reEVMAddress.MatchString(address) {
+		return nil, fmt.Errorf("not a 0x prefixed 20 byte hex address")
+	}
+
+	body := address[2:]
+	normalized := EIP55Address(address)
+
+	// single case addresses carry no checksum
+	if body != strings.ToLower(body) && body != strings.ToUpper(body) && address != normalized {
+		return nil, errEIP55Checksum
+	}
+
+	hash, _ := hex.DecodeString(body)
+
+	return &AddressInfo{Address: normalized, Kind: AddressKindEVM, Hash: hash}, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	gen := []uint32{0x3B6A57B2, 0x26508E6D, 0x1EA119FA, 0x3D4233DD, 0x2A1462B3}
+
+	chk := uint32(1)
+
+	for _, v := range values {
+		b := chk >> 25
+		chk = (chk&0x1FFFFFF)<<5 ^ uint32(v)
+
+		for i := 0; i < 5; i++ {
+			if (b>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	var r []byte
+
+	for _, c := range hrp {
+		r = append(r, byte(c>>5))
+	}
+
+	r = append(r, 0)
+
+	for _, c := range hrp {
+		r = append(r, byte(c&31))
+	}
+
+	return r
+}
+
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var acc uint
+	var bits uint
+	var r []byte
+
+	maxv := uint(1)<<toBits - 1
+
+	for _, v := range data {
+		if uint(v)>>fromBits != 0 {
+			return nil, fmt.Errorf("invalid data range")
+		}
+
+		acc = acc<<fromBits | uint(v)
+		bits += fromBits
+
+		for bits >= toBits {
+			bits -= toBits
+			r = append(r, byte(acc>>bits&maxv))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			r = append(r, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, fmt.Errorf("invalid padding")
+	}
+
+	return r, nil
+}
+
+func decodeBase32(s string) ([]byte, error) {
+	var r []byte
+
+	for _, c := range s {
+		i := strings.IndexRune(bech32Charset, c)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid character %q", c)
+		}
+
+		r = append(r, byte(i))
+	}
+
+	return r, nil
+}
+
+//decodeSegwit BIP173/BIP350 segwit address decoding
+func decodeSegwit(hrp, address string) (int, []byte, error) {
+	if address != strings.ToLower(address) && address != strings.ToUpper(address) {
+		return 0, nil, fmt.Errorf("mixed case")
+	}
+
+	address = strings.ToLower(address)
+
+	pos := strings.LastIndex(address, "1")
+	if pos < 1 || pos+7 > len(address) || len(address) > 90 {
+		return 0, nil, fmt.Errorf("invalid bech32 length")
+	}
+
+	if address[:pos] != hrp {
+		return 0, nil, fmt.Errorf("prefix [%s] expected", hrp)
+	}
+
+	data, err := decodeBase32(address[pos+1:])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	check := bech32Polymod(append(bech32HRPExpand(hrp), data...))
+
+	if len(data) < 7 {
+		return 0, nil, fmt.Errorf("invalid bech32 length")
+	}
+
+	version := int(data[0])
+
+	switch {
+	case version == 0 && check != bech32Const:
+		return 0, nil, fmt.Errorf("invalid bech32 checksum")
+	case version > 0 && check != bech32mConst:
+		return 0, nil, fmt.Errorf("invalid bech32m checksum")
+	case version > 16:
+		return 0, nil, fmt.Errorf("invalid witness version")
+	}
+
+	program, err := convertBits(data[1:len(data)-6], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(program) < 2 || len(program) > 40 || (version == 0 && len(program) != 20 && len(program) != 32) {
+		return 0, nil, fmt.Errorf("invalid witness program length")
+	}
+
+	return version, program, nil
+}
+
+func cashAddrPolymod(values []byte) uint64 {
+	gen := []uint64{0x98F2BC8E61, 0x79B76D99E2, 0xF33E5FB3C4, 0xAE2EABE2A8, 0x1E4F43E470}
+
+	c := uint64(1)
+
+	for _, d := range values {
+		c0 := c >> 35
+		c = (c&0x07FFFFFFFF)<<5 ^ uint64(d)
+
+		for i := 0; i < 5; i++ {
+			if (c0>>uint(i))&1 == 1 {
+				c ^= gen[i]
+			}
+		}
+	}
+
+	return c ^ 1
+}
+
+func cashAddrPrefixExpand(prefix string) []byte {
+	var r []byte
+
+	for _, c := range prefix {
+		r = append(r, byte(c&31))
+	}
+
+	return append(r, 0)
+}
+
+//CashAddrEncode encodes hash160 as BCH CashAddr, isScript for P2SH
+func CashAddrEncode(hash []byte, isScript bool) (string, error) {
+	if len(hash) != 20 {
+		return "", fmt.Errorf("hash160 expected")
+	}
+
+	version := byte(0)
+	if isScript {
+		version = 8
+	}
+
+	payload, err := convertBits(append([]byte{version}, hash...), 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	mod := cashAddrPolymod(append(append(cashAddrPrefixExpand(cashAddrPrefixBCH), payload...), make([]byte, 8)...))
+
+	for i := 0; i < 8; i++ {
+		payload = append(payload, byte(mod>>uint(5*(7-i))&31))
+	}
+
+	var b strings.Builder
+
+	b.WriteString(cashAddrPrefixBCH + ":")
+
+	for _, d := range payload {
+		b.WriteByte(bech32Charset[d])
+	}
+
+	return b.String(), nil
+}
+
+//CashAddrDecode decodes BCH CashAddr with or without prefix, returns hash160 and isScript
+func CashAddrDecode(address string) ([]byte, bool, error) {
+	if address != strings.ToLower(address) && address != strings.ToUpper(address) {
+		return nil, false, fmt.Errorf("mixed case")
+	}
+
+	address = strings.ToLower(address)
+
+	prefix := cashAddrPrefixBCH
+
+	if pos := strings.Index(address, ":"); pos >= 0 {
+		prefix, address = address[:pos], address[pos+1:]
+	}
+
+	if prefix != cashAddrPrefixBCH {
+		return nil, false, fmt.Errorf("prefix [%s] expected", cashAddrPrefixBCH)
+	}
+
+	data, err := decodeBase32(address)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(data) < 8 || cashAddrPolymod(append(cashAddrPrefixExpand(prefix), data...)) != 0 {
+		return nil, false, fmt.Errorf("invalid cashaddr checksum")
+	}
+
+	payload, err := convertBits(data[:len(data)-8], 5, 8, false)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(payload) != 21 {
+		return nil, false, fmt.Errorf("only 160 bit hashes supported")
+	}
+
+	switch payload[0] {
+	case 0:
+		return payload[1:], false, nil
+	case 8:
+		return payload[1:], true, nil
+	}
+
+	return nil, false, fmt.Errorf("unknown cashaddr version %d", payload[0])
+}
+
+func parseBitcoinAddress(tag string, c *coinInfo, address string) (*AddressInfo, error) {
+	info := &AddressInfo{Coin: tag, Address: address}
+
+	switch {
+	case tag == CoinZEC:
+		script, err := zcashAddressScript(address)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(script) == 25 {
+			info.Kind, info.Hash = AddressKindP2PKH, script[3:23]
+		} else {
+			info.Kind, info.Hash = AddressKindP2SH, script[2:22]
+		}
+
+		return info, nil
+
+	case tag == CoinBCH && (strings.HasPrefix(strings.ToLower(address), cashAddrPrefixBCH+":") || strings.HasPrefix(address, "q") || strings.HasPrefix(address, "p")):
+		hash, isScript, err := CashAddrDecode(address)
+		if err != nil {
+			return nil, err
+		}
+
+		info.Kind, info.Hash = AddressKindP2PKH, hash
+		if isScript {
+			info.Kind = AddressKindP2SH
+		}
+
+		info.Address, _ = CashAddrEncode(hash, isScript)
+
+		return info, nil
+
+	case len(c.B.Bech32HRP) > 0 && strings.HasPrefix(strings.ToLower(address), c.B.Bech32HRP+"1"):
+		version, program, err := decodeSegwit(c.B.Bech32HRP, address)
+		if err != nil {
+			return nil, err
+		}
+
+		info.Kind, info.WitnessVersion, info.Hash = AddressKindWitness, version, program
+		info.Address = strings.ToLower(address)
+
+		return info, nil
+	}
+
+	hash, version, err := base58.CheckDecode(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hash) != 20 {
+		return nil, fmt.Errorf("invalid address length")
+	}
+
+	switch version {
+	case c.B.PubKeyID:
+		info.Kind = AddressKindP2PKH
+	case c.B.ScriptID:
+		info.Kind = AddressKindP2SH
+	default:
+		return nil, fmt.Errorf("version 0x%02X is not valid for %s", version, tag)
+	}
+
+	info.Hash = hash
+
+	// BCH nodes accept legacy addresses, CashAddr is the normalized form
+	if tag == CoinBCH {
+		info.Address, _ = CashAddrEncode(hash, info.Kind == AddressKindP2SH)
+	}
+
+	return info, nil
+}
+
+func parseAddress(tag, address string) (*AddressInfo, error) {
+	c := Coins[tag]
+	if c == nil {
+		return nil, errCoinNotSupported
+	}
+
+	switch tag {
+	case CoinETH, CoinETC:
+		info, err := parseEVMAddress(address)
+		if err != nil {
+			return nil, err
+		}
+
+		info.Coin = tag
+
+		return info, nil
+	}
+
+	return parseBitcoinAddress(tag, c, address)
+}
+
+//DetectCoins returns coins address is valid for
+func DetectCoins(address string) []string {
+	var tags []string
+
+	for tag := range Coins {
+		if _, err := parseAddress(tag, address); err == nil {
+			tags = append(tags, tag)
+		}
+	}
+
+	sort.Strings(tags)
+
+	return tags
+}
+
+//ParseAddress offline validation and normalization of address for coin, reports likely cross-coin mistakes
+func ParseAddress(tag, address string) (*AddressInfo, error) {
+	info, err := parseAddress(tag, strings.TrimSpace(address))
+	if err == nil {
+		return info, nil
+	}
+
+	if err == errEIP55Checksum || err == errCoinNotSupported {
+		return nil, err
+	}
+
+	if others := DetectCoins(address); len(others) > 0 {
+		return nil, fmt.Errorf("address is not valid for %s, looks like %s address", tag, strings.Join(others, "/"))
+	}
+
+	return nil, err
+}
+
+//NormalizeAddress returns canonical form of address: EIP-55 for EVM, lower case bech32, CashAddr for BCH
+func NormalizeAddress(tag, address string) (string, error) {
+	info, err := ParseAddress(tag, address)
+	if err != nil {
+		return "", err
+	}
+
+	return info.Address, nil
+}
@@ -0,0 +1,188 @@
+package coinapi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+func TestDecodeSegwit(t *testing.T) {
+	// BIP-173 and BIP-350 vectors
+	tests := []struct {
+		hrp     string
+		address string
+		version int
+		program string // empty if address is invalid
+	}{
+		{"bc", "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", 0, "751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb", "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", 0, "1863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc", "bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", 1, "751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"bc", "BC1SW50QGDZ25J", 16, "751e"},
+		{"bc", "bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", 2, "751e76e8199196d454941c45d1b3a323"},
+		{"tb", "tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c", 1, "000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+
+		{"bc", "tc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq5zuyut", 0, ""}, // invalid hrp
+		{"bc", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd", 0, ""}, // bech32 checksum for v1
+		{"bc", "BC1S0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ54WELL", 0, ""}, // bech32 checksum for v16
+		{"bc", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh", 0, ""},                     // bech32m checksum for v0
+		{"tb", "tb1q0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq24jc47", 0, ""}, // bech32m checksum for v0
+		{"bc", "bc1p38j9r5y49hruaue7wxjce0updqjuyyx0kh56v8s25huc6995vvpql3jow4", 0, ""}, // invalid character
+		{"bc", "BC130XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ7ZWS8R", 0, ""}, // witness version 17
+		{"bc", "bc1pw5dgrnzv", 0, ""}, // program of 1 byte
+		{"bc", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v8n0nx0muaewav253zgeav", 0, ""}, // program of 41 bytes
+		{"bc", "BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P", 0, ""},                                         // v0 program of 16 bytes
+		{"tb", "tb1z0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqglt7rf", 0, ""},               // bech32 checksum for v2
+		{"bc", "bc1gmk9yu", 0, ""}, // empty data
+		{"tb", "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sL5k7", 0, ""}, // mixed case
+	}
+
+	for _, tt := range tests {
+		version, program, err := decodeSegwit(tt.hrp, tt.address)
+
+		if len(tt.program) == 0 {
+			if err == nil {
+				t.Errorf("%s: decoded as invalid address", tt.address)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.address, err)
+
+			continue
+		}
+
+		if version != tt.version || hex.EncodeToString(program) != tt.program {
+			t.Errorf("%s: version %d program %x, want %d %s", tt.address, version, program, tt.version, tt.program)
+		}
+	}
+}
+
+func TestCashAddr(t *testing.T) {
+	// CashAddr specification examples
+	tests := []struct {
+		cashAddr string
+		legacy   string
+		isScript bool
+	}{
+		{"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu", false},
+		{"bitcoincash:qr95sy3j9xwd2ap32xkykttr4cvcu7as4y0qverfuy", "1KXrWXciRDZUpQwQmuM1DbwsKDLYAYsVLR", false},
+		{"bitcoincash:qqq3728yw0y47sqn6l2na30mcw6zm78dzqre909m2r", "16w1D5WRVKJuZUsSRzdLp9w3YGcgoxDXb", false},
+		{"bitcoincash:ppm2qsznhks23z7629mms6s4cwef74vcwvn0h829pq", "3CWFddi6m4ndiGyKqzYvsFYagqDLPVMTzC", true},
+		{"bitcoincash:pr95sy3j9xwd2ap32xkykttr4cvcu7as4yc93ky28e", "3LDsS579y7sruadqu11beEJoTjdFiFCdX4", true},
+		{"bitcoincash:pqq3728yw0y47sqn6l2na30mcw6zm78dzq5ucqzc37", "31nwvkZwyPdgzjBJZXfDmSWsC4ZLKpYyUw", true},
+	}
+
+	for _, tt := range tests {
+		legacyHash, _, err := base58.CheckDecode(tt.legacy)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.legacy, err)
+		}
+
+		hash, isScript, err := CashAddrDecode(tt.cashAddr)
+		if err != nil || isScript != tt.isScript || !bytes.Equal(hash, legacyHash) {
+			t.Errorf("%s: hash %x isScript %v err %v, want %x %v", tt.cashAddr, hash, isScript, err, legacyHash, tt.isScript)
+		}
+
+		encoded, err := CashAddrEncode(legacyHash, tt.isScript)
+		if err != nil || encoded != tt.cashAddr {
+			t.Errorf("%s: encoded %s err %v", tt.legacy, encoded, err)
+		}
+
+		for _, address := range []string{tt.legacy, tt.cashAddr, strings.TrimPrefix(tt.cashAddr, cashAddrPrefixBCH+":"), strings.ToUpper(tt.cashAddr)} {
+			normalized, err := NormalizeAddress(CoinBCH, address)
+			if err != nil || normalized != tt.cashAddr {
+				t.Errorf("%s: normalized %s err %v", address, normalized, err)
+			}
+		}
+	}
+
+	for _, address := range []string{
+		"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6b", // checksum
+		"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvY22gdx6a", // mixed case
+		"bchtest:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a",     // prefix
+	} {
+		_, _, err := CashAddrDecode(address)
+		if err == nil {
+			t.Errorf("%s: decoded as invalid address", address)
+		}
+	}
+}
+
+func TestEIP55Address(t *testing.T) {
+	// EIP-55 examples
+	for _, address := range []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	} {
+		if got := EIP55Address(strings.ToLower(address)); got != address {
+			t.Errorf("EIP55Address(%s) = %s", strings.ToLower(address), got)
+		}
+
+		for _, variant := range []string{address, strings.ToLower(address), "0x" + strings.ToUpper(address[2:])} {
+			normalized, err := NormalizeAddress(CoinETH, variant)
+			if err != nil || normalized != address {
+				t.Errorf("%s: normalized %s err %v", variant, normalized, err)
+			}
+		}
+
+		// one letter of checksum flipped
+		i := strings.IndexAny(address[2:], "abcdefABCDEF") + 2
+		flipped := []byte(address)
+		flipped[i] ^= 0x20
+
+		_, err := ParseAddress(CoinETH, string(flipped))
+		if err != errEIP55Checksum {
+			t.Errorf("%s: err %v, want %v", flipped, err, errEIP55Checksum)
+		}
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		tag     string
+		address string
+		kind    string
+		wantErr string // substring of error, empty if address is valid
+	}{
+		{CoinBTC, "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", AddressKindP2PKH, ""},
+		{CoinBTC, "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", AddressKindP2SH, ""},
+		{CoinBTC, "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq", AddressKindWitness, ""},
+		{CoinBTC, "bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", AddressKindWitness, ""},
+		{CoinBTC, "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3", "", "checksum"},
+		{CoinBTC, "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", "", ""},
+		{CoinLTC, "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", "", "looks like BCH/BTC"},
+		{CoinETH, "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq", "", "looks like BTC"},
+		{CoinBTC, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "", "looks like ETC/ETH"},
+	}
+
+	for _, tt := range tests {
+		info, err := ParseAddress(tt.tag, tt.address)
+
+		if len(tt.kind) == 0 {
+			if err == nil {
+				t.Errorf("%s %s: parsed as invalid address", tt.tag, tt.address)
+			} else if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s %s: err %v, want %s", tt.tag, tt.address, err, tt.wantErr)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.tag, tt.address, err)
+
+			continue
+		}
+
+		if info.Kind != tt.kind || info.Coin != tt.tag {
+			t.Errorf("%s %s: kind %s coin %s", tt.tag, tt.address, info.Kind, info.Coin)
+		}
+	}
+}
@@ -54,8 +54,37 @@ type replyAddress struct {
 }
 
 type replyGetTransaction struct {
-	Confirmations int64  `json:"confirmations"`
-	BlockHash     string `json:"blockhash"`
+	Confirmations   int64            `json:"confirmations"`
+	BlockHash       string           `json:"blockhash"`
+	WalletConflicts []string         `json:"walletconflicts"`
+	ReplacedBy      string           `json:"replaced_by_txid"`
+	Fee             *decimal.Decimal `json:"fee"` // negative, only for transactions spending wallet outputs
+	Hex             string           `json:"hex"`
+}
+
+type replyRawTxOut struct {
+	Value decimal.Decimal `json:"value"`
+	N     uint32          `json:"n"`
+}
+
+type replyRawTxIn struct {
+	TxID     string         `json:"txid"`
+	Vout     uint32         `json:"vout"`
+	Coinbase string         `json:"coinbase"`
+	PrevOut  *replyRawTxOut `json:"prevout"`
+}
+
+type replyRawTx struct {
+	TxID          string          `json:"txid"`
+	BlockHash     string          `json:"blockhash"`
+	Confirmations int64           `json:"confirmations"`
+	Vin           []replyRawTxIn  `json:"vin"`
+	Vout          []replyRawTxOut `json:"vout"`
+}
+
+type replyBlockHeader struct {
+	Hash   string `json:"hash"`
+	Height int64  `json:"height"`
 }
 
 //BitcoinAPI interface for bitcoind based coins
@@ -65,7 +94,7 @@ type BitcoinAPI struct {
 
 	logID int64
 
-	client *jsonrpcf.Client
+	client *rpcClient
 }
 
 const signerUserName = "nobody"
@@ -73,8 +102,8 @@ const signerUserName = "nobody"
 var signerUID uint64
 var signerGID uint64
 
-func bitcoinTestRPC(URL, address string) error {
-	client := jsonrpcf.NewHTTPClient(URL)
+func bitcoinTestRPC(tag, URL, address string) error {
+	client := newRPCClient(tag, URL)
 	defer client.Close()
 
 	var replyValidate replyAddress
@@ -110,7 +139,7 @@ func InitBitcoin(tag string, config gutils.CoinConfig, testMode bool) error {
 	}
 
 	switch tag {
-	case CoinBTC, CoinBCH, CoinZEC, CoinRVN, CoinDASH, CoinMONA:
+	case CoinBTC, CoinBCH, CoinZEC, CoinRVN, CoinDASH, CoinMONA, CoinLTC, CoinDOGE:
 	default:
 		return errCoinNotSupported
 	}
@@ -132,14 +161,17 @@ func InitBitcoin(tag string, config gutils.CoinConfig, testMode bool) error {
 
 	jsonrpcf.EnableDebug()
 
-	err = bitcoinTestRPC(Coins[tag].URL, Coins[tag].Address)
+	err = bitcoinTestRPC(tag, Coins[tag].URL, Coins[tag].Address)
 	if err != nil {
 		return gutils.FormatErrorSD("RPC", tag, "(%s) test FAILED [%v]", Coins[tag].URL, err)
 	}
 
-	_, err = os.Stat(Coins[tag].B.Signer)
-	if err != nil {
-		return gutils.FormatErrorSD("Signer", tag, "signer [%s] not found", Coins[tag].B.Signer)
+	// ZEC transactions are built and signed in process
+	if tag != CoinZEC {
+		_, err = os.Stat(Coins[tag].B.Signer)
+		if err != nil {
+			return gutils.FormatErrorSD("Signer", tag, "signer [%s] not found", Coins[tag].B.Signer)
+		}
 	}
 
 	Coins[tag].TestTrans = config.TestTransaction
@@ -154,25 +186,11 @@ func NewBitcoinAPI(logID int64, tag string, c *coinInfo) *BitcoinAPI {
 	return &BitcoinAPI{logID: logID, Tag: tag, Coin: c}
 }
 
-//IsValidAddress -
+//IsValidAddress offline check of address version byte, bech32 prefix and checksum
 func (a *BitcoinAPI) IsValidAddress(address string) error {
-	var err error
-	var replyValidate replyAddress
-
-	c := a.client
-
-	if c == nil {
-		c = jsonrpcf.NewHTTPClient(a.Coin.URL)
-		defer c.Close()
-	}
-
-	err = c.Call("validateaddress", []string{address}, &replyValidate)
+	_, err := ParseAddress(a.Tag, address)
 	if err != nil {
-		return gutils.FormatErrorSI("validateaddress", a.logID, "%v", err)
-	}
-
-	if !replyValidate.IsValid {
-		return gutils.FormatErrorI(a.logID, "address [%s] is not valid for %s", address, a.Tag)
+		return gutils.FormatErrorI(a.logID, "address [%s] is not valid for %s: %v", address, a.Tag, err)
 	}
 
 	return nil
@@ -189,7 +207,7 @@ func (a *BitcoinAPI) GetBalance(address string) (decimal.Decimal, error) {
 	var replyUTXOs []UTXO
 	var balance decimal.Decimal
 
-	c := jsonrpcf.NewHTTPClient(a.Coin.URL)
+	c := newRPCClient(a.Tag, a.Coin.URL)
 	defer c.Close()
 
 	err = c.Call("listunspent", []interface{}{a.Coin.B.Confirmations, 9999999, []interface{}{address}}, &replyUTXOs)
@@ -209,7 +227,7 @@ func (a *BitcoinAPI) checkOnlineNode() error {
 	var replyAddress replyAddress
 
 	switch a.Tag {
-	case CoinBTC, CoinMONA:
+	case CoinBTC, CoinMONA, CoinLTC:
 		err = a.client.Call("getaddressinfo", []string{a.Coin.Address}, &replyAddress)
 	default:
 		err = a.client.Call("validateaddress", []string{a.Coin.Address}, &replyAddress)
@@ -234,7 +252,40 @@ func (a *BitcoinAPI) checkOnlineNode() error {
 	return nil
 }
 
-func (a *BitcoinAPI) signTx(unsignedTx string, inputUTXOs []UTXO, height uint64) (string, error) {
+//createSignedTx creates transaction spending inputUTXOs to vOut and signs it with keys
+func (a *BitcoinAPI) createSignedTx(inputUTXOs []UTXO, vOut map[string]decimal.Decimal, keys []*gutils.SecureBuffer) (string, error) {
+	var err error
+	var unsignedTx string
+	var replyBlockChain blockChain
+
+	err = a.client.Call("getblockchaininfo", []interface{}{}, &replyBlockChain)
+	if err != nil {
+		return "", fmt.Errorf("getblockchaininfo: %v", err)
+	}
+
+	metrics().SetNodeHeight(a.Tag, int64(replyBlockChain.Blocks))
+
+	if a.Tag == CoinZEC {
+		signedTx, err := buildZcashTx(inputUTXOs, vOut, keys, replyBlockChain.Blocks)
+		if err != nil {
+			return "", fmt.Errorf("buildZcashTx: %v", err)
+		}
+
+		return signedTx, nil
+	}
+
+	err = a.client.Call("createrawtransaction", []interface{}{inputUTXOs, vOut}, &unsignedTx)
+	if err != nil {
+		return "", fmt.Errorf("createrawtransaction: %v", err)
+	}
+
+	gutils.RemoteLog.PutDebugI(a.logID, "UnsignedTx: %s", unsignedTx)
+
+	return a.signTxKeys(unsignedTx, inputUTXOs, keys)
+}
+
+//signTxKeys signs inputs belonging to different addresses, keys must cover every input
+func (a *BitcoinAPI) signTxKeys(unsignedTx string, inputUTXOs []UTXO, keys []*gutils.SecureBuffer) (string, error) {
 	var err error
 
 	prevTxs, err := json.Marshal(inputUTXOs)
@@ -242,18 +293,30 @@ func (a *BitcoinAPI) signTx(unsignedTx string, inputUTXOs []UTXO, height uint64)
 		return "", fmt.Errorf("json.Marshal: %v", err)
 	}
 
+	// WIF needs no escaping, JSON is built by hand so it can be wiped, signer still gets it as argument
+	privateKeys := []byte{'['}
+	defer func() { gutils.WipeBytes(privateKeys) }()
+
+	for i, key := range keys {
+		if i > 0 {
+			privateKeys = append(privateKeys, ',')
+		}
+
+		privateKeys = append(append(append(privateKeys, '"'), key.Bytes()...), '"')
+	}
+
+	privateKeys = append(privateKeys, ']')
+
 	signCommand := "sign=ALL"
 
 	switch a.Tag {
-	case CoinZEC:
-		signCommand = fmt.Sprintf("sign=%d:ALL", height)
 	case CoinBCH:
 		signCommand = "sign=ALL|FORKID"
 	}
 
 	cmd := exec.Command(a.Coin.B.Signer,
 		unsignedTx,
-		"set=privatekeys:[\""+a.Coin.Key+"\"]",
+		"set=privatekeys:"+string(privateKeys),
 		"set=prevtxs:"+string(prevTxs),
 		signCommand,
 	)
@@ -287,16 +350,65 @@ func (a *BitcoinAPI) signTx(unsignedTx string, inputUTXOs []UTXO, height uint64)
 	return strings.Trim(o.String(), "\n"), nil
 }
 
-//GetRedeemScript -
-func (a *BitcoinAPI) GetRedeemScript(privKey string) (string, error) {
-	var err error
+//inputRedeemScript redeemScript for inputs of address owned by privKey, legacy coins have none
+func (a *BitcoinAPI) inputRedeemScript(privKey *gutils.SecureBuffer) (string, error) {
+	if a.Coin.B.Legacy {
+		return "", nil
+	}
 
-	var redeemScript []byte
+	wif, err := decodeWIFKey(privKey)
+	if err != nil {
+		return "", err
+	}
+	defer wipeWIF(wif)
+
+	return redeemScriptWIF(wif), nil
+}
+
+//requiredFee applies MinFeeRate to fee for transaction of given shape
+func (a *BitcoinAPI) requiredFee(fee decimal.Decimal, inputs, outputs int) decimal.Decimal {
+	if len(a.Coin.B.MinFeeRate) == 0 {
+		return fee
+	}
+
+	minFeeRate, err := decimal.NewFromString(a.Coin.B.MinFeeRate)
+	if err != nil {
+		return fee
+	}
+
+	minFee := feeForSize(a.estimateTxSize(inputs, outputs), minFeeRate)
 
+	if minFee.GreaterThan(fee) {
+		return minFee
+	}
+
+	return fee
+}
+
+//dustLimit zero if coin has no dust rules
+func (a *BitcoinAPI) dustLimit() decimal.Decimal {
+	dust, err := decimal.NewFromString(a.Coin.B.DustLimit)
+	if err != nil {
+		return decimal.Zero
+	}
+
+	return dust
+}
+
+//GetRedeemScript -
+func (a *BitcoinAPI) GetRedeemScript(privKey string) (string, error) {
 	wif, err := btcutil.DecodeWIF(privKey)
 	if err != nil {
 		return "", err
 	}
+	defer wipeWIF(wif)
+
+	return redeemScriptWIF(wif), nil
+}
+
+//redeemScriptWIF P2SH-P2WPKH redeemScript of key
+func redeemScriptWIF(wif *btcutil.WIF) string {
+	var redeemScript []byte
 
 	pubKeyB := wif.PrivKey.PubKey().SerializeCompressed()
 
@@ -304,23 +416,21 @@ func (a *BitcoinAPI) GetRedeemScript(privKey string) (string, error) {
 	redeemScript = append(redeemScript, 20) // size of Hash160
 	redeemScript = append(redeemScript, btcutil.Hash160(pubKeyB)...)
 
-	return hex.EncodeToString(redeemScript), nil
+	return hex.EncodeToString(redeemScript)
 }
 
 //Send -
 func (a *BitcoinAPI) Send(amount decimal.Decimal, addressTo string) (*string, bool, decimal.Decimal, error) {
 	var err error
-	var unsignedTx string
 	var signedTx string
 	var replyTxHash string
 	var replyUTXOs []UTXO
 	var inputUTXOs []UTXO
-	var replyBlockChain blockChain
 	var inputAmount decimal.Decimal
 
 	//	jsonrpc1.JSONRPC_DEBUG = true
 
-	a.client = jsonrpcf.NewHTTPClient(a.Coin.URL)
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
 	defer a.client.Close()
 
 	fee, err := decimal.NewFromString(a.Coin.B.Fee)
@@ -328,6 +438,10 @@ func (a *BitcoinAPI) Send(amount decimal.Decimal, addressTo string) (*string, bo
 		return nil, false, decimal.Zero, fmt.Errorf("fee [%s] is invalid", a.Coin.B.Fee)
 	}
 
+	if amount.LessThan(a.dustLimit()) {
+		return nil, false, decimal.Zero, fmt.Errorf("amount %s is below dust limit %s", amount.String(), a.Coin.B.DustLimit)
+	}
+
 	amountFull := amount.Add(fee)
 
 	gutils.RemoteLog.PutDebugI(a.logID, "%s -> %s,",
@@ -356,28 +470,45 @@ func (a *BitcoinAPI) Send(amount decimal.Decimal, addressTo string) (*string, bo
 		return nil, false, decimal.Zero, fmt.Errorf("listunspent: %v", err)
 	}
 
-	for i := 0; i < len(replyUTXOs); i++ {
-		replyUTXOs[i].RedeemScript, err = a.GetRedeemScript(a.Coin.Key)
-		if err != nil {
-			return nil, false, decimal.Zero, fmt.Errorf("getRedeemScript: %v", err)
-		}
+	reportServiceUTXOs(a.Tag, replyUTXOs)
 
-		inputUTXOs = append(inputUTXOs, replyUTXOs[i])
-		inputAmount = inputAmount.Add(replyUTXOs[i].Amount)
+	key, err := a.Coin.serviceKey()
+	if err != nil {
+		return nil, false, decimal.Zero, err
+	}
+	defer key.Release()
 
-		gutils.RemoteLog.PutDebugI(a.logID, "+INPUT: %s, %s, total %s", replyUTXOs[i].TxID, replyUTXOs[i].Amount.String(), inputAmount.String())
+	redeemScript, err := a.inputRedeemScript(key)
+	if err != nil {
+		return nil, false, decimal.Zero, fmt.Errorf("getRedeemScript: %v", err)
+	}
 
-		if inputAmount.GreaterThanOrEqual(amountFull) {
-			break
-		}
+	for i := 0; i < len(replyUTXOs); i++ {
+		replyUTXOs[i].RedeemScript = redeemScript
+	}
+
+	inputUTXOs, inputAmount, fee, err = a.selectInputs(replyUTXOs, amount, fee, 2)
+	if err != nil {
+		return nil, true, decimal.Zero, err
 	}
 
-	if inputAmount.LessThan(amountFull) {
-		return nil, true, decimal.Zero, fmt.Errorf("not enough unspent funds (%s < %s)", inputAmount.String(), amountFull.String())
+	defer func() { a.Coin.Spends.finish(utxoKeys(inputUTXOs), err == nil && !a.Coin.TestMode) }()
+
+	amountFull = amount.Add(fee)
+
+	for _, v := range inputUTXOs {
+		gutils.RemoteLog.PutDebugI(a.logID, "+INPUT: %s, %s", v.TxID, v.Amount.String())
 	}
 
 	change := inputAmount.Sub(amountFull)
 
+	if change.GreaterThan(decimal.Zero) && change.LessThan(a.dustLimit()) {
+		gutils.RemoteLog.PutDebugI(a.logID, "change %s below dust limit, added to fee", change.String())
+
+		fee = fee.Add(change)
+		change = decimal.Zero
+	}
+
 	VOut := make(map[string]decimal.Decimal)
 
 	VOut[addressTo] = amount
@@ -390,19 +521,7 @@ func (a *BitcoinAPI) Send(amount decimal.Decimal, addressTo string) (*string, bo
 		gutils.RemoteLog.PutDebugI(a.logID, "+OUT(C): %s %s %s", a.Coin.Address, change.String(), a.Tag)
 	}
 
-	err = a.client.Call("createrawtransaction", []interface{}{inputUTXOs, VOut}, &unsignedTx)
-	if err != nil {
-		return nil, false, decimal.Zero, fmt.Errorf("createrawtransaction: %v", err)
-	}
-
-	gutils.RemoteLog.PutDebugI(a.logID, "UnsignedTx: %s", unsignedTx)
-
-	err = a.client.Call("getblockchaininfo", []interface{}{}, &replyBlockChain)
-	if err != nil {
-		return nil, false, decimal.Zero, fmt.Errorf("getblockchaininfo: %v", err)
-	}
-
-	signedTx, err = a.signTx(unsignedTx, inputUTXOs, replyBlockChain.Blocks)
+	signedTx, err = a.createSignedTx(inputUTXOs, VOut, []*gutils.SecureBuffer{key})
 	if err != nil {
 		return nil, false, decimal.Zero, err
 	}
@@ -416,6 +535,8 @@ func (a *BitcoinAPI) Send(amount decimal.Decimal, addressTo string) (*string, bo
 		if err != nil {
 			return nil, false, decimal.Zero, fmt.Errorf("sendrawtransaction:  %v", err)
 		}
+
+		metrics().TxSent(a.Tag, amount, fee)
 	}
 
 	gutils.RemoteLog.PutDebugI(a.logID, "Hash: %s", replyTxHash)
@@ -427,17 +548,15 @@ func (a *BitcoinAPI) Send(amount decimal.Decimal, addressTo string) (*string, bo
 	return &replyTxHash, false, fee, err
 }
 
-//Spend -
-func (a *BitcoinAPI) Spend(addressFrom, addressTo string, inputUTXOs []UTXO, privateKey string, nonce uint64) (*string, bool, decimal.Decimal, decimal.Decimal, error) {
+//Spend sends inputUTXOs less fee to addressTo, they are signed by privateKey or by service key if privateKey is empty
+func (a *BitcoinAPI) Spend(addressFrom, addressTo string, inputUTXOs []UTXO, privateKey *gutils.SecureBuffer, nonce uint64) (*string, bool, decimal.Decimal, decimal.Decimal, error) {
 	var err error
-	var unsignedTx string
 	var signedTx string
 	var replyTxHash string
-	var replyBlockChain blockChain
 
 	//	jsonrpc1.JSONRPC_DEBUG = true
 
-	a.client = jsonrpcf.NewHTTPClient(a.Coin.URL)
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
 	defer a.client.Close()
 
 	fee, err := decimal.NewFromString(a.Coin.B.Fee)
@@ -453,6 +572,12 @@ func (a *BitcoinAPI) Spend(addressFrom, addressTo string, inputUTXOs []UTXO, pri
 		amount = amount.Add(v.Amount)
 	}
 
+	fee = a.requiredFee(fee, len(inputUTXOs), 1)
+
+	if amount.Sub(fee).LessThan(a.dustLimit()) {
+		return nil, false, decimal.Zero, decimal.Zero, fmt.Errorf("amount %s minus fee %s is below dust limit %s", amount.String(), fee.String(), a.Coin.B.DustLimit)
+	}
+
 	gutils.RemoteLog.PutDebugI(a.logID, " -> %s, amount: %s (%s), fee: %s",
 		addressTo,
 		amount.String(), a.Tag,
@@ -464,25 +589,30 @@ func (a *BitcoinAPI) Spend(addressFrom, addressTo string, inputUTXOs []UTXO, pri
 		return nil, false, decimal.Zero, decimal.Zero, err
 	}
 
+	err = a.Coin.Spends.reserve(utxoKeys(inputUTXOs))
+	if err != nil {
+		return nil, true, decimal.Zero, decimal.Zero, err
+	}
+
+	defer func() { a.Coin.Spends.finish(utxoKeys(inputUTXOs), err == nil && !a.Coin.TestMode) }()
+
 	VOut := make(map[string]decimal.Decimal)
 
 	VOut[addressTo] = amount.Sub(fee)
 
 	gutils.RemoteLog.PutDebugI(a.logID, "+OUT(R): %s %s %s", addressTo, VOut[addressTo].String(), a.Tag)
 
-	err = a.client.Call("createrawtransaction", []interface{}{inputUTXOs, VOut}, &unsignedTx)
-	if err != nil {
-		return nil, false, decimal.Zero, decimal.Zero, fmt.Errorf("createrawtransaction: %v", err)
-	}
+	key := privateKey
 
-	gutils.RemoteLog.PutDebugI(a.logID, "UnsignedTx: %s", unsignedTx)
-
-	err = a.client.Call("getblockchaininfo", []interface{}{}, &replyBlockChain)
-	if err != nil {
-		return nil, false, decimal.Zero, decimal.Zero, fmt.Errorf("getblockchaininfo: %v", err)
+	if key.Len() == 0 {
+		key, err = a.Coin.serviceKey()
+		if err != nil {
+			return nil, false, decimal.Zero, decimal.Zero, err
+		}
+		defer key.Release()
 	}
 
-	signedTx, err = a.signTx(unsignedTx, inputUTXOs, replyBlockChain.Blocks)
+	signedTx, err = a.createSignedTx(inputUTXOs, VOut, []*gutils.SecureBuffer{key})
 	if err != nil {
 		return nil, false, decimal.Zero, decimal.Zero, err
 	}
@@ -496,6 +626,8 @@ func (a *BitcoinAPI) Spend(addressFrom, addressTo string, inputUTXOs []UTXO, pri
 		if err != nil {
 			return nil, false, decimal.Zero, decimal.Zero, fmt.Errorf("sendrawtransaction:  %v", err)
 		}
+
+		metrics().TxSent(a.Tag, VOut[addressTo], fee)
 	}
 
 	gutils.RemoteLog.PutDebugI(a.logID, "Hash: %s", replyTxHash)
@@ -510,17 +642,15 @@ func (a *BitcoinAPI) Spend(addressFrom, addressTo string, inputUTXOs []UTXO, pri
 //SendMany -
 func (a *BitcoinAPI) SendMany(wds *Transfers) (*string, bool, error) {
 	var err error
-	var unsignedTx string
 	var signedTx string
 	var replyTxHash string
 	var replyUTXOs []UTXO
 	var inputUTXOs []UTXO
-	var replyBlockChain blockChain
 	var inputAmount decimal.Decimal
 
 	//jsonrpc1.JSONRPC_DEBUG = true
 
-	a.client = jsonrpcf.NewHTTPClient(a.Coin.URL)
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
 	defer a.client.Close()
 
 	fee, err := decimal.NewFromString(a.Coin.B.Fee)
@@ -535,6 +665,10 @@ func (a *BitcoinAPI) SendMany(wds *Transfers) (*string, bool, error) {
 	vOut := make(map[string]decimal.Decimal)
 
 	for i := 0; i < len(*wds); i++ {
+		if (*wds)[i].Amount.LessThan(a.dustLimit()) {
+			return nil, false, gutils.FormatErrorSI("checkDust", (*wds)[i].ID, "amount %s is below dust limit %s", (*wds)[i].Amount.String(), a.Coin.B.DustLimit)
+		}
+
 		amountTotal = amountTotal.Add((*wds)[i].Amount)
 
 		vOut[(*wds)[i].Address] = vOut[(*wds)[i].Address].Add((*wds)[i].Amount)
@@ -546,7 +680,7 @@ func (a *BitcoinAPI) SendMany(wds *Transfers) (*string, bool, error) {
 
 	amountFee := fee.Mul(decimal.New(int64(len(vOut)), 0))
 
-	(*wds)[0].TxFee = amountFee
+	amountSent := amountTotal
 
 	amountTotal = amountTotal.Add(amountFee)
 
@@ -563,47 +697,54 @@ func (a *BitcoinAPI) SendMany(wds *Transfers) (*string, bool, error) {
 		return nil, false, fmt.Errorf("listunspent: %v", err)
 	}
 
-	for i := 0; i < len(replyUTXOs); i++ {
-		replyUTXOs[i].RedeemScript, err = a.GetRedeemScript(a.Coin.Key)
-		if err != nil {
-			return nil, false, fmt.Errorf("getRedeemScript: %v", err)
-		}
+	reportServiceUTXOs(a.Tag, replyUTXOs)
 
-		inputUTXOs = append(inputUTXOs, replyUTXOs[i])
-		inputAmount = inputAmount.Add(replyUTXOs[i].Amount)
+	key, err := a.Coin.serviceKey()
+	if err != nil {
+		return nil, false, err
+	}
+	defer key.Release()
 
-		gutils.RemoteLog.PutDebugS(a.Tag, "+INPUT: %s, %s, total %s", replyUTXOs[i].TxID, replyUTXOs[i].Amount.String(), inputAmount.String())
+	redeemScript, err := a.inputRedeemScript(key)
+	if err != nil {
+		return nil, false, fmt.Errorf("getRedeemScript: %v", err)
+	}
 
-		if inputAmount.GreaterThanOrEqual(amountTotal) {
-			break
-		}
+	for i := 0; i < len(replyUTXOs); i++ {
+		replyUTXOs[i].RedeemScript = redeemScript
 	}
 
-	if inputAmount.LessThan(amountTotal) {
-		return nil, true, fmt.Errorf("not enough unspent funds (%s < %s)", inputAmount.String(), amountTotal.String())
+	inputUTXOs, inputAmount, amountFee, err = a.selectInputs(replyUTXOs, amountSent, amountFee, len(vOut)+1)
+	if err != nil {
+		return nil, true, err
 	}
 
-	change := inputAmount.Sub(amountTotal)
+	defer func() { a.Coin.Spends.finish(utxoKeys(inputUTXOs), err == nil && !a.Coin.TestMode) }()
 
-	if change.GreaterThan(decimal.Zero) {
-		vOut[a.Coin.Address] = change
+	amountTotal = amountSent.Add(amountFee)
 
-		gutils.RemoteLog.PutDebugS(a.Tag, "+OUT(C): %s %s %s", a.Coin.Address, change.String(), a.Tag)
+	for _, v := range inputUTXOs {
+		gutils.RemoteLog.PutDebugS(a.Tag, "+INPUT: %s, %s", v.TxID, v.Amount.String())
 	}
 
-	err = a.client.Call("createrawtransaction", []interface{}{inputUTXOs, vOut}, &unsignedTx)
-	if err != nil {
-		return nil, false, fmt.Errorf("createrawtransaction: %v", err)
+	change := inputAmount.Sub(amountTotal)
+
+	if change.GreaterThan(decimal.Zero) && change.LessThan(a.dustLimit()) {
+		gutils.RemoteLog.PutDebugS(a.Tag, "change %s below dust limit, added to fee", change.String())
+
+		amountFee = amountFee.Add(change)
+		change = decimal.Zero
 	}
 
-	gutils.RemoteLog.PutDebugI(a.logID, "UnsignedTx: %s", unsignedTx)
+	(*wds)[0].TxFee = amountFee
 
-	err = a.client.Call("getblockchaininfo", []interface{}{}, &replyBlockChain)
-	if err != nil {
-		return nil, false, fmt.Errorf("getblockchaininfo: %v", err)
+	if change.GreaterThan(decimal.Zero) {
+		vOut[a.Coin.Address] = change
+
+		gutils.RemoteLog.PutDebugS(a.Tag, "+OUT(C): %s %s %s", a.Coin.Address, change.String(), a.Tag)
 	}
 
-	signedTx, err = a.signTx(unsignedTx, inputUTXOs, replyBlockChain.Blocks)
+	signedTx, err = a.createSignedTx(inputUTXOs, vOut, []*gutils.SecureBuffer{key})
 	if err != nil {
 		return nil, false, err
 	}
@@ -617,6 +758,8 @@ func (a *BitcoinAPI) SendMany(wds *Transfers) (*string, bool, error) {
 		if err != nil {
 			return nil, false, fmt.Errorf("sendrawtransaction: %v", err)
 		}
+
+		metrics().TxSent(a.Tag, amountSent, amountFee)
 	}
 
 	gutils.RemoteLog.PutDebugS(a.Tag, "Hash: %s", replyTxHash)
@@ -628,34 +771,176 @@ func (a *BitcoinAPI) SendMany(wds *Transfers) (*string, bool, error) {
 	return &replyTxHash, false, err
 }
 
-//Check -
-func (a *BitcoinAPI) Check(txHash string, fee decimal.Decimal) (bool, decimal.Decimal, error) {
-	var err error
+//getRawTransaction decodes transaction of block, node without -txindex finds it only by blockHash
+func (a *BitcoinAPI) getRawTransaction(txHash string, verbosity int, blockHash string) (*replyRawTx, error) {
+	var reply replyRawTx
+
+	err := a.client.Call("getrawtransaction", []interface{}{txHash, verbosity, blockHash}, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply, nil
+}
+
+//getWalletTransaction decodes transaction known to node wallet, works without -txindex
+func (a *BitcoinAPI) getWalletTransaction(txHash string) (*replyRawTx, error) {
 	var replyTx replyGetTransaction
+	var reply replyRawTx
 
-	//jsonrpc1.JSONRPC1_DEBUG = true
+	err := a.client.Call("gettransaction", []interface{}{txHash, true}, &replyTx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.client.Call("decoderawtransaction", []interface{}{replyTx.Hex}, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply, nil
+}
+
+//getTxFee sum of inputs minus sum of outputs, inputs without prevout are resolved from wallet transactions
+func (a *BitcoinAPI) getTxFee(tx *replyRawTx) (decimal.Decimal, error) {
+	var amountIn decimal.Decimal
+	var amountOut decimal.Decimal
+
+	prevTxs := make(map[string]*replyRawTx)
+
+	for _, vin := range tx.Vin {
+		if len(vin.Coinbase) > 0 {
+			return decimal.Zero, nil
+		}
 
-	a.client = jsonrpcf.NewHTTPClient(a.Coin.URL)
+		if vin.PrevOut != nil {
+			amountIn = amountIn.Add(vin.PrevOut.Value)
+
+			continue
+		}
+
+		prevTx, ok := prevTxs[vin.TxID]
+		if !ok {
+			var err error
+
+			prevTx, err = a.getWalletTransaction(vin.TxID)
+			if err != nil {
+				return decimal.Zero, fmt.Errorf("gettransaction(%s): %v", vin.TxID, err)
+			}
+
+			prevTxs[vin.TxID] = prevTx
+		}
+
+		if int(vin.Vout) >= len(prevTx.Vout) {
+			return decimal.Zero, fmt.Errorf("prevout %s:%d not found", vin.TxID, vin.Vout)
+		}
+
+		amountIn = amountIn.Add(prevTx.Vout[vin.Vout].Value)
+	}
+
+	for _, vout := range tx.Vout {
+		amountOut = amountOut.Add(vout.Value)
+	}
+
+	if amountIn.LessThan(amountOut) {
+		return decimal.Zero, fmt.Errorf("inputs less then outputs (%s < %s)", amountIn.String(), amountOut.String())
+	}
+
+	return amountIn.Sub(amountOut), nil
+}
+
+//GetTxStatus -
+func (a *BitcoinAPI) GetTxStatus(txHash string) (*TxStatus, error) {
+	var err error
+	var replyTx replyGetTransaction
+	var replyHeader replyBlockHeader
+
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
 	defer a.client.Close()
 
-	err = a.client.Call("gettransaction", []string{txHash}, &replyTx)
+	err = a.client.Call("gettransaction", []interface{}{txHash, true}, &replyTx)
+	if err != nil {
+		return nil, gutils.FormatErrorSI("gettransaction", a.logID, "%v", err)
+	}
+
+	status := &TxStatus{
+		TxHash:        txHash,
+		Confirmations: replyTx.Confirmations,
+		BlockHash:     replyTx.BlockHash,
+		ReplacedBy:    replyTx.ReplacedBy,
+	}
+
+	// negative confirmations means transaction conflicts with one in the chain
+	if replyTx.Confirmations < 0 || len(replyTx.ReplacedBy) > 0 {
+		status.Replaced = true
+
+		if len(status.ReplacedBy) == 0 && len(replyTx.WalletConflicts) > 0 {
+			status.ReplacedBy = replyTx.WalletConflicts[0]
+		}
+
+		return status, nil
+	}
+
+	if len(replyTx.BlockHash) == 0 {
+		return status, nil
+	}
+
+	if replyTx.Fee != nil {
+		status.Fee = replyTx.Fee.Neg()
+	} else {
+		verbosity := 1
+
+		switch a.Tag {
+		case CoinBTC:
+			verbosity = 2 // includes prevout for every input
+		}
+
+		rawTx, err := a.getRawTransaction(txHash, verbosity, replyTx.BlockHash)
+		if err != nil {
+			return nil, gutils.FormatErrorSI("getrawtransaction", a.logID, "%v", err)
+		}
+
+		status.Fee, err = a.getTxFee(rawTx)
+		if err != nil {
+			return nil, gutils.FormatErrorSI("getTxFee", a.logID, "%v", err)
+		}
+	}
+
+	err = a.client.Call("getblockheader", []interface{}{replyTx.BlockHash, true}, &replyHeader)
 	if err != nil {
-		return false, fee, gutils.FormatErrorSI("gettransaction", a.logID, "%v", err)
+		return nil, gutils.FormatErrorSI("getblockheader", a.logID, "%v", err)
 	}
 
-	if replyTx.Confirmations < a.Coin.B.Confirmations {
+	status.BlockHeight = replyHeader.Height
+	status.Success = true
+
+	return status, nil
+}
+
+//Check -
+func (a *BitcoinAPI) Check(txHash string, fee decimal.Decimal) (bool, decimal.Decimal, error) {
+	//jsonrpc1.JSONRPC1_DEBUG = true
+
+	status, err := a.GetTxStatus(txHash)
+	if err != nil {
+		return false, fee, err
+	}
+
+	if status.Replaced {
+		return false, fee, gutils.FormatErrorSI("checkReplaced", a.logID, "transaction replaced by [%s]", status.ReplacedBy)
+	}
+
+	if status.Confirmations < a.Coin.B.Confirmations {
 		return false, fee, gutils.FormatErrorSI("checkConfirmations", a.logID, "not enough confirmations")
 	}
 
-	return true, fee, nil
+	gutils.RemoteLog.PutDebugI(a.logID, "block: %s (%d), fee: %s %s", status.BlockHash, status.BlockHeight, status.Fee.String(), a.Tag)
+
+	return true, status.Fee, nil
 }
 
 func (a *BitcoinAPI) getNetworkParams() *chaincfg.Params {
-	networkParams := &chaincfg.MainNetParams
-	networkParams.PubKeyHashAddrID = a.Coin.B.PubKeyID
-	networkParams.PrivateKeyID = a.Coin.B.PrivKeyID
-	networkParams.ScriptHashAddrID = a.Coin.B.ScriptID
-	return networkParams
+	return coinNetworkParams(a.Coin)
 }
 
 func (a *BitcoinAPI) createPrivateKey(params *chaincfg.Params) (*btcutil.WIF, error) {
@@ -711,12 +996,17 @@ func (a *BitcoinAPI) CreateAccount(privateKey string) (*Account, error) {
 		return nil, err
 	}
 
+	gutils.RemoteLog.PutDebugS(a.Tag, "P2PKH %s", addrPubKeyHash.EncodeAddress())
+
+	if a.Coin.B.Legacy {
+		return &Account{Address: addrPubKeyHash.EncodeAddress(), PrivateKey: wif.String()}, nil
+	}
+
 	addrScriptHash, err := a.getAddressP2SH(wif, params)
 	if err != nil {
 		return nil, err
 	}
 
-	gutils.RemoteLog.PutDebugS(a.Tag, "P2PKH %s", addrPubKeyHash.EncodeAddress())
 	gutils.RemoteLog.PutDebugS(a.Tag, "P2SH %s", addrScriptHash.EncodeAddress())
 
 	acc := Account{
@@ -730,8 +1020,7 @@ func (a *BitcoinAPI) CreateAccount(privateKey string) (*Account, error) {
 
 //SetServiceAccount -
 func (a *BitcoinAPI) SetServiceAccount(address, privKey string) {
-	a.Coin.Address = address
-	a.Coin.Key = privKey
+	setServiceAccount(a.Coin, a.Tag, address, privKey)
 }
 
 //GetAPIType -
@@ -0,0 +1,328 @@
+package coinapi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/seagiv/common/gutils"
+	"github.com/seagiv/foreign/decimal"
+)
+
+// size estimation in bytes (vbytes for segwit coins)
+const (
+	bitcoinTxOverhead      = 11
+	bitcoinOutputSize      = 32
+	bitcoinInputSizeSegWit = 91
+	bitcoinInputSizeLegacy = 148
+
+	bitcoinMaxTxSize = 100000 // standard transaction size limit
+)
+
+var (
+	errFeeRateTooHigh = errors.New("current feerate is higher then allowed for consolidation")
+	errFeeRateUnknown = errors.New("node can't estimate feerate and FeeRate is not set")
+	errNoSavings      = errors.New("consolidation costs more then it saves")
+)
+
+//ConsolidateOptions parameters for Consolidate
+type ConsolidateOptions struct {
+	Addresses []string        // addresses to gather UTXOs from, service address if empty
+	Storage   *gutils.Storage // keys for Addresses other then service address
+	AddressTo string          // destination, service address if empty
+
+	MaxUTXOAmount decimal.Decimal // only UTXOs less or equal are gathered, zero means any
+	MinInputs     int             // do nothing if fewer small UTXOs found
+	MaxInputs     int             // inputs per transaction, limited by bitcoinMaxTxSize
+
+	FeeRate       decimal.Decimal // per 1000 bytes, used if node can't estimate
+	MaxFeeRate    decimal.Decimal // per 1000 bytes, consolidate only if current feerate is lower
+	FutureFeeRate decimal.Decimal // per 1000 bytes, feerate inputs are expected to be spent at otherwise
+
+	DryRun bool
+	Force  bool // send even if projected Savings is not positive
+}
+
+//ConsolidateTx single consolidation transaction
+type ConsolidateTx struct {
+	Inputs []UTXO
+	Amount decimal.Decimal // amount sent to AddressTo
+	Fee    decimal.Decimal
+	Size   int64
+
+	TxHash string
+}
+
+//ConsolidatePlan result of Consolidate, projected values are filled before any transaction is sent
+type ConsolidatePlan struct {
+	FeeRate decimal.Decimal
+
+	Inputs  int
+	Outputs int
+
+	FeeTotal decimal.Decimal
+	Savings  decimal.Decimal // fee saved on future spends minus FeeTotal
+
+	Txs []ConsolidateTx
+}
+
+type replyEstimateFee struct {
+	FeeRate decimal.Decimal `json:"feerate"`
+	Errors  []string        `json:"errors"`
+}
+
+func (a *BitcoinAPI) inputSize() int64 {
+	switch a.Tag {
+	case CoinBTC, CoinMONA, CoinLTC:
+		return bitcoinInputSizeSegWit
+	}
+
+	return bitcoinInputSizeLegacy
+}
+
+func (a *BitcoinAPI) estimateTxSize(inputs, outputs int) int64 {
+	return bitcoinTxOverhead + int64(inputs)*a.inputSize() + int64(outputs)*bitcoinOutputSize
+}
+
+//getFeeRate returns feerate per 1000 bytes estimated by node, fallback is used if node can't estimate
+func (a *BitcoinAPI) getFeeRate(fallback decimal.Decimal) (decimal.Decimal, error) {
+	var reply replyEstimateFee
+
+	err := a.client.Call("estimatesmartfee", []interface{}{a.Coin.B.Confirmations}, &reply)
+	if err == nil && reply.FeeRate.GreaterThan(decimal.Zero) {
+		return reply.FeeRate, nil
+	}
+
+	if fallback.LessThanOrEqual(decimal.Zero) {
+		if err == nil {
+			err = fmt.Errorf("%v", reply.Errors)
+		}
+
+		return decimal.Zero, fmt.Errorf("%v (estimatesmartfee: %v)", errFeeRateUnknown, err)
+	}
+
+	gutils.RemoteLog.PutDebugS(a.Tag, "estimatesmartfee unavailable, using feerate %s", fallback.String())
+
+	return fallback, nil
+}
+
+func feeForSize(size int64, feeRate decimal.Decimal) decimal.Decimal {
+	return feeRate.Mul(decimal.New(size, 0)).Div(decimal.New(1000, 0)).Round(8)
+}
+
+//consolidateKeys returns keys of addresses, caller must release them by releaseKeys
+func (a *BitcoinAPI) consolidateKeys(opts *ConsolidateOptions) (map[string]*gutils.SecureBuffer, error) {
+	var key *gutils.SecureBuffer
+	var err error
+
+	keys := make(map[string]*gutils.SecureBuffer)
+
+	for _, address := range opts.Addresses {
+		if address == a.Coin.Address {
+			key, err = a.Coin.serviceKey()
+		} else if opts.Storage == nil {
+			err = fmt.Errorf("storage required for key of [%s]", address)
+		} else {
+			key, err = opts.Storage.GetAddressKey(a.Tag, address)
+		}
+
+		if err != nil {
+			releaseKeys(keys)
+
+			return nil, err
+		}
+
+		keys[address] = key
+	}
+
+	return keys, nil
+}
+
+//Consolidate gathers small UTXOs into fewer outputs, with DryRun only the plan is returned
+func (a *BitcoinAPI) Consolidate(opts ConsolidateOptions) (*ConsolidatePlan, error) {
+	var err error
+	var replyUTXOs []UTXO
+
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
+	defer a.client.Close()
+
+	if len(opts.Addresses) == 0 {
+		opts.Addresses = []string{a.Coin.Address}
+	}
+
+	if len(opts.AddressTo) == 0 {
+		opts.AddressTo = a.Coin.Address
+	}
+
+	if opts.MinInputs < 2 {
+		opts.MinInputs = 2
+	}
+
+	maxInputs := int((bitcoinMaxTxSize - a.estimateTxSize(0, 1)) / a.inputSize())
+
+	if opts.MaxInputs <= 0 || opts.MaxInputs > maxInputs {
+		opts.MaxInputs = maxInputs
+	}
+
+	keys, err := a.consolidateKeys(&opts)
+	if err != nil {
+		return nil, gutils.FormatErrorSI("consolidateKeys", a.logID, "%v", err)
+	}
+	defer releaseKeys(keys)
+
+	err = a.IsValidAddress(opts.AddressTo)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ConsolidatePlan{}
+
+	plan.FeeRate, err = a.getFeeRate(opts.FeeRate)
+	if err != nil {
+		return nil, gutils.FormatErrorSI("getFeeRate", a.logID, "%v", err)
+	}
+
+	if opts.MaxFeeRate.GreaterThan(decimal.Zero) && plan.FeeRate.GreaterThan(opts.MaxFeeRate) {
+		return plan, gutils.FormatErrorSI("checkFeeRate", a.logID, "%v (%s > %s)", errFeeRateTooHigh, plan.FeeRate.String(), opts.MaxFeeRate.String())
+	}
+
+	futureFeeRate := opts.FutureFeeRate
+	if futureFeeRate.LessThan(plan.FeeRate) {
+		futureFeeRate = plan.FeeRate
+	}
+
+	err = a.client.Call("listunspent", []interface{}{a.Coin.B.Confirmations, 9999999, opts.Addresses}, &replyUTXOs)
+	if err != nil {
+		return nil, fmt.Errorf("listunspent: %v", err)
+	}
+
+	replyUTXOs = a.Coin.Spends.available(replyUTXOs)
+
+	var small []UTXO
+
+	for _, u := range replyUTXOs {
+		if opts.MaxUTXOAmount.GreaterThan(decimal.Zero) && u.Amount.GreaterThan(opts.MaxUTXOAmount) {
+			continue
+		}
+
+		u.RedeemScript, err = a.inputRedeemScript(keys[u.Address])
+		if err != nil {
+			return nil, fmt.Errorf("getRedeemScript(%s): %v", u.Address, err)
+		}
+
+		small = append(small, u)
+	}
+
+	if len(small) < opts.MinInputs {
+		gutils.RemoteLog.PutDebugI(a.logID, "nothing to consolidate, %d small UTXOs found", len(small))
+
+		return plan, nil
+	}
+
+	sort.Slice(small, func(i, j int) bool { return small[i].Amount.LessThan(small[j].Amount) })
+
+	for i := 0; i < len(small); i += opts.MaxInputs {
+		j := i + opts.MaxInputs
+		if j > len(small) {
+			j = len(small)
+		}
+
+		if j-i < 2 {
+			break // single input gains nothing
+		}
+
+		tx := ConsolidateTx{Inputs: small[i:j]}
+
+		var amount decimal.Decimal
+
+		for _, u := range tx.Inputs {
+			amount = amount.Add(u.Amount)
+		}
+
+		tx.Size = a.estimateTxSize(len(tx.Inputs), 1)
+		tx.Fee = feeForSize(tx.Size, plan.FeeRate)
+		tx.Amount = amount.Sub(tx.Fee)
+
+		if tx.Amount.LessThanOrEqual(tx.Fee) {
+			gutils.RemoteLog.PutDebugI(a.logID, "skipping %d inputs, amount %s does not cover fee %s", len(tx.Inputs), amount.String(), tx.Fee.String())
+
+			continue
+		}
+
+		plan.Txs = append(plan.Txs, tx)
+		plan.Inputs += len(tx.Inputs)
+		plan.Outputs++
+		plan.FeeTotal = plan.FeeTotal.Add(tx.Fee)
+	}
+
+	plan.Savings = feeForSize(int64(plan.Inputs-plan.Outputs)*a.inputSize(), futureFeeRate).Sub(plan.FeeTotal)
+
+	gutils.RemoteLog.PutInfoSI(a.Tag, a.logID, "consolidate %d inputs -> %d outputs, feerate %s, fee %s, projected savings %s",
+		plan.Inputs, plan.Outputs,
+		plan.FeeRate.String(),
+		plan.FeeTotal.String(),
+		plan.Savings.String(),
+	)
+
+	if opts.DryRun || len(plan.Txs) == 0 {
+		return plan, nil
+	}
+
+	if !opts.Force && plan.Savings.LessThanOrEqual(decimal.Zero) {
+		return plan, gutils.FormatErrorSI("checkSavings", a.logID, "%v (savings %s)", errNoSavings, plan.Savings.String())
+	}
+
+	err = a.checkOnlineNode()
+	if err != nil {
+		return plan, err
+	}
+
+	for i := range plan.Txs {
+		tx := &plan.Txs[i]
+
+		var signedTx string
+
+		inputKeys := []*gutils.SecureBuffer{}
+
+		for _, u := range tx.Inputs {
+			if !isKeyIn(keys[u.Address], inputKeys) {
+				inputKeys = append(inputKeys, keys[u.Address])
+			}
+		}
+
+		vOut := map[string]decimal.Decimal{opts.AddressTo: tx.Amount}
+
+		reserved := utxoKeys(tx.Inputs)
+
+		err = a.Coin.Spends.reserve(reserved)
+		if err != nil {
+			return plan, err
+		}
+
+		signedTx, err = a.createSignedTx(tx.Inputs, vOut, inputKeys)
+		if err != nil {
+			a.Coin.Spends.release(reserved)
+
+			return plan, err
+		}
+
+		if a.Coin.TestMode {
+			tx.TxHash = a.Coin.TestTrans
+		} else {
+			err = a.client.Call("sendrawtransaction", []interface{}{signedTx}, &tx.TxHash)
+			if err != nil {
+				a.Coin.Spends.release(reserved)
+
+				return plan, fmt.Errorf("sendrawtransaction: %v", err)
+			}
+
+			metrics().TxSent(a.Tag, tx.Amount, tx.Fee)
+		}
+
+		a.Coin.Spends.finish(reserved, !a.Coin.TestMode)
+
+		gutils.RemoteLog.PutDebugI(a.logID, "Hash: %s, inputs: %d, amount: %s, fee: %s", tx.TxHash, len(tx.Inputs), tx.Amount.String(), tx.Fee.String())
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,143 @@
+package coinapi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/seagiv/foreign/decimal"
+)
+
+type replySinceBlockTx struct {
+	TxID          string          `json:"txid"`
+	Address       string          `json:"address"`
+	Category      string          `json:"category"`
+	Amount        decimal.Decimal `json:"amount"`
+	Fee           decimal.Decimal `json:"fee"`
+	Confirmations int64           `json:"confirmations"`
+	BlockHash     string          `json:"blockhash"`
+	BlockHeight   int64           `json:"blockheight"`
+	BlockTime     int64           `json:"blocktime"`
+}
+
+type replySinceBlock struct {
+	Transactions []replySinceBlockTx `json:"transactions"`
+}
+
+//blockHeight returns height of block, heights are cached as wallet entries repeat block hashes
+func (a *BitcoinAPI) blockHeight(hash string, cache map[string]int64) (int64, error) {
+	if h, ok := cache[hash]; ok {
+		return h, nil
+	}
+
+	var replyHeader replyBlockHeader
+
+	err := a.client.Call("getblockheader", []interface{}{hash, true}, &replyHeader)
+	if err != nil {
+		return 0, err
+	}
+
+	cache[hash] = replyHeader.Height
+
+	return replyHeader.Height, nil
+}
+
+//History returns mined wallet transactions of address in [fromHeight, toHeight], address must be watched by node wallet
+//outgoing entries are known for service address only as wallet does not track inputs of watch-only addresses
+func (a *BitcoinAPI) History(address string, fromHeight, toHeight int64) ([]HistoryEntry, error) {
+	var err error
+	var sinceBlock string
+	var replySince replySinceBlock
+
+	if toHeight < fromHeight {
+		return nil, fmt.Errorf("invalid height range %d..%d", fromHeight, toHeight)
+	}
+
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
+	defer a.client.Close()
+
+	if fromHeight > 0 {
+		err = a.client.Call("getblockhash", []interface{}{fromHeight - 1}, &sinceBlock)
+		if err != nil {
+			return nil, fmt.Errorf("getblockhash: %v", err)
+		}
+	}
+
+	err = a.client.Call("listsinceblock", []interface{}{sinceBlock, 1, true}, &replySince)
+	if err != nil {
+		return nil, fmt.Errorf("listsinceblock: %v", err)
+	}
+
+	isService := strings.EqualFold(address, a.Coin.Address)
+
+	heights := make(map[string]int64)
+	feeTaken := make(map[string]bool)
+	sent := make(map[string]bool)
+
+	for _, tx := range replySince.Transactions {
+		if tx.Category == "send" {
+			sent[tx.TxID] = true
+		}
+	}
+
+	var entries []HistoryEntry
+
+	for _, tx := range replySince.Transactions {
+		if tx.Confirmations <= 0 || len(tx.BlockHash) == 0 {
+			continue
+		}
+
+		entry := HistoryEntry{TxHash: tx.TxID, Time: tx.BlockTime}
+
+		switch tx.Category {
+		case "receive", "generate":
+			// receiving side of send to self is reported by send entry
+			if tx.Address != address || (isService && sent[tx.TxID]) {
+				continue
+			}
+
+			entry.Direction = HistoryIn
+			entry.Amount = tx.Amount
+
+		case "send":
+			if !isService {
+				continue
+			}
+
+			entry.Direction = HistoryOut
+			entry.Amount = tx.Amount.Neg()
+			entry.Counterparty = tx.Address
+
+			if tx.Address == address {
+				entry.Direction = HistorySelf
+			}
+
+			// fee is repeated in every output of the same transaction
+			if !feeTaken[tx.TxID] {
+				entry.Fee = tx.Fee.Neg()
+				feeTaken[tx.TxID] = true
+			}
+
+		default:
+			continue
+		}
+
+		entry.Height = tx.BlockHeight
+
+		if entry.Height == 0 {
+			entry.Height, err = a.blockHeight(tx.BlockHash, heights)
+			if err != nil {
+				return nil, fmt.Errorf("getblockheader: %v", err)
+			}
+		}
+
+		if entry.Height < fromHeight || entry.Height > toHeight {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sortHistory(entries)
+
+	return entries, nil
+}
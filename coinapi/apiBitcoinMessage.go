@@ -0,0 +1,136 @@
+package coinapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// address kinds, value is BIP137 header base for compressed keys
+const (
+	addressP2PKH  = 31
+	addressP2SH   = 35 // P2SH-P2WPKH
+	addressP2WPKH = 39
+)
+
+//addressHash decodes address to its hash160 and kind
+func (a *BitcoinAPI) addressHash(address string) ([]byte, int, error) {
+	info, err := ParseAddress(a.Tag, address)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch {
+	case info.Kind == AddressKindP2PKH:
+		return info.Hash, addressP2PKH, nil
+	case info.Kind == AddressKindP2SH:
+		return info.Hash, addressP2SH, nil
+	case info.WitnessVersion == 0 && len(info.Hash) == 20:
+		return info.Hash, addressP2WPKH, nil
+	}
+
+	return nil, 0, fmt.Errorf("unsupported witness program")
+}
+
+//messageHash double sha256 of magic prefixed message, both serialized as var strings
+func (a *BitcoinAPI) messageHash(message string) []byte {
+	var b bytes.Buffer
+
+	wire.WriteVarString(&b, 0, a.Coin.B.MessageMagic)
+	wire.WriteVarString(&b, 0, message)
+
+	return chainhash.DoubleHashB(b.Bytes())
+}
+
+//pubKeyMatches checks if pubKey owns address of given kind
+func pubKeyMatches(pubKey []byte, hash []byte, kind int) bool {
+	pubKeyHash := btcutil.Hash160(pubKey)
+
+	if kind == addressP2SH {
+		redeemScript := append([]byte{0x00, 0x14}, pubKeyHash...)
+
+		return bytes.Equal(btcutil.Hash160(redeemScript), hash)
+	}
+
+	return bytes.Equal(pubKeyHash, hash)
+}
+
+//SignMessage -
+func (a *BitcoinAPI) SignMessage(address, message string) (string, error) {
+	key, err := getAddressKey(a.Coin, a.Tag, address)
+	if err != nil {
+		return "", err
+	}
+	defer key.Release()
+
+	wif, err := decodeWIFKey(key)
+	if err != nil {
+		return "", fmt.Errorf("DecodeWIF: %v", err)
+	}
+	defer wipeWIF(wif)
+
+	hash, kind, err := a.addressHash(address)
+	if err != nil {
+		return "", fmt.Errorf("address [%s] is not valid for %s: %v", address, a.Tag, err)
+	}
+
+	if !pubKeyMatches(wif.SerializePubKey(), hash, kind) {
+		return "", fmt.Errorf("key does not match address [%s]", address)
+	}
+
+	sig, err := btcec.SignCompact(btcec.S256(), wif.PrivKey, a.messageHash(message), wif.CompressPubKey)
+	if err != nil {
+		return "", fmt.Errorf("SignCompact: %v", err)
+	}
+
+	if wif.CompressPubKey {
+		sig[0] = byte(kind) + (sig[0]-27)&3 // recovery id with BIP137 header for address kind
+	}
+
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+//VerifyMessage -
+func (a *BitcoinAPI) VerifyMessage(address, message, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) != 65 {
+		return fmt.Errorf("invalid signature encoding")
+	}
+
+	hash, kind, err := a.addressHash(address)
+	if err != nil {
+		return fmt.Errorf("address [%s] is not valid for %s: %v", address, a.Tag, err)
+	}
+
+	// recovery works on 27..34 headers only
+	header := sig[0]
+
+	switch {
+	case header >= 27 && header <= 34:
+	case header >= 35 && header <= 42:
+		sig[0] = 31 + (header-35)&3
+	default:
+		return fmt.Errorf("invalid signature header %d", header)
+	}
+
+	pubKey, compressed, err := btcec.RecoverCompact(btcec.S256(), sig, a.messageHash(message))
+	if err != nil {
+		return fmt.Errorf("RecoverCompact: %v", err)
+	}
+
+	pubKeyB := pubKey.SerializeUncompressed()
+	if compressed {
+		pubKeyB = pubKey.SerializeCompressed()
+	}
+
+	if !pubKeyMatches(pubKeyB, hash, kind) {
+		return fmt.Errorf("signature does not match address [%s]", address)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package coinapi
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/seagiv/foreign/decimal"
+)
+
+const snapshotTxCount = 1000
+
+type replyListTransaction struct {
+	TxID          string          `json:"txid"`
+	Category      string          `json:"category"`
+	Amount        decimal.Decimal `json:"amount"`
+	Fee           decimal.Decimal `json:"fee"`
+	Confirmations int64           `json:"confirmations"`
+	Time          int64           `json:"time"`
+	Abandoned     bool            `json:"abandoned"`
+}
+
+//Snapshot returns balances, unspent outputs and pending outgoing transactions of service address
+func (a *BitcoinAPI) Snapshot() (*Snapshot, error) {
+	var err error
+	var replyUTXOs []UTXO
+	var replyBlockChain blockChain
+	var replyTxs []replyListTransaction
+
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
+	defer a.client.Close()
+
+	s := &Snapshot{Coin: a.Tag, Address: a.Coin.Address, Time: time.Now().UTC()}
+
+	err = a.client.Call("getblockchaininfo", []interface{}{}, &replyBlockChain)
+	if err != nil {
+		return nil, fmt.Errorf("getblockchaininfo: %v", err)
+	}
+
+	s.Height = int64(replyBlockChain.Blocks)
+
+	err = a.client.Call("listunspent", []interface{}{0, 9999999, []interface{}{a.Coin.Address}}, &replyUTXOs)
+	if err != nil {
+		return nil, fmt.Errorf("listunspent: %v", err)
+	}
+
+	for _, u := range replyUTXOs {
+		if int64(u.Confirmations) >= a.Coin.B.Confirmations {
+			s.Confirmed = s.Confirmed.Add(u.Amount)
+		} else {
+			s.Unconfirmed = s.Unconfirmed.Add(u.Amount)
+		}
+
+		s.UTXOs = append(s.UTXOs, SnapshotUTXO{TxID: u.TxID, Vout: u.Vout, Amount: u.Amount, AgeBlocks: u.Confirmations})
+	}
+
+	sort.Slice(s.UTXOs, func(i, j int) bool {
+		return s.UTXOs[i].AgeBlocks > s.UTXOs[j].AgeBlocks
+	})
+
+	err = a.client.Call("listtransactions", []interface{}{"*", snapshotTxCount, 0, true}, &replyTxs)
+	if err != nil {
+		return nil, fmt.Errorf("listtransactions: %v", err)
+	}
+
+	pending := make(map[string]*SnapshotTx)
+
+	for _, tx := range replyTxs {
+		if tx.Category != "send" || tx.Confirmations != 0 || tx.Abandoned {
+			continue
+		}
+
+		// one entry per output, fee is repeated in each
+		if pending[tx.TxID] == nil {
+			pending[tx.TxID] = &SnapshotTx{TxHash: tx.TxID, Fee: tx.Fee.Neg(), Time: tx.Time}
+		}
+
+		pending[tx.TxID].Amount = pending[tx.TxID].Amount.Add(tx.Amount.Neg())
+	}
+
+	for _, p := range pending {
+		s.Pending = append(s.Pending, *p)
+	}
+
+	sort.Slice(s.Pending, func(i, j int) bool {
+		return s.Pending[i].Time < s.Pending[j].Time
+	})
+
+	metrics().SetNodeHeight(a.Tag, s.Height)
+
+	return s, nil
+}
@@ -0,0 +1,420 @@
+package coinapi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/seagiv/common/gutils"
+	"github.com/seagiv/foreign/decimal"
+)
+
+const (
+	testUnsignedTx = "02000000unsigned"
+	testSignedTx   = "02000000signed0000000000000000000000000000000000000000"
+	testTxHash     = "9f2c45a12db5a2b1e6e7c1d0e1e1c8f0a1b2c3d4e5f60718293a4b5c6d7e8f90"
+)
+
+//mockNode bitcoind JSON-RPC mock, handlers are keyed by method, every call is recorded
+type mockNode struct {
+	sync.Mutex
+
+	handlers map[string]func(params []json.RawMessage) (interface{}, error)
+	calls    map[string][][]json.RawMessage
+}
+
+func newMockNode(t *testing.T) (*mockNode, *httptest.Server) {
+	n := &mockNode{handlers: make(map[string]func([]json.RawMessage) (interface{}, error)), calls: make(map[string][][]json.RawMessage)}
+
+	server := httptest.NewServer(http.HandlerFunc(n.serve))
+	t.Cleanup(server.Close)
+
+	return n, server
+}
+
+func (n *mockNode) handle(method string, f func(params []json.RawMessage) (interface{}, error)) {
+	n.handlers[method] = f
+}
+
+func (n *mockNode) reply(method string, result interface{}) {
+	n.handle(method, func([]json.RawMessage) (interface{}, error) { return result, nil })
+}
+
+func (n *mockNode) called(method string) [][]json.RawMessage {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.calls[method]
+}
+
+func (n *mockNode) serve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     interface{}       `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	n.Lock()
+	n.calls[req.Method] = append(n.calls[req.Method], req.Params)
+	n.Unlock()
+
+	reply := map[string]interface{}{"id": req.ID, "result": nil, "error": nil}
+
+	h, ok := n.handlers[req.Method]
+	if !ok {
+		reply["error"] = map[string]interface{}{"code": -32601, "message": "Method not found"}
+	} else {
+		result, err := h(req.Params)
+		if err != nil {
+			reply["error"] = map[string]interface{}{"code": -26, "message": err.Error()}
+		} else {
+			reply["result"] = result
+		}
+	}
+
+	json.NewEncoder(w).Encode(reply)
+}
+
+//writeSigner writes stub signer script storing its arguments one per line next to itself
+func writeSigner(t *testing.T, body string) string {
+	fileName := filepath.Join(t.TempDir(), "signer")
+
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > \"$0.args\"\n" + body + "\n"
+
+	err := ioutil.WriteFile(fileName, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fileName
+}
+
+func signerArgs(t *testing.T, signer string) []string {
+	data, err := ioutil.ReadFile(signer + ".args")
+	if err != nil {
+		t.Fatalf("signer not called: %v", err)
+	}
+
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+}
+
+//setupBitcoinTest points coin to mock node and stub signer with fresh service account, returns API and
+//account of recipient
+func setupBitcoinTest(t *testing.T, tag string, signerBody string) (*BitcoinAPI, *mockNode, *Account, string) {
+	if os.Getuid() != 0 {
+		t.Skip("signer is run with credentials of its own user, test needs root")
+	}
+
+	node, server := newMockNode(t)
+
+	c := Coins[tag]
+	a := NewBitcoinAPI(1, tag, c)
+
+	service, err := a.CreateAccount("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipient, err := a.CreateAccount("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := writeSigner(t, signerBody)
+
+	signerUID, signerGID = uint64(os.Getuid()), uint64(os.Getgid())
+
+	c.URL = server.URL
+	c.B.Signer = signer
+	c.TestMode = false
+	a.SetServiceAccount(service.Address, service.PrivateKey)
+
+	t.Cleanup(func() {
+		c.setAccount("", nil)
+		c.URL = ""
+		c.B.Signer = ""
+	})
+
+	node.reply("getaddressinfo", replyAddress{IsValid: true, Address: service.Address, IsWatchOnly: true})
+	node.reply("validateaddress", replyAddress{IsValid: true, Address: service.Address, IsWatchOnly: true})
+	node.reply("getblockchaininfo", blockChain{Chain: "main", Blocks: 2500000})
+	node.reply("createrawtransaction", testUnsignedTx)
+	node.reply("sendrawtransaction", testTxHash)
+
+	return a, node, recipient, service.PrivateKey
+}
+
+func dec(s string) decimal.Decimal {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return d
+}
+
+func testUTXOs(address, prefix string, amounts ...string) []UTXO {
+	var utxos []UTXO
+
+	for i, amount := range amounts {
+		utxos = append(utxos, UTXO{
+			TxID:          strings.Repeat(prefix, 64/len(prefix)),
+			Vout:          uint32(i),
+			Address:       address,
+			ScriptPubKey:  "a914" + strings.Repeat("00", 20) + "87",
+			Amount:        dec(amount),
+			Confirmations: 10,
+			Spendable:     false,
+		})
+	}
+
+	return utxos
+}
+
+//createdTx decodes inputs and outputs passed to createrawtransaction
+func createdTx(t *testing.T, node *mockNode) ([]UTXO, map[string]decimal.Decimal) {
+	var inputs []UTXO
+	var outputs map[string]decimal.Decimal
+
+	calls := node.called("createrawtransaction")
+	if len(calls) != 1 {
+		t.Fatalf("createrawtransaction called %d times", len(calls))
+	}
+
+	err := json.Unmarshal(calls[0][0], &inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(calls[0][1], &outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return inputs, outputs
+}
+
+func sumUTXOs(utxos []UTXO) decimal.Decimal {
+	sum := decimal.Zero
+
+	for _, u := range utxos {
+		sum = sum.Add(u.Amount)
+	}
+
+	return sum
+}
+
+func TestBitcoinSendLitecoin(t *testing.T) {
+	a, node, recipient, wif := setupBitcoinTest(t, CoinLTC, "echo "+testSignedTx)
+
+	node.reply("listunspent", testUTXOs(a.Coin.Address, "a1", "0.2", "0.5"))
+
+	txHash, retry, fee, err := a.Send(dec("0.3"), recipient.Address)
+	if err != nil {
+		t.Fatalf("Send: %v (retry %v)", err, retry)
+	}
+
+	if *txHash != testTxHash {
+		t.Errorf("txHash %s, want %s", *txHash, testTxHash)
+	}
+
+	if !fee.Equal(dec(a.Coin.B.Fee)) {
+		t.Errorf("fee %s, want %s", fee, a.Coin.B.Fee)
+	}
+
+	inputs, outputs := createdTx(t, node)
+
+	if len(inputs) != 2 {
+		t.Fatalf("%d inputs, want 2", len(inputs))
+	}
+
+	redeemScript, _ := a.GetRedeemScript(wif)
+
+	for _, u := range inputs {
+		if u.RedeemScript != redeemScript {
+			t.Errorf("input %s:%d redeemScript [%s], want [%s]", u.TxID, u.Vout, u.RedeemScript, redeemScript)
+		}
+	}
+
+	if !outputs[recipient.Address].Equal(dec("0.3")) {
+		t.Errorf("recipient gets %s", outputs[recipient.Address])
+	}
+
+	change := sumUTXOs(inputs).Sub(dec("0.3")).Sub(fee)
+
+	if !outputs[a.Coin.Address].Equal(change) {
+		t.Errorf("change %s, want %s", outputs[a.Coin.Address], change)
+	}
+
+	args := signerArgs(t, a.Coin.B.Signer)
+
+	if len(args) != 4 {
+		t.Fatalf("signer args %q", args)
+	}
+
+	if args[0] != testUnsignedTx {
+		t.Errorf("signer got tx [%s]", args[0])
+	}
+
+	if args[1] != `set=privatekeys:["`+wif+`"]` {
+		t.Errorf("signer got keys [%s]", args[1])
+	}
+
+	var prevTxs []UTXO
+
+	err = json.Unmarshal([]byte(strings.TrimPrefix(args[2], "set=prevtxs:")), &prevTxs)
+	if err != nil || len(prevTxs) != 2 || prevTxs[0].RedeemScript != redeemScript {
+		t.Errorf("signer got prevtxs [%s]: %v", args[2], err)
+	}
+
+	if args[3] != "sign=ALL" {
+		t.Errorf("signer got [%s]", args[3])
+	}
+
+	sent := node.called("sendrawtransaction")
+	if len(sent) != 1 || string(sent[0][0]) != `"`+testSignedTx+`"` {
+		t.Errorf("sendrawtransaction got %s", sent)
+	}
+}
+
+func TestBitcoinSendDogecoin(t *testing.T) {
+	a, node, recipient, _ := setupBitcoinTest(t, CoinDOGE, "echo "+testSignedTx)
+
+	node.reply("listunspent", testUTXOs(a.Coin.Address, "d0", "3", "40"))
+
+	_, _, _, err := a.Send(dec("0.5"), recipient.Address)
+	if err == nil || !strings.Contains(err.Error(), "dust") {
+		t.Errorf("amount below dust limit sent, err %v", err)
+	}
+
+	if len(node.called("listunspent")) != 0 {
+		t.Errorf("node asked for amount below dust limit")
+	}
+
+	txHash, _, fee, err := a.Send(dec("10"), recipient.Address)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if *txHash != testTxHash {
+		t.Errorf("txHash %s", *txHash)
+	}
+
+	if len(node.called("validateaddress")) == 0 || len(node.called("getaddressinfo")) != 0 {
+		t.Errorf("DOGE node must be checked by validateaddress")
+	}
+
+	inputs, outputs := createdTx(t, node)
+
+	for _, u := range inputs {
+		if len(u.RedeemScript) > 0 {
+			t.Errorf("legacy input %s:%d has redeemScript", u.TxID, u.Vout)
+		}
+	}
+
+	minFee := a.requiredFee(dec(a.Coin.B.Fee), len(inputs), 2)
+
+	if fee.LessThan(minFee) || fee.LessThan(dec(a.Coin.B.Fee)) {
+		t.Errorf("fee %s below minimum %s", fee, minFee)
+	}
+
+	total := decimal.Zero
+
+	for _, amount := range outputs {
+		total = total.Add(amount)
+	}
+
+	if !sumUTXOs(inputs).Sub(total).Equal(fee) {
+		t.Errorf("inputs %s - outputs %s != fee %s", sumUTXOs(inputs), total, fee)
+	}
+}
+
+func TestBitcoinSendSignerError(t *testing.T) {
+	a, node, recipient, _ := setupBitcoinTest(t, CoinLTC, "echo 'error: invalid private key' >&2\nexit 1")
+
+	node.reply("listunspent", testUTXOs(a.Coin.Address, "e2", "1"))
+
+	_, _, _, err := a.Send(dec("0.1"), recipient.Address)
+	if err == nil || !strings.Contains(err.Error(), "invalid private key") {
+		t.Fatalf("signer error not reported, err %v", err)
+	}
+
+	if len(node.called("sendrawtransaction")) != 0 {
+		t.Errorf("transaction sent after signer failed")
+	}
+
+	// reservation is dropped so the same output can be spent again
+	_, _, _, err = a.selectInputs(testUTXOs(a.Coin.Address, "e2", "1"), dec("0.1"), decimal.Zero, 2)
+	if err != nil {
+		t.Errorf("output stays reserved: %v", err)
+	}
+}
+
+func TestBitcoinSendNodeOffline(t *testing.T) {
+	a, node, recipient, _ := setupBitcoinTest(t, CoinLTC, "echo "+testSignedTx)
+
+	node.reply("getaddressinfo", replyAddress{IsValid: true})
+
+	_, _, _, err := a.Send(dec("0.1"), recipient.Address)
+	if err == nil {
+		t.Fatal("sent from address unknown to node")
+	}
+
+	if len(node.called("listunspent")) != 0 || len(node.called("createrawtransaction")) != 0 {
+		t.Errorf("node used after address check failed")
+	}
+}
+
+func TestBitcoinSpendKey(t *testing.T) {
+	a, _, recipient, wif := setupBitcoinTest(t, CoinLTC, "echo "+testSignedTx)
+
+	deposit, err := a.CreateAccount("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := gutils.NewSecureBufferString(deposit.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Release()
+
+	tests := []struct {
+		key    *gutils.SecureBuffer
+		wif    string
+		prefix string
+	}{
+		{key, deposit.PrivateKey, "b1"},
+		{nil, wif, "b2"}, // empty key spends by service key
+	}
+
+	for _, tt := range tests {
+		_, _, _, _, err = a.Spend(deposit.Address, recipient.Address, testUTXOs(deposit.Address, tt.prefix, "0.5"), tt.key, 0)
+		if err != nil {
+			t.Fatalf("Spend: %v", err)
+		}
+
+		args := signerArgs(t, a.Coin.B.Signer)
+
+		if len(args) < 2 || args[1] != `set=privatekeys:["`+tt.wif+`"]` {
+			t.Errorf("signer got %q, want key %s", args, tt.wif)
+		}
+	}
+
+	if key.Len() == 0 {
+		t.Error("privateKey of caller released")
+	}
+}
@@ -20,8 +20,8 @@ import (
 	"github.com/seagiv/foreign/jsonrpcf"
 )
 
-const ethGasLimit = 60000
-const ethGasLimitStrict = 21000
+const ethGasLimitStrict = 21000 // plain transfer to externally owned account
+const ethGasMarginDefault = 120
 
 var ethGwei = big.NewInt(1000000000)
 var ethMaxGasPrice = big.NewInt(1000000000) // 1 Gwei
@@ -33,15 +33,36 @@ type EthereumAPI struct {
 
 	logID int64
 
-	client *jsonrpcf.Client
+	client *rpcClient
 }
 
 //EthereumReceiptItem representation of ethereum reply for getTransactionReceipt
 type EthereumReceiptItem struct {
-	BlockHash   string
+	BlockHash         string
+	BlockNumber       string
+	GasUsed           hexutil.Big
+	EffectiveGasPrice *hexutil.Big
+	Status            string
+	Logs              []EthereumLogItem
+}
+
+//EthereumLogItem representation of ethereum log entry
+type EthereumLogItem struct {
+	Address         string
+	Topics          []string
+	Data            string
+	BlockNumber     hexutil.Uint64
+	TransactionHash string
+	LogIndex        hexutil.Uint64
+}
+
+//EthereumTxItem representation of ethereum reply for getTransactionByHash
+type EthereumTxItem struct {
+	Hash        string
+	From        string
 	BlockNumber string
-	GasUsed     hexutil.Big
-	Status      string
+	Nonce       hexutil.Uint64
+	GasPrice    hexutil.Big
 }
 
 //InitEthereum initialises ethereum based coins
@@ -55,6 +76,11 @@ func InitEthereum(tag string, config gutils.CoinConfig, testMode bool) error {
 	Coins[tag].URL = config.URL
 	Coins[tag].E.NonceFile = "/var/lib/payserv/" + tag + ".nonce"
 
+	Coins[tag].E.GasMargin = config.GasMargin
+	if Coins[tag].E.GasMargin < 100 {
+		Coins[tag].E.GasMargin = ethGasMarginDefault
+	}
+
 	Coins[tag].TestMode = testMode
 
 	gutils.RemoteLog.PutInfoS(tag, "addressFrom [%s]\n", Coins[tag].Address)
@@ -63,7 +89,7 @@ func InitEthereum(tag string, config gutils.CoinConfig, testMode bool) error {
 
 	jsonrpcf.SetVersion("2.0")
 
-	Coins[tag].E.Nonce, err = ethGetNonce(Coins[tag].Address, Coins[tag].URL)
+	Coins[tag].E.Nonce, err = ethGetNonce(tag, Coins[tag].Address, Coins[tag].URL)
 	if err != nil {
 		return gutils.FormatErrorSD("ethGetNonce", tag, "RPC test FAILED [%v]", err)
 	}
@@ -73,6 +99,8 @@ func InitEthereum(tag string, config gutils.CoinConfig, testMode bool) error {
 	if err != nil {
 		gutils.RemoteLog.PutWarningS(tag, "can't read nonce from file [%s]", Coins[tag].E.NonceFile)
 	} else {
+		metrics().SetNonceDrift(tag, int64(cachedNonce)-int64(Coins[tag].E.Nonce))
+
 		if cachedNonce != Coins[tag].E.Nonce {
 			gutils.RemoteLog.PutWarningS(tag, "saved nonce out of sync with nonce from blockchain (%d != %d)", cachedNonce, Coins[tag].E.Nonce)
 			gutils.RemoteLog.PutWarningS(tag, "must be geth issue, using cached value %d", cachedNonce)
@@ -108,10 +136,10 @@ func ethWeiToGWei(w *big.Int) decimal.Decimal {
 	return d.Div(decimal.NewFromBigInt(ethGwei, 0))
 }
 
-func ethGetNonce(address, url string) (uint64, error) {
+func ethGetNonce(tag, address, url string) (uint64, error) {
 	var err error
 
-	client := jsonrpcf.NewHTTPClient(url)
+	client := newRPCClient(tag, url)
 	defer client.Close()
 
 	var reply hexutil.Uint64
@@ -143,6 +171,17 @@ func (a *EthereumAPI) getTransactionReceipt(hash string) (*EthereumReceiptItem,
 	return &reply, err
 }
 
+func (a *EthereumAPI) getTransactionByHash(hash string) (*EthereumTxItem, error) {
+	var reply EthereumTxItem
+
+	err := a.client.Call("eth_getTransactionByHash", []string{hash}, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reply, err
+}
+
 func (a *EthereumAPI) getGasPrice() (*big.Int, error) {
 	var reply hexutil.Big
 
@@ -154,6 +193,65 @@ func (a *EthereumAPI) getGasPrice() (*big.Int, error) {
 	return (*big.Int)(&reply), err
 }
 
+//isContract address has code, transaction to it runs contract code
+func (a *EthereumAPI) isContract(address string) (bool, error) {
+	var reply hexutil.Bytes
+
+	err := a.client.Call("eth_getCode", []string{address, "latest"}, &reply)
+	if err != nil {
+		return false, err
+	}
+
+	return len(reply) > 0, nil
+}
+
+//estimateGas eth_estimateGas, returns expected gas used and gas limit, safety margin is applied only if contract
+//code is run as gas used by plain transfer is exact
+func (a *EthereumAPI) estimateGas(from, to string, value *big.Int, data []byte) (uint64, uint64, error) {
+	var reply hexutil.Uint64
+
+	err := a.client.Call("eth_estimateGas", []interface{}{ethCallArgs(from, to, value, data)}, &reply)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	contract, err := a.isContract(to)
+	if err != nil {
+		return 0, 0, fmt.Errorf("eth_getCode: %v", err)
+	}
+
+	if !contract {
+		return uint64(reply), uint64(reply), nil
+	}
+
+	margin := a.Coin.E.GasMargin
+	if margin < 100 {
+		margin = ethGasMarginDefault
+	}
+
+	return uint64(reply), uint64(reply) * uint64(margin) / 100, nil
+}
+
+//transferGas expected gas used and gas limit of plain value transfer, contract recipient fallback may need
+//more than 21000
+func (a *EthereumAPI) transferGas(from, to string, value *big.Int) (uint64, uint64, error) {
+	gasUsed, gasLimit, err := a.estimateGas(from, to, value, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if gasLimit > gasUsed {
+		gutils.RemoteLog.PutDebugI(a.logID, "recipient [%s] is contract, gasLimit: %d", to, gasLimit)
+	}
+
+	return gasUsed, gasLimit, nil
+}
+
+//ethFee fee of gas in coin units
+func (a *EthereumAPI) ethFee(gas uint64, gasPrice *big.Int) decimal.Decimal {
+	return a.ethWeiToETH(new(big.Int).Mul(new(big.Int).SetUint64(gas), gasPrice))
+}
+
 func (a *EthereumAPI) isRetryError(err error) bool {
 	var rcpError gutils.ErrorRPC
 
@@ -166,12 +264,11 @@ func (a *EthereumAPI) isRetryError(err error) bool {
 	return regexp.MustCompile("^(insufficient funds)|(balance too low)").MatchString(rcpError.Message)
 }
 
-//IsValidAddress -
+//IsValidAddress offline check of address format and EIP-55 checksum
 func (a *EthereumAPI) IsValidAddress(address string) error {
-	re := regexp.MustCompile("^0x[0-9a-fA-F]{40}$")
-
-	if !re.MatchString(address) {
-		return gutils.FormatErrorI(a.logID, "address [%s] is not valid for Ethereum", address)
+	_, err := ParseAddress(a.Tag, address)
+	if err != nil {
+		return gutils.FormatErrorI(a.logID, "address [%s] is not valid for %s: %v", address, a.Tag, err)
 	}
 
 	return nil
@@ -186,7 +283,7 @@ func (a *EthereumAPI) GetServiceAddress() string {
 func (a *EthereumAPI) GetBalance(address string) (decimal.Decimal, error) {
 	var err error
 
-	c := jsonrpcf.NewHTTPClient(a.Coin.URL)
+	c := newRPCClient(a.Tag, a.Coin.URL)
 	defer c.Close()
 
 	var reply string
@@ -216,14 +313,16 @@ func (a *EthereumAPI) Send(amount decimal.Decimal, addressTo string) (*string, b
 
 	amountWei := amount.Mul(a.Coin.E.C2C)
 
-	if len(a.Coin.Key) == 0 {
-		return nil, false, decimal.Zero, fmt.Errorf("key not loaded")
+	key, err := a.Coin.serviceKey()
+	if err != nil {
+		return nil, false, decimal.Zero, err
 	}
+	defer key.Release()
 
 	a.Coin.E.Lock()
 	defer a.Coin.E.Unlock()
 
-	a.client = jsonrpcf.NewHTTPClient(a.Coin.URL)
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
 	defer a.client.Close()
 
 	gasPrice, err := a.getGasPrice()
@@ -241,11 +340,16 @@ func (a *EthereumAPI) Send(amount decimal.Decimal, addressTo string) (*string, b
 
 	amountI.SetString(amountWei.String(), 10)
 
+	gasUsed, gasLimit, err := a.transferGas(a.Coin.Address, addressTo, &amountI)
+	if err != nil {
+		return nil, a.isRetryError(err), decimal.Zero, fmt.Errorf("eth_estimateGas: %v", err)
+	}
+
 	tx := types.NewTransaction(
 		a.Coin.E.Nonce,                 //nonce
 		common.HexToAddress(addressTo), //Address send to
 		&amountI,                       //amount
-		ethGasLimit,                    //gas limit
+		gasLimit,                       //gas limit
 		gasPrice,                       //gas price
 		nil,                            //contract
 	)
@@ -257,15 +361,16 @@ func (a *EthereumAPI) Send(amount decimal.Decimal, addressTo string) (*string, b
 
 	gutils.RemoteLog.PutDebugI(a.logID, "amount(%s): %s, gasLimit: %d, gasPrice(Gwei): %s, Nonce: %d",
 		a.Tag, amount.String(),
-		ethGasLimit,
+		gasLimit,
 		ethWeiToGWei(gasPrice).String(),
 		a.Coin.E.Nonce,
 	)
 
-	privKey, err := crypto.HexToECDSA(a.Coin.Key)
+	privKey, err := hexToECDSAKey(key)
 	if err != nil {
 		return nil, false, decimal.Zero, fmt.Errorf("HexToECDSA: %v", err)
 	}
+	defer wipeECDSA(privKey)
 
 	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(big.NewInt(a.Coin.E.ChainID)), privKey)
 	if err != nil {
@@ -277,6 +382,13 @@ func (a *EthereumAPI) Send(amount decimal.Decimal, addressTo string) (*string, b
 		return nil, false, decimal.Zero, fmt.Errorf("EncodeToBytes: %v", err)
 	}
 
+	reserved := []string{nonceKey(a.Coin.Address, a.Coin.E.Nonce)}
+
+	err = a.Coin.Spends.reserve(reserved)
+	if err != nil {
+		return nil, true, decimal.Zero, err
+	}
+
 	var replyTxHash string
 	var errF error
 
@@ -295,9 +407,17 @@ func (a *EthereumAPI) Send(amount decimal.Decimal, addressTo string) (*string, b
 	}
 
 	if errF != nil {
+		a.Coin.Spends.release(reserved)
+
 		return nil, a.isRetryError(err), decimal.Zero, errF
 	}
 
+	a.Coin.Spends.finish(reserved, !a.Coin.TestMode)
+
+	if !a.Coin.TestMode {
+		metrics().TxSent(a.Tag, amount, a.ethFee(gasUsed, gasPrice))
+	}
+
 	a.Coin.E.Nonce++
 
 	gutils.RemoteLog.PutDebugI(a.logID, "Hash: %s", replyTxHash)
@@ -321,23 +441,21 @@ func (a *EthereumAPI) ethWeiToETH(w *big.Int) decimal.Decimal {
 }
 
 //Spend -
-func (a *EthereumAPI) Spend(addressFrom, addressTo string, inputUTXOs []UTXO, privateKey string, nonce uint64) (*string, bool, decimal.Decimal, decimal.Decimal, error) {
+func (a *EthereumAPI) Spend(addressFrom, addressTo string, inputUTXOs []UTXO, privateKey *gutils.SecureBuffer, nonce uint64) (*string, bool, decimal.Decimal, decimal.Decimal, error) {
 	var err error
 
-	amount, err := a.GetBalance(addressFrom)
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
+	defer a.client.Close()
+
+	balance, err := a.getBalanceWei(addressFrom)
 	if err != nil {
-		return nil, false, decimal.Zero, decimal.Zero, err
+		return nil, false, decimal.Zero, decimal.Zero, fmt.Errorf("eth_getBalance: %v", err)
 	}
 
-	if amount.LessThanOrEqual(decimal.Zero) {
+	if balance.Sign() <= 0 {
 		return nil, false, decimal.Zero, decimal.Zero, fmt.Errorf("amount must not be zero or negative")
 	}
 
-	amountWei := amount.Mul(a.Coin.E.C2C)
-
-	a.client = jsonrpcf.NewHTTPClient(a.Coin.URL)
-	defer a.client.Close()
-
 	gasPrice, err := a.getGasPrice()
 	if err != nil {
 		return nil, false, decimal.Zero, decimal.Zero, fmt.Errorf("getGasPrice: %v", err)
@@ -349,42 +467,54 @@ func (a *EthereumAPI) Spend(addressFrom, addressTo string, inputUTXOs []UTXO, pr
 		gasPrice.Set(ethMaxGasPrice)
 	}
 
-	var amountI big.Int
+	// value for estimation only, contract recipient may depend on it
+	estimateValue := new(big.Int).Sub(balance, new(big.Int).Mul(big.NewInt(ethGasLimitStrict), gasPrice))
+	if estimateValue.Sign() < 0 {
+		estimateValue.SetInt64(0)
+	}
 
-	amountI.SetString(amountWei.String(), 10)
+	gasUsed, gasLimit, err := a.transferGas(addressFrom, addressTo, estimateValue)
+	if err != nil {
+		return nil, a.isRetryError(err), decimal.Zero, decimal.Zero, fmt.Errorf("eth_estimateGas: %v", err)
+	}
 
-	feeI := big.NewInt(ethGasLimitStrict)
+	// balance must cover whole gas limit, legacy transaction pays gasUsed * gasPrice so unused margin of contract
+	// recipient stays on address
+	feeI := new(big.Int).Mul(new(big.Int).SetUint64(gasLimit), gasPrice)
 
-	feeI = feeI.Mul(feeI, gasPrice)
+	amountI := new(big.Int).Sub(balance, feeI)
 
-	amountI = *amountI.Sub(&amountI, feeI)
+	if amountI.Sign() <= 0 {
+		return nil, false, decimal.Zero, decimal.Zero, fmt.Errorf("balance %s does not cover fee %s", a.ethWeiToETH(balance).String(), a.ethWeiToETH(feeI).String())
+	}
 
 	tx := types.NewTransaction(
 		nonce,                          //nonce
 		common.HexToAddress(addressTo), //Address send to
-		&amountI,                       //amount
-		ethGasLimitStrict,              //gas limit
+		amountI,                        //amount
+		gasLimit,                       //gas limit
 		gasPrice,                       //gas price
 		nil,                            //contract
 	)
 
 	gutils.RemoteLog.PutDebugI(a.logID, "Address: %s -> %s",
-		a.Coin.Address,
+		addressFrom,
 		addressTo,
 	)
 
 	gutils.RemoteLog.PutDebugI(a.logID, "amount(%s): %s, fee: %s, gasLimit: %d, gasPrice(Gwei): %s, Nonce: %d",
-		a.Tag, a.ethWeiToETH(&amountI).String(),
+		a.Tag, a.ethWeiToETH(amountI).String(),
 		a.ethWeiToETH(feeI).String(),
-		ethGasLimitStrict,
+		gasLimit,
 		ethWeiToGWei(gasPrice).String(),
 		nonce,
 	)
 
-	privKey, err := crypto.HexToECDSA(privateKey)
+	privKey, err := hexToECDSAKey(privateKey)
 	if err != nil {
 		return nil, false, decimal.Zero, decimal.Zero, fmt.Errorf("HexToECDSA: %v", err)
 	}
+	defer wipeECDSA(privKey)
 
 	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(big.NewInt(a.Coin.E.ChainID)), privKey)
 	if err != nil {
@@ -396,6 +526,13 @@ func (a *EthereumAPI) Spend(addressFrom, addressTo string, inputUTXOs []UTXO, pr
 		return nil, false, decimal.Zero, decimal.Zero, fmt.Errorf("EncodeToBytes: %v", err)
 	}
 
+	reserved := []string{nonceKey(addressFrom, nonce)}
+
+	err = a.Coin.Spends.reserve(reserved)
+	if err != nil {
+		return nil, true, decimal.Zero, decimal.Zero, err
+	}
+
 	var replyTxHash string
 	var errF error
 
@@ -414,16 +551,24 @@ func (a *EthereumAPI) Spend(addressFrom, addressTo string, inputUTXOs []UTXO, pr
 	}
 
 	if errF != nil {
+		a.Coin.Spends.release(reserved)
+
 		return nil, a.isRetryError(err), decimal.Zero, decimal.Zero, errF
 	}
 
+	a.Coin.Spends.finish(reserved, !a.Coin.TestMode)
+
+	if !a.Coin.TestMode {
+		metrics().TxSent(a.Tag, a.ethWeiToETH(amountI), a.ethFee(gasUsed, gasPrice))
+	}
+
 	gutils.RemoteLog.PutDebugI(a.logID, "Hash: %s", replyTxHash)
 
 	/*if testMode {
 		return nil, false, fmt.Errorf("test error")
 	}*/
 
-	return &replyTxHash, false, amount, decimal.NewFromBigInt(gasPrice, 0), nil
+	return &replyTxHash, false, a.ethWeiToETH(amountI), decimal.NewFromBigInt(gasPrice, 0), nil
 }
 
 //SendMany -
@@ -441,34 +586,85 @@ func (a *EthereumAPI) SendMany(wds *Transfers) (*string, bool, error) {
 	return txHash, isRetry, err
 }
 
-//Check -
+//Check returns status and actual fee of mined transaction, fee (gas price returned by Send) is returned until it
+//is mined
 func (a *EthereumAPI) Check(tx string, fee decimal.Decimal) (bool, decimal.Decimal, error) {
+	status, err := a.GetTxStatus(tx)
+	if err != nil {
+		return false, fee, err
+	}
+
+	if len(status.BlockHash) == 0 {
+		return false, fee, gutils.FormatErrorI(a.logID, "transaction not mined yet")
+	}
+
+	return status.Success, status.Fee, nil
+}
+
+//GetTxStatus -
+func (a *EthereumAPI) GetTxStatus(txHash string) (*TxStatus, error) {
 	var err error
-	var res bool
-	var feeCheck decimal.Decimal
 
-	a.client = jsonrpcf.NewHTTPClient(a.Coin.URL)
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
 	defer a.client.Close()
 
-	//check transaction receip
-	txRecipt, err := a.getTransactionReceipt(tx)
+	status := &TxStatus{TxHash: txHash}
+
+	tx, err := a.getTransactionByHash(txHash)
 	if err != nil {
-		return false, fee, gutils.FormatErrorSI("getTransactionReceipt", a.logID, "%v", err)
+		return nil, gutils.FormatErrorSI("getTransactionByHash", a.logID, "%v", err)
+	}
+
+	txRecipt, err := a.getTransactionReceipt(txHash)
+	if err != nil {
+		return nil, gutils.FormatErrorSI("getTransactionReceipt", a.logID, "%v", err)
 	}
 
 	if txRecipt.BlockNumber == "" {
-		return false, fee, gutils.FormatErrorI(a.logID, "transaction not mined yet")
+		// nonce already used by another mined transaction
+		if tx.From != "" {
+			var nonce hexutil.Uint64
+
+			err = a.client.Call("eth_getTransactionCount", []string{tx.From, "latest"}, &nonce)
+			if err != nil {
+				return nil, gutils.FormatErrorSI("eth_getTransactionCount", a.logID, "%v", err)
+			}
+
+			status.Replaced = uint64(nonce) > uint64(tx.Nonce)
+		}
+
+		return status, nil
 	}
 
-	res = (txRecipt.Status != "0x0")
+	blockNumber, err := hexutil.DecodeUint64(txRecipt.BlockNumber)
+	if err != nil {
+		return nil, gutils.FormatErrorSI("DecodeUint64", a.logID, "%v", err)
+	}
 
-	gasUsed := (*big.Int)(&txRecipt.GasUsed)
+	var head hexutil.Uint64
 
-	feeCheck = fee.Mul(decimal.NewFromBigInt(gasUsed, 0))
+	err = a.client.Call("eth_blockNumber", nil, &head)
+	if err != nil {
+		return nil, gutils.FormatErrorSI("eth_blockNumber", a.logID, "%v", err)
+	}
 
-	feeCheck = feeCheck.Div(a.Coin.E.C2C)
+	metrics().SetNodeHeight(a.Tag, int64(head))
 
-	return res, feeCheck, nil
+	gasPrice := (*big.Int)(&tx.GasPrice)
+
+	if txRecipt.EffectiveGasPrice != nil {
+		gasPrice = (*big.Int)(txRecipt.EffectiveGasPrice)
+	}
+
+	feeI := new(big.Int).Mul((*big.Int)(&txRecipt.GasUsed), gasPrice)
+
+	status.Success = (txRecipt.Status != "0x0")
+	status.BlockHash = txRecipt.BlockHash
+	status.BlockHeight = int64(blockNumber)
+	status.Confirmations = int64(head) - int64(blockNumber) + 1
+	status.Fee = a.ethWeiToETH(feeI)
+
+	return status, nil
 }
 
 //CreateAccount -
@@ -503,15 +699,16 @@ func (a *EthereumAPI) CreateAccount(privateKey string) (*Account, error) {
 func (a *EthereumAPI) SetServiceAccount(address, privKey string) {
 	var err error
 
-	a.Coin.Address = address
-	a.Coin.Key = privKey
+	setServiceAccount(a.Coin, a.Tag, address, privKey)
 
-	a.Coin.E.Nonce, err = ethGetNonce(a.Coin.Address, a.Coin.URL)
+	a.Coin.E.Nonce, err = ethGetNonce(a.Tag, a.Coin.Address, a.Coin.URL)
 	if err != nil {
 		gutils.RemoteLog.PutErrorS(a.Tag, "RPC test FAILED [%v]", err)
 
 		return
 	}
+
+	metrics().SetNonceDrift(a.Tag, 0)
 }
 
 //GetRedeemScript -
@@ -0,0 +1,221 @@
+package coinapi
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/seagiv/common/gutils"
+	"github.com/seagiv/foreign/decimal"
+)
+
+//EthereumContract contract address with its ABI
+type EthereumContract struct {
+	Address string
+	ABI     abi.ABI
+}
+
+//EthereumEvent decoded log entry
+type EthereumEvent struct {
+	Name     string
+	Contract string
+	TxHash   string
+	LogIndex uint64
+	Fields   map[string]interface{}
+}
+
+//NewEthereumContract parses ABI JSON as produced by solc
+func NewEthereumContract(address, abiJSON string) (*EthereumContract, error) {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return nil, fmt.Errorf("abi.JSON: %v", err)
+	}
+
+	return &EthereumContract{Address: common.HexToAddress(address).Hex(), ABI: parsed}, nil
+}
+
+func ethCallArgs(from, to string, value *big.Int, data []byte) map[string]interface{} {
+	call := map[string]interface{}{
+		"to":   to,
+		"data": hexutil.Encode(data),
+	}
+
+	if len(from) > 0 {
+		call["from"] = from
+	}
+
+	if value != nil && value.Sign() > 0 {
+		call["value"] = (*hexutil.Big)(value)
+	}
+
+	return call
+}
+
+//CallContract read-only eth_call of method at latest block, returns decoded outputs
+func (a *EthereumAPI) CallContract(c *EthereumContract, method string, args ...interface{}) ([]interface{}, error) {
+	var reply hexutil.Bytes
+
+	m, ok := c.ABI.Methods[method]
+	if !ok {
+		return nil, fmt.Errorf("method [%s] not found in ABI", method)
+	}
+
+	data, err := c.ABI.Pack(method, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Pack: %v", err)
+	}
+
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
+	defer a.client.Close()
+
+	err = a.client.Call("eth_call", []interface{}{ethCallArgs(a.Coin.Address, c.Address, nil, data), "latest"}, &reply)
+	if err != nil {
+		return nil, fmt.Errorf("eth_call: %v", err)
+	}
+
+	result, err := m.Outputs.UnpackValues(reply)
+	if err != nil {
+		return nil, fmt.Errorf("Unpack: %v", err)
+	}
+
+	return result, nil
+}
+
+//TransactContract state-changing call from service address, value is in coin units and may be zero
+//gas is estimated, nonce is taken the same way Send does
+func (a *EthereumAPI) TransactContract(c *EthereumContract, value decimal.Decimal, method string, args ...interface{}) (*string, error) {
+	if !a.Coin.keyLoaded() {
+		return nil, fmt.Errorf("key not loaded")
+	}
+
+	if value.LessThan(decimal.Zero) {
+		return nil, fmt.Errorf("value must not be negative")
+	}
+
+	data, err := c.ABI.Pack(method, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Pack: %v", err)
+	}
+
+	valueWei, _ := new(big.Int).SetString(value.Mul(a.Coin.E.C2C).Truncate(0).String(), 10)
+
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
+	defer a.client.Close()
+
+	gasPrice, err := a.getGasPrice()
+	if err != nil {
+		return nil, fmt.Errorf("getGasPrice: %v", err)
+	}
+
+	if gasPrice.Cmp(ethMaxGasPrice) > 0 {
+		gutils.RemoteLog.PutDebugI(a.logID, "GasPrice too high limiting it %s -> %s", ethWeiToGWei(gasPrice).String(), ethWeiToGWei(ethMaxGasPrice).String())
+
+		gasPrice.Set(ethMaxGasPrice)
+	}
+
+	// estimation fails with revert reason when call would fail on chain
+	gasUsed, gasLimit, err := a.estimateGas(a.Coin.Address, c.Address, valueWei, data)
+	if err != nil {
+		return nil, fmt.Errorf("eth_estimateGas: %v", err)
+	}
+
+	gutils.RemoteLog.PutDebugI(a.logID, "%s.%s value(%s): %s, gasLimit: %d, gasPrice(Gwei): %s",
+		c.Address, method,
+		a.Tag, value.String(),
+		gasLimit,
+		ethWeiToGWei(gasPrice).String(),
+	)
+
+	txHash, err := a.sendFromService(c.Address, valueWei, gasUsed, gasLimit, gasPrice, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("eth_sendRawTransaction: %v", err)
+	}
+
+	gutils.RemoteLog.PutDebugI(a.logID, "Hash: %s", txHash)
+
+	return &txHash, nil
+}
+
+//DecodeLog decodes log entry emitted by contract, returns nil if log is from other contract or event is unknown
+func (c *EthereumContract) DecodeLog(l EthereumLogItem) (*EthereumEvent, error) {
+	if !strings.EqualFold(l.Address, c.Address) || len(l.Topics) == 0 {
+		return nil, nil
+	}
+
+	event, err := c.ABI.EventByID(common.HexToHash(l.Topics[0]))
+	if err != nil {
+		return nil, nil
+	}
+
+	data, err := hexutil.Decode(l.Data)
+	if err != nil {
+		return nil, fmt.Errorf("log data: %v", err)
+	}
+
+	e := &EthereumEvent{
+		Name:     event.Name,
+		Contract: c.Address,
+		TxHash:   l.TransactionHash,
+		LogIndex: uint64(l.LogIndex),
+		Fields:   make(map[string]interface{}),
+	}
+
+	err = event.Inputs.UnpackIntoMap(e.Fields, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", event.Name, err)
+	}
+
+	var indexed abi.Arguments
+
+	for _, arg := range event.Inputs {
+		if arg.Indexed {
+			indexed = append(indexed, arg)
+		}
+	}
+
+	topics := make([]common.Hash, 0, len(l.Topics)-1)
+
+	for _, t := range l.Topics[1:] {
+		topics = append(topics, common.HexToHash(t))
+	}
+
+	err = abi.ParseTopicsIntoMap(e.Fields, indexed, topics)
+	if err != nil {
+		return nil, fmt.Errorf("%s topics: %v", event.Name, err)
+	}
+
+	return e, nil
+}
+
+//DecodeReceiptLogs returns events of contract emitted by mined transaction
+func (a *EthereumAPI) DecodeReceiptLogs(c *EthereumContract, txHash string) ([]EthereumEvent, error) {
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
+	defer a.client.Close()
+
+	receipt, err := a.getTransactionReceipt(txHash)
+	if err != nil {
+		return nil, fmt.Errorf("getTransactionReceipt: %v", err)
+	}
+
+	if len(receipt.BlockHash) == 0 {
+		return nil, fmt.Errorf("transaction [%s] is not mined", txHash)
+	}
+
+	var events []EthereumEvent
+
+	for _, l := range receipt.Logs {
+		e, err := c.DecodeLog(l)
+		if err != nil {
+			return nil, err
+		}
+
+		if e != nil {
+			events = append(events, *e)
+		}
+	}
+
+	return events, nil
+}
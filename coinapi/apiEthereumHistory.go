@@ -0,0 +1,188 @@
+package coinapi
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/seagiv/foreign/decimal"
+)
+
+// keccak256("Transfer(address,address,uint256)")
+const erc20TopicTransfer = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+type replyEthBlockTx struct {
+	Hash     string          `json:"hash"`
+	From     string          `json:"from"`
+	To       *common.Address `json:"to"`
+	Value    hexutil.Big     `json:"value"`
+	GasPrice hexutil.Big     `json:"gasPrice"`
+}
+
+type replyEthBlock struct {
+	Number       hexutil.Uint64    `json:"number"`
+	Timestamp    hexutil.Uint64    `json:"timestamp"`
+	Transactions []replyEthBlockTx `json:"transactions"`
+}
+
+//txFee actual fee paid for mined transaction
+func (a *EthereumAPI) txFee(hash string, gasPrice *big.Int) (*big.Int, error) {
+	receipt, err := a.getTransactionReceipt(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if receipt.EffectiveGasPrice != nil {
+		gasPrice = (*big.Int)(receipt.EffectiveGasPrice)
+	}
+
+	return new(big.Int).Mul((*big.Int)(&receipt.GasUsed), gasPrice), nil
+}
+
+//tokenHistory ERC20 Transfer events of address in block range
+func (a *EthereumAPI) tokenHistory(address string, fromHeight, toHeight int64, times map[int64]int64) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+
+	topic := "0x" + ethPadAddress(address)
+
+	// sender is topic 1, receiver topic 2
+	for i, topics := range [][]interface{}{{erc20TopicTransfer, topic}, {erc20TopicTransfer, nil, topic}} {
+		var logs []EthereumLogItem
+
+		filter := map[string]interface{}{
+			"fromBlock": hexutil.EncodeUint64(uint64(fromHeight)),
+			"toBlock":   hexutil.EncodeUint64(uint64(toHeight)),
+			"topics":    topics,
+		}
+
+		err := a.client.Call("eth_getLogs", []interface{}{filter}, &logs)
+		if err != nil {
+			return nil, fmt.Errorf("eth_getLogs: %v", err)
+		}
+
+		for _, l := range logs {
+			if len(l.Topics) != 3 {
+				continue // ERC721 has indexed token id
+			}
+
+			value, ok := new(big.Int).SetString(strings.TrimPrefix(l.Data, "0x"), 16)
+			if !ok {
+				continue
+			}
+
+			from := common.HexToAddress(l.Topics[1]).Hex()
+			to := common.HexToAddress(l.Topics[2]).Hex()
+
+			entry := HistoryEntry{
+				TxHash: l.TransactionHash,
+				Height: int64(l.BlockNumber),
+				Time:   times[int64(l.BlockNumber)],
+				Amount: decimal.NewFromBigInt(value, 0),
+				Token:  common.HexToAddress(l.Address).Hex(),
+			}
+
+			switch {
+			case strings.EqualFold(from, to):
+				if i == 1 {
+					continue // already taken as sender
+				}
+
+				entry.Direction, entry.Counterparty = HistorySelf, to
+			case i == 0:
+				entry.Direction, entry.Counterparty = HistoryOut, to
+			default:
+				entry.Direction, entry.Counterparty = HistoryIn, from
+			}
+
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+//History returns transactions and ERC20 transfers of address in [fromHeight, toHeight] by scanning blocks and logs
+//internal (contract made) ether transfers are not visible without tracing node
+func (a *EthereumAPI) History(address string, fromHeight, toHeight int64) ([]HistoryEntry, error) {
+	var err error
+
+	if toHeight < fromHeight || fromHeight < 0 {
+		return nil, fmt.Errorf("invalid height range %d..%d", fromHeight, toHeight)
+	}
+
+	if toHeight-fromHeight >= historyMaxBlocks {
+		return nil, fmt.Errorf("height range too wide, max %d blocks per call", historyMaxBlocks)
+	}
+
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
+	defer a.client.Close()
+
+	times := make(map[int64]int64)
+
+	var entries []HistoryEntry
+
+	for height := fromHeight; height <= toHeight; height++ {
+		var block replyEthBlock
+
+		err = a.client.Call("eth_getBlockByNumber", []interface{}{hexutil.EncodeUint64(uint64(height)), true}, &block)
+		if err != nil {
+			return nil, fmt.Errorf("eth_getBlockByNumber: %v", err)
+		}
+
+		times[height] = int64(block.Timestamp)
+
+		for _, tx := range block.Transactions {
+			to := ""
+			if tx.To != nil {
+				to = tx.To.Hex()
+			}
+
+			isFrom := strings.EqualFold(tx.From, address)
+			isTo := strings.EqualFold(to, address)
+
+			if !isFrom && !isTo {
+				continue
+			}
+
+			entry := HistoryEntry{
+				TxHash: tx.Hash,
+				Height: height,
+				Time:   int64(block.Timestamp),
+				Amount: a.ethWeiToETH((*big.Int)(&tx.Value)),
+			}
+
+			switch {
+			case isFrom && isTo:
+				entry.Direction, entry.Counterparty = HistorySelf, to
+			case isFrom:
+				entry.Direction, entry.Counterparty = HistoryOut, to
+			default:
+				entry.Direction, entry.Counterparty = HistoryIn, common.HexToAddress(tx.From).Hex()
+			}
+
+			if isFrom {
+				fee, err := a.txFee(tx.Hash, (*big.Int)(&tx.GasPrice))
+				if err != nil {
+					return nil, fmt.Errorf("getTransactionReceipt: %v", err)
+				}
+
+				entry.Fee = a.ethWeiToETH(fee)
+			}
+
+			entries = append(entries, entry)
+		}
+	}
+
+	tokenEntries, err := a.tokenHistory(address, fromHeight, toHeight, times)
+	if err != nil {
+		return nil, err
+	}
+
+	entries = append(entries, tokenEntries...)
+
+	sortHistory(entries)
+
+	return entries, nil
+}
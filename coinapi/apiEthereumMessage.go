@@ -0,0 +1,378 @@
+package coinapi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+//EthereumTypedField EIP-712 struct member
+type EthereumTypedField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+//EthereumTypedData EIP-712 typed data as accepted by eth_signTypedData_v4
+type EthereumTypedData struct {
+	Types       map[string][]EthereumTypedField `json:"types"`
+	PrimaryType string                          `json:"primaryType"`
+	Domain      map[string]interface{}          `json:"domain"`
+	Message     map[string]interface{}          `json:"message"`
+}
+
+var reTypedArray = regexp.MustCompile(`^(.*)\[([0-9]*)\]$`)
+
+//personalHash EIP-191 version 0x45 hash
+func personalHash(message []byte) []byte {
+	prefix := fmt.Sprintf("\x19Ethereum Signed Message:\n%d", len(message))
+
+	return crypto.Keccak256([]byte(prefix), message)
+}
+
+func (td *EthereumTypedData) dependencies(typeName string, found map[string]bool) {
+	if m := reTypedArray.FindStringSubmatch(typeName); m != nil {
+		typeName = m[1]
+	}
+
+	if found[typeName] || td.Types[typeName] == nil {
+		return
+	}
+
+	found[typeName] = true
+
+	for _, field := range td.Types[typeName] {
+		td.dependencies(field.Type, found)
+	}
+}
+
+func (td *EthereumTypedData) encodeType(typeName string) string {
+	found := make(map[string]bool)
+
+	td.dependencies(typeName, found)
+
+	delete(found, typeName)
+
+	deps := []string{typeName}
+	others := []string{}
+
+	for dep := range found {
+		others = append(others, dep)
+	}
+
+	sort.Strings(others)
+
+	var b strings.Builder
+
+	for _, dep := range append(deps, others...) {
+		fields := []string{}
+
+		for _, field := range td.Types[dep] {
+			fields = append(fields, field.Type+" "+field.Name)
+		}
+
+		b.WriteString(dep + "(" + strings.Join(fields, ",") + ")")
+	}
+
+	return b.String()
+}
+
+func typedInteger(value interface{}) (*big.Int, error) {
+	var s string
+
+	switch v := value.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return big.NewInt(1), nil
+		}
+
+		return big.NewInt(0), nil
+	default:
+		return nil, fmt.Errorf("invalid integer %v", value)
+	}
+
+	i, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return nil, fmt.Errorf("invalid integer %s", s)
+	}
+
+	return i, nil
+}
+
+func typedBytes(value interface{}) ([]byte, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid bytes %v", value)
+	}
+
+	return hex.DecodeString(strings.TrimPrefix(s, "0x"))
+}
+
+func word(b []byte, left bool) []byte {
+	w := make([]byte, 32)
+
+	if left {
+		copy(w[32-len(b):], b)
+	} else {
+		copy(w, b)
+	}
+
+	return w
+}
+
+func (td *EthereumTypedData) encodeValue(typeName string, value interface{}) ([]byte, error) {
+	if m := reTypedArray.FindStringSubmatch(typeName); m != nil {
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: array expected", typeName)
+		}
+
+		var b bytes.Buffer
+
+		for _, item := range items {
+			w, err := td.encodeValue(m[1], item)
+			if err != nil {
+				return nil, err
+			}
+
+			b.Write(w)
+		}
+
+		return crypto.Keccak256(b.Bytes()), nil
+	}
+
+	if td.Types[typeName] != nil {
+		data, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: struct expected", typeName)
+		}
+
+		return td.hashStruct(typeName, data)
+	}
+
+	switch {
+	case typeName == "string":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("string expected")
+		}
+
+		return crypto.Keccak256([]byte(s)), nil
+
+	case typeName == "bytes":
+		b, err := typedBytes(value)
+		if err != nil {
+			return nil, err
+		}
+
+		return crypto.Keccak256(b), nil
+
+	case typeName == "address":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("address expected")
+		}
+
+		return word(common.HexToAddress(s).Bytes(), true), nil
+
+	case typeName == "bool":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("bool expected")
+		}
+
+		if b {
+			return word([]byte{1}, true), nil
+		}
+
+		return word(nil, true), nil
+
+	case strings.HasPrefix(typeName, "bytes"):
+		b, err := typedBytes(value)
+		if err != nil || len(b) > 32 {
+			return nil, fmt.Errorf("%s: invalid value", typeName)
+		}
+
+		return word(b, false), nil
+
+	case strings.HasPrefix(typeName, "uint"), strings.HasPrefix(typeName, "int"):
+		i, err := typedInteger(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if i.Sign() < 0 {
+			i.Add(i, new(big.Int).Lsh(big.NewInt(1), 256)) // two's complement
+		}
+
+		return word(i.Bytes(), true), nil
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", typeName)
+}
+
+func (td *EthereumTypedData) hashStruct(typeName string, data map[string]interface{}) ([]byte, error) {
+	var b bytes.Buffer
+
+	b.Write(crypto.Keccak256([]byte(td.encodeType(typeName))))
+
+	for _, field := range td.Types[typeName] {
+		value, ok := data[field.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s.%s missing", typeName, field.Name)
+		}
+
+		w, err := td.encodeValue(field.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", typeName, field.Name, err)
+		}
+
+		b.Write(w)
+	}
+
+	return crypto.Keccak256(b.Bytes()), nil
+}
+
+//Hash EIP-712 signing hash
+func (td *EthereumTypedData) Hash() ([]byte, error) {
+	domainSeparator, err := td.hashStruct("EIP712Domain", td.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	messageHash, err := td.hashStruct(td.PrimaryType, td.Message)
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.Keccak256([]byte{0x19, 0x01}, domainSeparator, messageHash), nil
+}
+
+//parseTypedData decodes EIP-712 typed data JSON
+func parseTypedData(typedData string) (*EthereumTypedData, error) {
+	var td EthereumTypedData
+
+	decoder := json.NewDecoder(strings.NewReader(typedData))
+	decoder.UseNumber()
+
+	err := decoder.Decode(&td)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(td.PrimaryType) == 0 || td.Types == nil {
+		return nil, fmt.Errorf("primaryType and types required")
+	}
+
+	return &td, nil
+}
+
+//typedDataHash EIP-712 hash of typed data JSON
+func typedDataHash(typedData string) ([]byte, error) {
+	td, err := parseTypedData(typedData)
+	if err != nil {
+		return nil, fmt.Errorf("typed data: %v", err)
+	}
+
+	hash, err := td.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("typed data: %v", err)
+	}
+
+	return hash, nil
+}
+
+//signHash signs hash by key of address, signature is in eth_sign format with v of 27 or 28
+func (a *EthereumAPI) signHash(address string, hash []byte) (string, error) {
+	key, err := getAddressKey(a.Coin, a.Tag, address)
+	if err != nil {
+		return "", err
+	}
+	defer key.Release()
+
+	privKey, err := hexToECDSAKey(key)
+	if err != nil {
+		return "", fmt.Errorf("HexToECDSA: %v", err)
+	}
+	defer wipeECDSA(privKey)
+
+	if !strings.EqualFold(crypto.PubkeyToAddress(privKey.PublicKey).Hex(), address) {
+		return "", fmt.Errorf("key does not match address [%s]", address)
+	}
+
+	sig, err := crypto.Sign(hash, privKey)
+	if err != nil {
+		return "", fmt.Errorf("Sign: %v", err)
+	}
+
+	sig[64] += 27
+
+	return "0x" + hex.EncodeToString(sig), nil
+}
+
+//verifyHash checks that signature of hash is made by address
+func verifyHash(address string, hash []byte, signature string) error {
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if err != nil || len(sig) != 65 {
+		return fmt.Errorf("invalid signature encoding")
+	}
+
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+
+	pubKey, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return fmt.Errorf("SigToPub: %v", err)
+	}
+
+	if !strings.EqualFold(crypto.PubkeyToAddress(*pubKey).Hex(), address) {
+		return fmt.Errorf("signature does not match address [%s]", address)
+	}
+
+	return nil
+}
+
+//SignMessage personal_sign of message, message is never treated as typed data, use SignTypedData for EIP-712
+func (a *EthereumAPI) SignMessage(address, message string) (string, error) {
+	return a.signHash(address, personalHash([]byte(message)))
+}
+
+//VerifyMessage checks personal_sign signature of message
+func (a *EthereumAPI) VerifyMessage(address, message, signature string) error {
+	return verifyHash(address, personalHash([]byte(message)), signature)
+}
+
+//SignTypedData EIP-712 signature of typed data JSON as made by eth_signTypedData_v4
+func (a *EthereumAPI) SignTypedData(address, typedData string) (string, error) {
+	hash, err := typedDataHash(typedData)
+	if err != nil {
+		return "", err
+	}
+
+	return a.signHash(address, hash)
+}
+
+//VerifyTypedData checks EIP-712 signature of typed data JSON
+func (a *EthereumAPI) VerifyTypedData(address, typedData, signature string) error {
+	hash, err := typedDataHash(typedData)
+	if err != nil {
+		return err
+	}
+
+	return verifyHash(address, hash, signature)
+}
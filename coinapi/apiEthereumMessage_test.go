@@ -0,0 +1,105 @@
+package coinapi
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// example of EIP-712 specification signed by key keccak256("cow")
+const (
+	testTypedData = `{
+  "types": {
+    "EIP712Domain": [
+      {"name": "name", "type": "string"},
+      {"name": "version", "type": "string"},
+      {"name": "chainId", "type": "uint256"},
+      {"name": "verifyingContract", "type": "address"}
+    ],
+    "Person": [
+      {"name": "name", "type": "string"},
+      {"name": "wallet", "type": "address"}
+    ],
+    "Mail": [
+      {"name": "from", "type": "Person"},
+      {"name": "to", "type": "Person"},
+      {"name": "contents", "type": "string"}
+    ]
+  },
+  "primaryType": "Mail",
+  "domain": {
+    "name": "Ether Mail",
+    "version": "1",
+    "chainId": 1,
+    "verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+  },
+  "message": {
+    "from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+    "to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+    "contents": "Hello, Bob!"
+  }
+}`
+	testTypedHash      = "be609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2"
+	testTypedAddress   = "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"
+	testTypedKey       = "c85ef7d79691fe79573b1a7064c19c1a9819ebdbd1faaab1a8ec92344438aaf4"
+	testTypedSignature = "0x4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b915621c"
+)
+
+func TestEthereumTypedData(t *testing.T) {
+	a := NewEthereumAPI(0, CoinETH, Coins[CoinETH])
+
+	hash, err := typedDataHash(testTypedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h := hex.EncodeToString(hash); h != testTypedHash {
+		t.Errorf("hash %s, want %s", h, testTypedHash)
+	}
+
+	err = a.VerifyTypedData(testTypedAddress, testTypedData, testTypedSignature)
+	if err != nil {
+		t.Errorf("VerifyTypedData: %v", err)
+	}
+
+	// typed data JSON passed as message is signed as plain text
+	err = a.VerifyMessage(testTypedAddress, testTypedData, testTypedSignature)
+	if err == nil {
+		t.Errorf("typed data signature accepted by VerifyMessage")
+	}
+
+	_, err = typedDataHash(`{"message": "not typed"}`)
+	if err == nil {
+		t.Errorf("message without types accepted as typed data")
+	}
+}
+
+func TestEthereumSignTypedData(t *testing.T) {
+	a := NewEthereumAPI(0, CoinETH, Coins[CoinETH])
+
+	setServiceAccount(a.Coin, a.Tag, testTypedAddress, testTypedKey)
+	defer a.Coin.setAccount("", nil)
+
+	sig, err := a.SignTypedData(testTypedAddress, testTypedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sig != testTypedSignature {
+		t.Errorf("signature %s, want %s", sig, testTypedSignature)
+	}
+
+	sig, err = a.SignMessage(testTypedAddress, "Hello, Bob!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = a.VerifyMessage(testTypedAddress, "Hello, Bob!", sig)
+	if err != nil {
+		t.Errorf("VerifyMessage: %v", err)
+	}
+
+	err = a.VerifyTypedData(testTypedAddress, testTypedData, sig)
+	if err == nil {
+		t.Errorf("personal signature accepted as typed data signature")
+	}
+}
@@ -0,0 +1,71 @@
+package coinapi
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func (a *EthereumAPI) getNonceAt(address, block string) (uint64, error) {
+	var reply hexutil.Uint64
+
+	err := a.client.Call("eth_getTransactionCount", []string{address, block}, &reply)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(reply), nil
+}
+
+//Snapshot returns latest and pending balance and pending nonce range of service address
+func (a *EthereumAPI) Snapshot() (*Snapshot, error) {
+	var err error
+	var head hexutil.Uint64
+	var latest, pending hexutil.Big
+
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
+	defer a.client.Close()
+
+	s := &Snapshot{Coin: a.Tag, Address: a.Coin.Address, Time: time.Now().UTC()}
+
+	err = a.client.Call("eth_blockNumber", nil, &head)
+	if err != nil {
+		return nil, fmt.Errorf("eth_blockNumber: %v", err)
+	}
+
+	s.Height = int64(head)
+
+	err = a.client.Call("eth_getBalance", []string{a.Coin.Address, "latest"}, &latest)
+	if err != nil {
+		return nil, fmt.Errorf("eth_getBalance: %v", err)
+	}
+
+	err = a.client.Call("eth_getBalance", []string{a.Coin.Address, "pending"}, &pending)
+	if err != nil {
+		return nil, fmt.Errorf("eth_getBalance: %v", err)
+	}
+
+	s.Confirmed = a.ethWeiToETH((*big.Int)(&latest))
+	s.Unconfirmed = a.ethWeiToETH((*big.Int)(&pending)).Sub(s.Confirmed)
+
+	s.NonceLatest, err = a.getNonceAt(a.Coin.Address, "latest")
+	if err != nil {
+		return nil, fmt.Errorf("eth_getTransactionCount: %v", err)
+	}
+
+	s.NoncePending, err = a.getNonceAt(a.Coin.Address, "pending")
+	if err != nil {
+		return nil, fmt.Errorf("eth_getTransactionCount: %v", err)
+	}
+
+	a.Coin.E.Lock()
+	s.NonceCached = a.Coin.E.Nonce
+	a.Coin.E.Unlock()
+
+	metrics().SetNonceDrift(a.Tag, int64(s.NonceCached)-int64(s.NoncePending))
+	metrics().SetNodeHeight(a.Tag, s.Height)
+
+	return s, nil
+}
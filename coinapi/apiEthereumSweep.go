@@ -0,0 +1,672 @@
+package coinapi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/seagiv/common/gutils"
+	"github.com/seagiv/foreign/decimal"
+)
+
+const (
+	erc20MethodTransfer  = "a9059cbb"
+	erc20MethodBalanceOf = "70a08231"
+)
+
+// sweep stages, item moves forward (back to new only if gas price rose above topped up balance) and is persisted
+// after every step, signed transaction of stage is persisted before it is broadcast
+const (
+	sweepStageNew      = "new"
+	sweepStageTopUp    = "topup"
+	sweepStageTransfer = "transfer"
+	sweepStageDust     = "dust"
+	sweepStageDone     = "done"
+	sweepStageSkipped  = "skipped"
+)
+
+const sweepPollInterval = 15 * time.Second
+
+//SweepOptions parameters for Sweep
+type SweepOptions struct {
+	Addresses []string        // deposit addresses, keys are taken from Storage
+	Storage   *gutils.Storage // Coin.<TAG>.<address>.JSON items
+
+	Token         string // ERC20 contract address, plain ETH sweep if empty
+	TokenDecimals int32
+
+	StateFile   string        // sweep progress, interrupted sweep resumes from it
+	WaitTimeout time.Duration // max time to wait for each transaction to be mined
+}
+
+//SweepItem state of single deposit address sweep
+type SweepItem struct {
+	Address string
+	Stage   string
+
+	TokenAmount  string // in token units
+	TokenGas     uint64 `json:",omitempty"` // gas limit of token transfer, estimated before top up
+	TokenGasUsed uint64 `json:",omitempty"` // expected gas used by token transfer
+
+	TopUpTx    string
+	TransferTx string
+	DustTx     string
+
+	PendingRaw string `json:",omitempty"` // signed transaction of current stage, may be broadcast already
+
+	Error string
+}
+
+//SweepState persisted sweep progress
+type SweepState struct {
+	Token string
+	Items map[string]*SweepItem
+}
+
+func ethPadAddress(address string) string {
+	return strings.Repeat("00", 12) + hex.EncodeToString(common.HexToAddress(address).Bytes())
+}
+
+//erc20TransferData call data of transfer(to, amount)
+func erc20TransferData(to string, amount *big.Int) []byte {
+	data, _ := hex.DecodeString(erc20MethodTransfer + ethPadAddress(to) + ethPadBig(amount))
+
+	return data
+}
+
+func ethPadBig(v *big.Int) string {
+	return fmt.Sprintf("%064x", v)
+}
+
+func (a *EthereumAPI) getBalanceWei(address string) (*big.Int, error) {
+	var reply hexutil.Big
+
+	err := a.client.Call("eth_getBalance", []string{address, "latest"}, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return (*big.Int)(&reply), nil
+}
+
+func (a *EthereumAPI) getTokenBalance(token, address string) (*big.Int, error) {
+	var reply string
+
+	call := map[string]string{
+		"to":   token,
+		"data": "0x" + erc20MethodBalanceOf + ethPadAddress(address),
+	}
+
+	err := a.client.Call("eth_call", []interface{}{call, "latest"}, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	balance := new(big.Int)
+
+	if len(reply) > 2 {
+		balance.SetString(reply[2:], 16)
+	}
+
+	return balance, nil
+}
+
+// next stage of stage that broadcasts transaction
+var sweepStageSent = map[string]string{
+	sweepStageNew:   sweepStageTopUp,
+	sweepStageTopUp: sweepStageTransfer,
+	sweepStageDust:  sweepStageDone,
+}
+
+//stageTx hash of transaction sent at current stage
+func (item *SweepItem) stageTx() *string {
+	switch item.Stage {
+	case sweepStageNew:
+		return &item.TopUpTx
+	case sweepStageTopUp:
+		return &item.TransferTx
+	}
+
+	return &item.DustTx
+}
+
+//rpcRejected node answered call with error, so request reached it and was refused
+func rpcRejected(err error) bool {
+	var rpcError gutils.ErrorRPC
+
+	return err != nil && json.Unmarshal([]byte(err.Error()), &rpcError) == nil && len(rpcError.Message) > 0
+}
+
+//signAndSend signs transaction with key and broadcasts it, returns txHash, pending if not nil is called
+//with hash and raw transaction before broadcast, its error cancels broadcast. If broadcast fails without node
+//refusing it, transaction may be sent already: with pending its txHash is returned along with error and nonce
+//stays used, persisted transaction is to be resent. Refused transaction is cleared by pending("", "")
+func (a *EthereumAPI) signAndSend(key *gutils.SecureBuffer, nonce uint64, to string, amount *big.Int, gasUsed, gasLimit uint64, gasPrice *big.Int, data []byte, pending func(txHash, raw string) error) (string, error) {
+	var replyTxHash string
+
+	tx := types.NewTransaction(nonce, common.HexToAddress(to), amount, gasLimit, gasPrice, data)
+
+	privKey, err := hexToECDSAKey(key)
+	if err != nil {
+		return "", fmt.Errorf("HexToECDSA: %v", err)
+	}
+	defer wipeECDSA(privKey)
+
+	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(big.NewInt(a.Coin.E.ChainID)), privKey)
+	if err != nil {
+		return "", fmt.Errorf("SignTx: %v", err)
+	}
+
+	raw, err := rlp.EncodeToBytes(signedTx)
+	if err != nil {
+		return "", fmt.Errorf("EncodeToBytes: %v", err)
+	}
+
+	gutils.RemoteLog.PutDebugI(a.logID, "SignedTx: %s", common.ToHex(raw))
+
+	reserved := []string{nonceKey(crypto.PubkeyToAddress(privKey.PublicKey).Hex(), nonce)}
+
+	err = a.Coin.Spends.reserve(reserved)
+	if err != nil {
+		return "", err
+	}
+
+	if pending != nil {
+		err = pending(signedTx.Hash().Hex(), common.ToHex(raw))
+		if err != nil {
+			a.Coin.Spends.release(reserved)
+
+			return "", err
+		}
+	}
+
+	if a.Coin.TestMode {
+		a.Coin.Spends.release(reserved)
+
+		return a.Coin.TestTrans, nil
+	}
+
+	err = a.client.Call("eth_sendRawTransaction", []string{common.ToHex(raw)}, &replyTxHash)
+	if err != nil {
+		if pending != nil && !rpcRejected(err) {
+			a.Coin.Spends.broadcast(reserved)
+
+			return signedTx.Hash().Hex(), err
+		}
+
+		a.Coin.Spends.release(reserved)
+
+		if pending != nil {
+			errPending := pending("", "")
+			if errPending != nil {
+				gutils.RemoteLog.PutWarningSI("sendPending", a.logID, "%v", errPending)
+			}
+		}
+
+		return "", err
+	}
+
+	a.Coin.Spends.broadcast(reserved)
+
+	metrics().TxSent(a.Tag, a.ethWeiToETH(amount), a.ethFee(gasUsed, gasPrice))
+
+	return replyTxHash, nil
+}
+
+//sendFromService sends transaction from service address using cached nonce the same way Send does, nonce is
+//advanced if transaction may be sent
+func (a *EthereumAPI) sendFromService(to string, amount *big.Int, gasUsed, gasLimit uint64, gasPrice *big.Int, data []byte, pending func(txHash, raw string) error) (string, error) {
+	key, err := a.Coin.serviceKey()
+	if err != nil {
+		return "", err
+	}
+	defer key.Release()
+
+	a.Coin.E.Lock()
+	defer a.Coin.E.Unlock()
+
+	txHash, err := a.signAndSend(key, a.Coin.E.Nonce, to, amount, gasUsed, gasLimit, gasPrice, data, pending)
+	if len(txHash) == 0 {
+		return "", err
+	}
+
+	a.Coin.E.Nonce++
+
+	errNonce := a.saveNonce(a.Coin.E.Nonce)
+	if errNonce != nil {
+		gutils.RemoteLog.PutWarningSI("ethSaveNonce", a.logID, "can't store nonce to file %v", errNonce)
+	}
+
+	return txHash, err
+}
+
+//skipServiceNonce moves cached nonce of service address past nonce used by resent transaction
+func (a *EthereumAPI) skipServiceNonce(nonce uint64) {
+	a.Coin.E.Lock()
+	defer a.Coin.E.Unlock()
+
+	if a.Coin.E.Nonce > nonce {
+		return
+	}
+
+	a.Coin.E.Nonce = nonce + 1
+
+	err := a.saveNonce(a.Coin.E.Nonce)
+	if err != nil {
+		gutils.RemoteLog.PutWarningSI("ethSaveNonce", a.logID, "can't store nonce to file %v", err)
+	}
+}
+
+//resendRaw broadcasts transaction persisted before interruption unless node knows it already, same signed
+//transaction is never spent twice, returns its nonce. Error refused by node means transaction can't be mined
+func (a *EthereumAPI) resendRaw(raw string) (uint64, error) {
+	var tx types.Transaction
+	var replyTxHash string
+
+	data, err := hexutil.Decode(raw)
+	if err != nil {
+		return 0, err
+	}
+
+	err = rlp.DecodeBytes(data, &tx)
+	if err != nil {
+		return 0, fmt.Errorf("DecodeBytes: %v", err)
+	}
+
+	if a.Coin.TestMode {
+		return tx.Nonce(), nil
+	}
+
+	known, err := a.getTransactionByHash(tx.Hash().Hex())
+	if err != nil {
+		return 0, fmt.Errorf("getTransactionByHash: %v", err)
+	}
+
+	if len(known.Hash) > 0 {
+		return tx.Nonce(), nil
+	}
+
+	gutils.RemoteLog.PutDebugI(a.logID, "resending %s", tx.Hash().Hex())
+
+	err = a.client.Call("eth_sendRawTransaction", []string{raw}, &replyTxHash)
+	if err != nil {
+		return 0, err
+	}
+
+	return tx.Nonce(), nil
+}
+
+func (a *EthereumAPI) waitMined(txHash string, timeout time.Duration) error {
+	if a.Coin.TestMode {
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		receipt, err := a.getTransactionReceipt(txHash)
+		if err != nil {
+			return err
+		}
+
+		if receipt.BlockNumber != "" {
+			if receipt.Status == "0x0" {
+				return fmt.Errorf("transaction [%s] failed", txHash)
+			}
+
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("transaction [%s] not mined in %v", txHash, timeout)
+		}
+
+		time.Sleep(sweepPollInterval)
+	}
+}
+
+func loadSweepState(fileName, token string) (*SweepState, error) {
+	state := &SweepState{Token: token, Items: make(map[string]*SweepItem)}
+
+	if len(fileName) == 0 {
+		return state, nil
+	}
+
+	err := gutils.LoadObject(fileName, state)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+
+		return nil, err
+	}
+
+	if state.Token != token {
+		return nil, fmt.Errorf("state file [%s] belongs to token [%s]", fileName, state.Token)
+	}
+
+	if state.Items == nil {
+		state.Items = make(map[string]*SweepItem)
+	}
+
+	return state, nil
+}
+
+//saveSweepState replaces state file by rename so interrupted write never leaves it truncated
+func saveSweepState(fileName string, state *SweepState) error {
+	if len(fileName) == 0 {
+		return nil
+	}
+
+	err := gutils.SaveObject(fileName+".tmp", state)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(fileName+".tmp", fileName)
+}
+
+//sweepPending returns hook persisting signed transaction of item before it is broadcast
+func sweepPending(item *SweepItem, txHash *string, save func() error) func(string, string) error {
+	return func(hash, raw string) error {
+		*txHash = hash
+		item.PendingRaw = raw
+
+		err := save()
+		if err != nil {
+			return fmt.Errorf("saveSweepState: %v", err)
+		}
+
+		return nil
+	}
+}
+
+//sweepGasPrice current gas price limited by ethMaxGasPrice, it is taken for every stage as sweep waits for
+//transactions to be mined in between
+func (a *EthereumAPI) sweepGasPrice() (*big.Int, error) {
+	gasPrice, err := a.getGasPrice()
+	if err != nil {
+		return nil, fmt.Errorf("getGasPrice: %v", err)
+	}
+
+	if gasPrice.Cmp(ethMaxGasPrice) > 0 {
+		gasPrice.Set(ethMaxGasPrice)
+	}
+
+	return gasPrice, nil
+}
+
+//sweepStep advances item by one stage, save persists state
+func (a *EthereumAPI) sweepStep(opts *SweepOptions, item *SweepItem, key *gutils.SecureBuffer, save func() error) error {
+	var err error
+
+	// interrupted after transaction of stage was signed, it is resent as is instead of signing new one
+	if len(item.PendingRaw) > 0 {
+		next, ok := sweepStageSent[item.Stage]
+		if !ok {
+			return fmt.Errorf("pending transaction at stage %s", item.Stage)
+		}
+
+		nonce, err := a.resendRaw(item.PendingRaw)
+		if rpcRejected(err) {
+			// nonce is taken by another transaction or price and balance changed, stage is signed again
+			gutils.RemoteLog.PutWarningSI(item.Address, a.logID, "pending transaction of stage %s refused: %v", item.Stage, err)
+
+			item.PendingRaw = ""
+			*item.stageTx() = ""
+
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("resend: %v", err)
+		}
+
+		if item.Stage == sweepStageNew {
+			a.skipServiceNonce(nonce)
+		}
+
+		item.PendingRaw = ""
+		item.Stage = next
+
+		return nil
+	}
+
+	gasPrice, err := a.sweepGasPrice()
+	if err != nil {
+		return err
+	}
+
+	switch item.Stage {
+	case sweepStageNew:
+		if len(opts.Token) == 0 {
+			item.Stage = sweepStageDust
+
+			return nil
+		}
+
+		amount, err := a.getTokenBalance(opts.Token, item.Address)
+		if err != nil {
+			return fmt.Errorf("getTokenBalance: %v", err)
+		}
+
+		if amount.Sign() <= 0 {
+			item.Stage = sweepStageSkipped
+
+			return nil
+		}
+
+		item.TokenAmount = amount.String()
+
+		item.TokenGasUsed, item.TokenGas, err = a.estimateGas(item.Address, opts.Token, nil, erc20TransferData(a.Coin.Address, amount))
+		if err != nil {
+			return fmt.Errorf("eth_estimateGas: %v", err)
+		}
+
+		balance, err := a.getBalanceWei(item.Address)
+		if err != nil {
+			return fmt.Errorf("getBalanceWei: %v", err)
+		}
+
+		// token transfer and return of what is left after it are paid from address
+		needed := new(big.Int).Mul(new(big.Int).SetUint64(item.TokenGas+ethGasLimitStrict), gasPrice)
+
+		if balance.Cmp(needed) < 0 {
+			topUp := new(big.Int).Sub(needed, balance)
+
+			gasUsed, gasLimit, err := a.transferGas(a.Coin.Address, item.Address, topUp)
+			if err != nil {
+				return fmt.Errorf("eth_estimateGas: %v", err)
+			}
+
+			_, err = a.sendFromService(item.Address, topUp, gasUsed, gasLimit, gasPrice, nil, sweepPending(item, &item.TopUpTx, save))
+			if err != nil {
+				return fmt.Errorf("topUp: %v", err)
+			}
+
+			gutils.RemoteLog.PutDebugI(a.logID, "%s top up %s, hash %s", item.Address, a.ethWeiToETH(topUp).String(), item.TopUpTx)
+		}
+
+		item.PendingRaw = ""
+		item.Stage = sweepStageTopUp
+
+	case sweepStageTopUp:
+		if len(item.TopUpTx) > 0 {
+			err = a.waitMined(item.TopUpTx, opts.WaitTimeout)
+			if err != nil {
+				return err
+			}
+		}
+
+		amount, ok := new(big.Int).SetString(item.TokenAmount, 10)
+		if !ok {
+			return fmt.Errorf("invalid token amount [%s]", item.TokenAmount)
+		}
+
+		if item.TokenGas == 0 {
+			return fmt.Errorf("token transfer gas not estimated")
+		}
+
+		balance, err := a.getBalanceWei(item.Address)
+		if err != nil {
+			return fmt.Errorf("getBalanceWei: %v", err)
+		}
+
+		if balance.Cmp(new(big.Int).Mul(new(big.Int).SetUint64(item.TokenGas), gasPrice)) < 0 {
+			gutils.RemoteLog.PutDebugI(a.logID, "%s balance %s is short of gas at current price, topping up again", item.Address, a.ethWeiToETH(balance).String())
+
+			item.Stage = sweepStageNew
+
+			return nil
+		}
+
+		nonce, err := ethGetNonce(a.Tag, item.Address, a.Coin.URL)
+		if err != nil {
+			return fmt.Errorf("ethGetNonce: %v", err)
+		}
+
+		data := erc20TransferData(a.Coin.Address, amount)
+
+		// gas limit the address was topped up for
+		_, err = a.signAndSend(key, nonce, opts.Token, big.NewInt(0), item.TokenGasUsed, item.TokenGas, gasPrice, data, sweepPending(item, &item.TransferTx, save))
+		if err != nil {
+			return fmt.Errorf("transfer: %v", err)
+		}
+
+		gutils.RemoteLog.PutDebugI(a.logID, "%s transfer %s, hash %s", item.Address, decimal.NewFromBigInt(amount, -opts.TokenDecimals).String(), item.TransferTx)
+
+		item.PendingRaw = ""
+		item.Stage = sweepStageTransfer
+
+	case sweepStageTransfer:
+		err = a.waitMined(item.TransferTx, opts.WaitTimeout)
+		if err != nil {
+			return err
+		}
+
+		item.Stage = sweepStageDust
+
+	case sweepStageDust:
+		balance, err := a.getBalanceWei(item.Address)
+		if err != nil {
+			return fmt.Errorf("getBalanceWei: %v", err)
+		}
+
+		gasUsed, gasLimit, err := a.transferGas(item.Address, a.Coin.Address, nil)
+		if err != nil {
+			return fmt.Errorf("eth_estimateGas: %v", err)
+		}
+
+		// service address has no code so gas limit is exact and nothing is left on address
+		amount := new(big.Int).Sub(balance, new(big.Int).Mul(new(big.Int).SetUint64(gasLimit), gasPrice))
+
+		if amount.Sign() > 0 {
+			nonce, err := ethGetNonce(a.Tag, item.Address, a.Coin.URL)
+			if err != nil {
+				return fmt.Errorf("ethGetNonce: %v", err)
+			}
+
+			_, err = a.signAndSend(key, nonce, a.Coin.Address, amount, gasUsed, gasLimit, gasPrice, nil, sweepPending(item, &item.DustTx, save))
+			if err != nil {
+				return fmt.Errorf("dust: %v", err)
+			}
+
+			gutils.RemoteLog.PutDebugI(a.logID, "%s return %s, hash %s", item.Address, a.ethWeiToETH(amount).String(), item.DustTx)
+		}
+
+		item.PendingRaw = ""
+		item.Stage = sweepStageDone
+
+	default:
+		return fmt.Errorf("unknown stage [%s]", item.Stage)
+	}
+
+	return nil
+}
+
+//Sweep moves token (or ETH) balance of deposit addresses to service address, topping up gas when needed
+func (a *EthereumAPI) Sweep(opts SweepOptions) ([]SweepItem, error) {
+	var err error
+
+	if len(opts.Token) > 0 {
+		err = a.IsValidAddress(opts.Token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Storage == nil {
+		return nil, gutils.FormatErrorSI("checkOptions", a.logID, "storage required for deposit keys")
+	}
+
+	if opts.WaitTimeout == 0 {
+		opts.WaitTimeout = 30 * time.Minute
+	}
+
+	state, err := loadSweepState(opts.StateFile, opts.Token)
+	if err != nil {
+		return nil, gutils.FormatErrorSI("loadSweepState", a.logID, "%v", err)
+	}
+
+	a.client = newRPCClient(a.Tag, a.Coin.URL)
+	defer a.client.Close()
+
+	var result []SweepItem
+
+	save := func() error {
+		return saveSweepState(opts.StateFile, state)
+	}
+
+	for _, address := range opts.Addresses {
+		item := state.Items[address]
+		if item == nil {
+			item = &SweepItem{Address: address, Stage: sweepStageNew}
+
+			state.Items[address] = item
+		}
+
+		key, err := opts.Storage.GetAddressKey(a.Tag, address)
+		if err != nil {
+			item.Error = err.Error()
+		}
+
+		for len(item.Error) == 0 && item.Stage != sweepStageDone && item.Stage != sweepStageSkipped {
+			err = a.sweepStep(&opts, item, key, save)
+			if err != nil {
+				item.Error = err.Error()
+			}
+
+			err = save()
+			if err != nil {
+				gutils.RemoteLog.PutWarningSI("saveSweepState", a.logID, "%v", err)
+			}
+		}
+
+		key.Release()
+
+		if len(item.Error) > 0 {
+			gutils.RemoteLog.PutWarningSI(address, a.logID, "sweep stopped at stage %s: %s", item.Stage, item.Error)
+		}
+
+		result = append(result, *item)
+
+		// error is reported once, next run retries the stage
+		item.Error = ""
+	}
+
+	err = save()
+	if err != nil {
+		gutils.RemoteLog.PutWarningSI("saveSweepState", a.logID, "%v", err)
+	}
+
+	return result, nil
+}
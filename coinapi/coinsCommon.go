@@ -2,6 +2,8 @@ package coinapi
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/seagiv/common/gutils"
@@ -33,6 +35,12 @@ const CoinDASH = "DASH"
 //CoinMONA -
 const CoinMONA = "MONA"
 
+//CoinLTC -
+const CoinLTC = "LTC"
+
+//CoinDOGE -
+const CoinDOGE = "DOGE"
+
 //APITypeBitcoin -
 const APITypeBitcoin = "BitcoinAPI"
 
@@ -47,6 +55,8 @@ type coinE struct {
 	Nonce     uint64
 	NonceFile string
 
+	GasMargin int64 // percent, estimated gas is multiplied by GasMargin/100 as state may change before transaction is mined
+
 	C2C decimal.Decimal
 }
 
@@ -59,12 +69,21 @@ type coinB struct {
 	PrivKeyID byte
 	ScriptID  byte
 
-	Fee string // per 100 byte (1x vout)
+	Bech32HRP string // empty if coin has no native segwit addresses
+	Legacy    bool   // no segwit, P2PKH addresses and no redeemScript for inputs
+
+	MessageMagic string // signed message prefix
+
+	Fee        string // per 100 byte (1x vout)
+	MinFeeRate string // per 1000 byte, enforced on top of Fee if set
+	DustLimit  string // outputs below are rejected, change below is added to fee
 }
 
 type coinInfo struct {
-	Address string `json:"address"`
-	Key     string `json:"key"`
+	Address string               `json:"address"`
+	Key     *gutils.SecureBuffer `json:"-"` // replaced by setAccount under keyLock, use serviceKey
+
+	keyLock sync.Mutex
 
 	URL string
 
@@ -75,10 +94,17 @@ type coinInfo struct {
 
 	E coinE
 	B coinB
+
+	Spends spendCoordinator // UTXOs and nonces reserved by spends in progress
 }
 
 var initialized = []string{}
 
+var (
+	keyStorageLock sync.RWMutex
+	keyStorage     *gutils.Storage
+)
+
 //Transfer args for SendMany function
 type Transfer struct {
 	ID int64
@@ -115,6 +141,22 @@ var (
 //Incoms -
 type Incoms []Income
 
+//TxStatus blockchain status of a sent transaction
+type TxStatus struct {
+	TxHash string
+
+	Success       bool
+	Confirmations int64
+
+	BlockHash   string
+	BlockHeight int64
+
+	Fee decimal.Decimal // actual fee paid, in coins
+
+	Replaced   bool
+	ReplacedBy string
+}
+
 //CoinAPI general template for coin API
 type CoinAPI interface {
 
@@ -133,7 +175,8 @@ type CoinAPI interface {
 
 	//spend specified utxo (determine fee and substruct it from amount)
 	//to addressTo using private key in one transaction, returns: txHash, isRetry, amount, fee, error
-	Spend(addressFrom, addressTo string, inputUTXOs []UTXO, privateKey string, nonce uint64) (*string, bool, decimal.Decimal, decimal.Decimal, error)
+	//privateKey is not released, bitcoin family signs by service key if it is empty
+	Spend(addressFrom, addressTo string, inputUTXOs []UTXO, privateKey *gutils.SecureBuffer, nonce uint64) (*string, bool, decimal.Decimal, decimal.Decimal, error)
 
 	//sends coins from service address to number of different addresses in one transaction, returns txHash, isRetry, error
 	SendMany(w *Transfers) (*string, bool, error)
@@ -141,12 +184,27 @@ type CoinAPI interface {
 	//checks specified transaction blockchain status, returns isSuccess, fee, error
 	Check(txHash string, fee decimal.Decimal) (bool, decimal.Decimal, error)
 
+	//returns detailed transaction status derived from chain data: block, actual fee, replacement
+	GetTxStatus(txHash string) (*TxStatus, error)
+
+	//returns balances, unspent outputs and pending transactions of service address for reconciliation
+	Snapshot() (*Snapshot, error)
+
+	//returns mined transactions of address in height range, both bounds included
+	History(address string, fromHeight, toHeight int64) ([]HistoryEntry, error)
+
 	//creates priv/pub key pair and address privateKey can be "", in that case it will be generated
 	CreateAccount(privateKey string) (*Account, error)
 
 	//override service account, used for test puproses
 	SetServiceAccount(address, privKey string)
 
+	//signs message with key of address (service address or address from key storage), returns signature
+	SignMessage(address, message string) (string, error)
+
+	//verifies message signature made by address
+	VerifyMessage(address, message, signature string) error
+
 	//generate redeemScript for given privKey (BitcoinAPI only for now)
 	GetRedeemScript(privKey string) (string, error)
 
@@ -154,6 +212,92 @@ type CoinAPI interface {
 	GetAPIType() string
 }
 
+//SetKeyStorage sets storage for keys of addresses other then service address
+func SetKeyStorage(s *gutils.Storage) {
+	keyStorageLock.Lock()
+	defer keyStorageLock.Unlock()
+
+	keyStorage = s
+}
+
+//LoadServiceAccount sets service address and key of coin from Coin.<TAG>.JSON of s, key goes from storage to
+//secure buffer without becoming string
+func LoadServiceAccount(tag string, s *gutils.Storage) error {
+	c := Coins[tag]
+	if c == nil {
+		return errCoinNotSupported
+	}
+
+	address, key, err := s.GetCoinInfo(tag)
+	if err != nil {
+		return err
+	}
+
+	c.setAccount(address, key)
+
+	return nil
+}
+
+//setServiceAccount implements SetServiceAccount of both APIs, privKey string can't be wiped, LoadServiceAccount
+//should be used instead outside of tests
+func setServiceAccount(c *coinInfo, tag, address, privKey string) {
+	key, err := gutils.NewSecureBufferString(privKey)
+	if err != nil {
+		gutils.RemoteLog.PutWarningS(tag, "SetServiceAccount: %v", err)
+	}
+
+	c.setAccount(address, key)
+}
+
+//setAccount replaces service account, previous key is released so spends in progress sign by their copies
+func (c *coinInfo) setAccount(address string, key *gutils.SecureBuffer) {
+	c.keyLock.Lock()
+	defer c.keyLock.Unlock()
+
+	old := c.Key
+
+	c.Address = address
+	c.Key = key
+
+	old.Release()
+}
+
+//serviceKey returns copy of service key, caller must Release it
+func (c *coinInfo) serviceKey() (*gutils.SecureBuffer, error) {
+	c.keyLock.Lock()
+	defer c.keyLock.Unlock()
+
+	if c.Key.Len() == 0 {
+		return nil, errors.New("key not loaded")
+	}
+
+	return c.Key.Clone()
+}
+
+func (c *coinInfo) keyLoaded() bool {
+	c.keyLock.Lock()
+	defer c.keyLock.Unlock()
+
+	return c.Key.Len() > 0
+}
+
+//getAddressKey returns copy of key of service address or key from key storage, caller must Release it
+func getAddressKey(c *coinInfo, tag, address string) (*gutils.SecureBuffer, error) {
+	if strings.EqualFold(address, c.Address) {
+		return c.serviceKey()
+	}
+
+	keyStorageLock.RLock()
+	s := keyStorage
+	keyStorageLock.RUnlock()
+
+	if s == nil {
+		return nil, fmt.Errorf("key for [%s] not found, key storage not set", address)
+	}
+
+	return s.GetAddressKey(tag, address)
+}
+
 //GetAvailable list of initialized coins
 func GetAvailable() []string {
 	return initialized
@@ -174,7 +318,7 @@ func GetCoinAPI(logID int64, tag string) (CoinAPI, error) {
 	switch tag {
 	case CoinETH, CoinETC:
 		api = NewEthereumAPI(logID, tag, Coins[tag])
-	case CoinBTC, CoinBCH, CoinZEC, CoinRVN, CoinDASH, CoinMONA:
+	case CoinBTC, CoinBCH, CoinZEC, CoinRVN, CoinDASH, CoinMONA, CoinLTC, CoinDOGE:
 		api = NewBitcoinAPI(logID, tag, Coins[tag])
 	default:
 		return api, errCoinNotSupported
@@ -187,10 +331,12 @@ func GetCoinAPI(logID int64, tag string) (CoinAPI, error) {
 var Coins = map[string]*coinInfo{
 	CoinETH:  &coinInfo{OutLimit: 001, E: coinE{ChainID: 01, C2C: decimal.New(1, 18)}},
 	CoinETC:  &coinInfo{OutLimit: 001, E: coinE{ChainID: 61, C2C: decimal.New(1, 18)}},
-	CoinZEC:  &coinInfo{OutLimit: 500, B: coinB{Fee: "0.00001", Confirmations: 06, PubKeyID: 0xB8, PrivKeyID: 0x80, ScriptID: 0xBD}},
-	CoinBTC:  &coinInfo{OutLimit: 500, B: coinB{Fee: "0.00001", Confirmations: 06, PubKeyID: 0x00, PrivKeyID: 0x80, ScriptID: 0x05}},
-	CoinBCH:  &coinInfo{OutLimit: 500, B: coinB{Fee: "0.00001", Confirmations: 06, PubKeyID: 0x00, PrivKeyID: 0x80, ScriptID: 0x05}},
-	CoinRVN:  &coinInfo{OutLimit: 500, B: coinB{Fee: "0.00050", Confirmations: 06, PubKeyID: 0x3C, PrivKeyID: 0x80, ScriptID: 0x7A}},
-	CoinDASH: &coinInfo{OutLimit: 500, B: coinB{Fee: "0.00010", Confirmations: 06, PubKeyID: 0x4C, PrivKeyID: 0xCC, ScriptID: 0x10}},
-	CoinMONA: &coinInfo{OutLimit: 500, B: coinB{Fee: "0.00030", Confirmations: 05, PubKeyID: 0x32, PrivKeyID: 0xB0, ScriptID: 0x37}},
+	CoinZEC:  &coinInfo{OutLimit: 500, B: coinB{Fee: "0.00001", Confirmations: 06, PubKeyID: 0xB8, PrivKeyID: 0x80, ScriptID: 0xBD, MessageMagic: "Zcash Signed Message:\n"}},
+	CoinBTC:  &coinInfo{OutLimit: 500, B: coinB{Fee: "0.00001", Confirmations: 06, PubKeyID: 0x00, PrivKeyID: 0x80, ScriptID: 0x05, Bech32HRP: "bc", MessageMagic: "Bitcoin Signed Message:\n"}},
+	CoinBCH:  &coinInfo{OutLimit: 500, B: coinB{Fee: "0.00001", Confirmations: 06, PubKeyID: 0x00, PrivKeyID: 0x80, ScriptID: 0x05, MessageMagic: "Bitcoin Signed Message:\n"}},
+	CoinRVN:  &coinInfo{OutLimit: 500, B: coinB{Fee: "0.00050", Confirmations: 06, PubKeyID: 0x3C, PrivKeyID: 0x80, ScriptID: 0x7A, MessageMagic: "Raven Signed Message:\n"}},
+	CoinDASH: &coinInfo{OutLimit: 500, B: coinB{Fee: "0.00010", Confirmations: 06, PubKeyID: 0x4C, PrivKeyID: 0xCC, ScriptID: 0x10, MessageMagic: "DarkCoin Signed Message:\n"}},
+	CoinMONA: &coinInfo{OutLimit: 500, B: coinB{Fee: "0.00030", Confirmations: 05, PubKeyID: 0x32, PrivKeyID: 0xB0, ScriptID: 0x37, Bech32HRP: "mona", MessageMagic: "Monacoin Signed Message:\n"}},
+	CoinLTC:  &coinInfo{OutLimit: 500, B: coinB{Fee: "0.00010", Confirmations: 06, PubKeyID: 0x30, PrivKeyID: 0xB0, ScriptID: 0x32, Bech32HRP: "ltc", MessageMagic: "Litecoin Signed Message:\n"}},
+	CoinDOGE: &coinInfo{OutLimit: 500, B: coinB{Fee: "1.00000", Confirmations: 06, PubKeyID: 0x1E, PrivKeyID: 0x9E, ScriptID: 0x16, Legacy: true, MinFeeRate: "1.0", DustLimit: "1.0", MessageMagic: "Dogecoin Signed Message:\n"}},
 }
@@ -0,0 +1,41 @@
+package coinapi
+
+import (
+	"sort"
+
+	"github.com/seagiv/foreign/decimal"
+)
+
+// history directions
+const (
+	HistoryIn   = "in"
+	HistoryOut  = "out"
+	HistorySelf = "self"
+)
+
+// widest block range History scans in one call on chains without address index
+const historyMaxBlocks = 50000
+
+//HistoryEntry normalized transaction of address, amounts in coin units
+type HistoryEntry struct {
+	TxHash string
+	Height int64
+	Time   int64
+
+	Direction    string
+	Amount       decimal.Decimal // always positive, Direction tells the sign
+	Fee          decimal.Decimal // paid by address, zero for incoming
+	Counterparty string
+
+	Token string `json:",omitempty"` // ERC20 contract, Amount is then in token base units
+}
+
+func sortHistory(entries []HistoryEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Height != entries[j].Height {
+			return entries[i].Height < entries[j].Height
+		}
+
+		return entries[i].TxHash < entries[j].TxHash
+	})
+}
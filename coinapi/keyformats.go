@@ -0,0 +1,530 @@
+package coinapi
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/seagiv/common/gutils"
+	"golang.org/x/crypto/scrypt"
+)
+
+// key formats accepted by ConvertKey
+const (
+	KeyFormatHex             = "hex"
+	KeyFormatWIF             = "wif"
+	KeyFormatWIFUncompressed = "wifu"
+	KeyFormatBIP38           = "bip38"
+)
+
+const extKeyLength = 78
+
+const bip32HardenedOffset = 0x80000000
+
+// SLIP-132 extended key versions
+var slip132Versions = map[string]uint32{
+	"xprv": 0x0488ADE4, "xpub": 0x0488B21E, // P2PKH
+	"yprv": 0x049D7878, "ypub": 0x049D7CB3, // P2WPKH in P2SH
+	"zprv": 0x04B2430C, "zpub": 0x04B24746, // P2WPKH
+	"Ltpv": 0x019D9CFE, "Ltub": 0x019DA462, // Litecoin P2PKH
+	"Mtpv": 0x01B26792, "Mtub": 0x01B26EF6, // Litecoin P2WPKH in P2SH
+	"dgpv": 0x02FAC398, "dgub": 0x02FACAFD, // Dogecoin P2PKH
+}
+
+var slip132Public = map[string]string{
+	"xprv": "xpub", "yprv": "ypub", "zprv": "zpub", "Ltpv": "Ltub", "Mtpv": "Mtub", "dgpv": "dgub",
+}
+
+//coinNetworkParams chaincfg params with coin version bytes
+func coinNetworkParams(c *coinInfo) *chaincfg.Params {
+	networkParams := chaincfg.MainNetParams // copy, shared params must not be modified
+	networkParams.PubKeyHashAddrID = c.B.PubKeyID
+	networkParams.PrivateKeyID = c.B.PrivKeyID
+	networkParams.ScriptHashAddrID = c.B.ScriptID
+	networkParams.Bech32HRPSegwit = c.B.Bech32HRP
+	return &networkParams
+}
+
+func bitcoinCoin(tag string) (*coinInfo, error) {
+	c := Coins[tag]
+	if c == nil || !c.E.C2C.IsZero() {
+		return nil, fmt.Errorf("coin %s is not bitcoin based", tag)
+	}
+
+	return c, nil
+}
+
+//p2pkhAddress legacy address of pubKey, used by BIP38 address hash
+func p2pkhAddress(tag string, c *coinInfo, pubKey []byte) string {
+	if tag == CoinZEC {
+		return base58.CheckEncode(append([]byte{zcashPrefixP2PKH[1]}, btcutil.Hash160(pubKey)...), zcashPrefixP2PKH[0])
+	}
+
+	return base58.CheckEncode(btcutil.Hash160(pubKey), c.B.PubKeyID)
+}
+
+//HexToWIF encodes raw 32 byte hex private key as WIF with coin version byte
+func HexToWIF(tag, key string, compressed bool) (string, error) {
+	c, err := bitcoinCoin(tag)
+	if err != nil {
+		return "", err
+	}
+
+	keyB, err := hex.DecodeString(strings.TrimPrefix(key, "0x"))
+	if err != nil || len(keyB) != 32 {
+		return "", fmt.Errorf("32 byte hex key expected")
+	}
+
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), keyB)
+
+	wif, err := btcutil.NewWIF(privKey, coinNetworkParams(c), compressed)
+	if err != nil {
+		return "", err
+	}
+
+	return wif.String(), nil
+}
+
+//WIFToHex decodes WIF checking coin version byte, returns hex key and compression flag
+func WIFToHex(tag, wifS string) (string, bool, error) {
+	c, err := bitcoinCoin(tag)
+	if err != nil {
+		return "", false, err
+	}
+
+	wif, err := btcutil.DecodeWIF(wifS)
+	if err != nil {
+		return "", false, err
+	}
+
+	if !wif.IsForNet(coinNetworkParams(c)) {
+		return "", false, fmt.Errorf("WIF version is not valid for %s", tag)
+	}
+
+	return hex.EncodeToString(wif.PrivKey.Serialize()), wif.CompressPubKey, nil
+}
+
+func bip38Keys(passphrase string, addressHash []byte) ([]byte, []byte, error) {
+	derived, err := scrypt.Key([]byte(passphrase), addressHash, 16384, 8, 8, 64)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return derived[:32], derived[32:], nil
+}
+
+//EncryptBIP38 encrypts WIF with passphrase, non EC-multiply mode
+func EncryptBIP38(tag, wifS, passphrase string) (string, error) {
+	c, err := bitcoinCoin(tag)
+	if err != nil {
+		return "", err
+	}
+
+	wif, err := btcutil.DecodeWIF(wifS)
+	if err != nil {
+		return "", err
+	}
+
+	if !wif.IsForNet(coinNetworkParams(c)) {
+		return "", fmt.Errorf("WIF version is not valid for %s", tag)
+	}
+
+	priv := wif.PrivKey.Serialize()
+
+	addressHash := chainhash.DoubleHashB([]byte(p2pkhAddress(tag, c, wif.SerializePubKey())))[:4]
+
+	dh1, dh2, err := bip38Keys(passphrase, addressHash)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(dh2)
+	if err != nil {
+		return "", err
+	}
+
+	flag := byte(0xC0)
+	if wif.CompressPubKey {
+		flag |= 0x20
+	}
+
+	out := append([]byte{0x01, 0x42, flag}, addressHash...)
+
+	half := make([]byte, 16)
+
+	for i := 0; i < 32; i += 16 {
+		for j := 0; j < 16; j++ {
+			half[j] = priv[i+j] ^ dh1[i+j]
+		}
+
+		enc := make([]byte, 16)
+		block.Encrypt(enc, half)
+
+		out = append(out, enc...)
+	}
+
+	sum := chainhash.DoubleHashB(out)
+
+	return base58.Encode(append(out, sum[:4]...)), nil
+}
+
+//DecryptBIP38 decrypts BIP38 key to WIF of coin, EC-multiply keys are not supported
+func DecryptBIP38(tag, encrypted, passphrase string) (string, error) {
+	c, err := bitcoinCoin(tag)
+	if err != nil {
+		return "", err
+	}
+
+	raw := base58.Decode(encrypted)
+	if len(raw) != 43 || !bytes.Equal(chainhash.DoubleHashB(raw[:39])[:4], raw[39:]) {
+		return "", fmt.Errorf("invalid BIP38 encoding")
+	}
+
+	if raw[0] != 0x01 || raw[1] != 0x42 {
+		return "", fmt.Errorf("only non EC-multiply BIP38 keys supported")
+	}
+
+	compressed := raw[2]&0x20 != 0
+	addressHash := raw[3:7]
+
+	dh1, dh2, err := bip38Keys(passphrase, addressHash)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(dh2)
+	if err != nil {
+		return "", err
+	}
+
+	priv := make([]byte, 32)
+
+	block.Decrypt(priv[:16], raw[7:23])
+	block.Decrypt(priv[16:], raw[23:39])
+
+	for i := range priv {
+		priv[i] ^= dh1[i]
+	}
+
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), priv)
+
+	wif, err := btcutil.NewWIF(privKey, coinNetworkParams(c), compressed)
+	if err != nil {
+		return "", err
+	}
+
+	if !bytes.Equal(chainhash.DoubleHashB([]byte(p2pkhAddress(tag, c, wif.SerializePubKey())))[:4], addressHash) {
+		return "", fmt.Errorf("wrong passphrase")
+	}
+
+	return wif.String(), nil
+}
+
+//extendedKey BIP32 serialized key
+type extendedKey struct {
+	version   uint32
+	depth     byte
+	parentFP  []byte
+	child     uint32
+	chainCode []byte
+	key       []byte // 33 bytes, 0x00 prefixed for private keys
+}
+
+func (k *extendedKey) isPrivate() bool {
+	return k.key[0] == 0
+}
+
+func (k *extendedKey) pubKey() []byte {
+	if !k.isPrivate() {
+		return k.key
+	}
+
+	_, pub := btcec.PrivKeyFromBytes(btcec.S256(), k.key[1:])
+
+	return pub.SerializeCompressed()
+}
+
+func (k *extendedKey) String() string {
+	b := make([]byte, 0, extKeyLength+4)
+
+	b = append(b, byte(k.version>>24), byte(k.version>>16), byte(k.version>>8), byte(k.version))
+	b = append(b, k.depth)
+	b = append(b, k.parentFP...)
+	b = append(b, byte(k.child>>24), byte(k.child>>16), byte(k.child>>8), byte(k.child))
+	b = append(b, k.chainCode...)
+	b = append(b, k.key...)
+
+	sum := chainhash.DoubleHashB(b)
+
+	return base58.Encode(append(b, sum[:4]...))
+}
+
+func parseExtendedKey(s string) (*extendedKey, string, error) {
+	raw := base58.Decode(s)
+	if len(raw) != extKeyLength+4 || !bytes.Equal(chainhash.DoubleHashB(raw[:extKeyLength])[:4], raw[extKeyLength:]) {
+		return nil, "", fmt.Errorf("invalid extended key encoding")
+	}
+
+	k := &extendedKey{
+		version:   binary.BigEndian.Uint32(raw[0:4]),
+		depth:     raw[4],
+		parentFP:  raw[5:9],
+		child:     binary.BigEndian.Uint32(raw[9:13]),
+		chainCode: raw[13:45],
+		key:       raw[45:78],
+	}
+
+	for prefix, version := range slip132Versions {
+		if version != k.version {
+			continue
+		}
+
+		if _, private := slip132Public[prefix]; private != k.isPrivate() {
+			return nil, "", fmt.Errorf("key data does not match %s prefix", prefix)
+		}
+
+		return k, prefix, nil
+	}
+
+	return nil, "", fmt.Errorf("unknown extended key version %08X", k.version)
+}
+
+func (k *extendedKey) derive(index uint32) (*extendedKey, error) {
+	curve := btcec.S256()
+
+	var data []byte
+
+	if index >= bip32HardenedOffset {
+		if !k.isPrivate() {
+			return nil, fmt.Errorf("hardened derivation requires private key")
+		}
+
+		data = append(data, k.key...)
+	} else {
+		data = append(data, k.pubKey()...)
+	}
+
+	data = append(data, byte(index>>24), byte(index>>16), byte(index>>8), byte(index))
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	I := mac.Sum(nil)
+
+	il := new(big.Int).SetBytes(I[:32])
+	if il.Cmp(curve.N) >= 0 {
+		return nil, fmt.Errorf("invalid child %d", index)
+	}
+
+	child := &extendedKey{
+		version:   k.version,
+		depth:     k.depth + 1,
+		parentFP:  btcutil.Hash160(k.pubKey())[:4],
+		child:     index,
+		chainCode: I[32:],
+	}
+
+	if k.isPrivate() {
+		il.Add(il, new(big.Int).SetBytes(k.key[1:]))
+		il.Mod(il, curve.N)
+
+		if il.Sign() == 0 {
+			return nil, fmt.Errorf("invalid child %d", index)
+		}
+
+		child.key = make([]byte, 33)
+		il.FillBytes(child.key[1:])
+
+		return child, nil
+	}
+
+	parent, err := btcec.ParsePubKey(k.key, curve)
+	if err != nil {
+		return nil, err
+	}
+
+	x, y := curve.ScalarBaseMult(I[:32])
+	x, y = curve.Add(x, y, parent.X, parent.Y)
+
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, fmt.Errorf("invalid child %d", index)
+	}
+
+	child.key = (&btcec.PublicKey{Curve: curve, X: x, Y: y}).SerializeCompressed()
+
+	return child, nil
+}
+
+//DeriveExtendedKey derives child key by path like m/44'/0'/0'/0/0, h suffix is accepted as hardened mark
+func DeriveExtendedKey(key, path string) (string, error) {
+	k, _, err := parseExtendedKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	parts := strings.Split(strings.TrimSpace(path), "/")
+
+	if len(parts) == 0 || (parts[0] != "m" && parts[0] != "M") {
+		return "", fmt.Errorf("path must start with m")
+	}
+
+	for _, p := range parts[1:] {
+		offset := uint32(0)
+
+		if strings.HasSuffix(p, "'") || strings.HasSuffix(p, "h") || strings.HasSuffix(p, "H") {
+			offset = bip32HardenedOffset
+			p = p[:len(p)-1]
+		}
+
+		i, err := strconv.ParseUint(p, 10, 31)
+		if err != nil {
+			return "", fmt.Errorf("invalid path element [%s]", p)
+		}
+
+		k, err = k.derive(uint32(i) + offset)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return k.String(), nil
+}
+
+//ConvertExtendedKey changes SLIP-132 prefix, private key may be converted to public, not vice versa
+func ConvertExtendedKey(key, prefix string) (string, error) {
+	k, current, err := parseExtendedKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	version, ok := slip132Versions[prefix]
+	if !ok {
+		return "", fmt.Errorf("unknown prefix %s", prefix)
+	}
+
+	_, toPrivate := slip132Public[prefix]
+
+	switch {
+	case toPrivate && !k.isPrivate():
+		return "", fmt.Errorf("can't convert %s public key to %s", current, prefix)
+	case !toPrivate && k.isPrivate():
+		k.key = k.pubKey()
+	}
+
+	k.version = version
+
+	return k.String(), nil
+}
+
+//ExtendedKeyToWIF returns compressed WIF of private extended key for coin
+func ExtendedKeyToWIF(tag, key string) (string, error) {
+	k, prefix, err := parseExtendedKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	if !k.isPrivate() {
+		return "", fmt.Errorf("%s is public key", prefix)
+	}
+
+	return HexToWIF(tag, hex.EncodeToString(k.key[1:]), true)
+}
+
+//ImportKey stores private key of bitcoin based coin as service account, key may be given in any format accepted
+//by ConvertKey, extended private key is derived by path first if path is not empty, existing service account is
+//replaced only with overwrite
+func ImportKey(s *gutils.Storage, coinTag, key, passphrase, path string, overwrite bool) (string, error) {
+	var err error
+
+	if !overwrite && CoinInfoExists(s, coinTag) {
+		return "", fmt.Errorf("Coin.%s.JSON already exists", coinTag)
+	}
+
+	if len(path) > 0 {
+		key, err = DeriveExtendedKey(strings.TrimSpace(key), path)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	wif, err := ConvertKey(coinTag, key, KeyFormatWIF, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	acc, err := NewBitcoinAPI(0, coinTag, Coins[coinTag]).CreateAccount(wif)
+	if err != nil {
+		return "", fmt.Errorf("CreateAccount: %v", err)
+	}
+
+	err = setCoinInfo(s, coinTag, acc.Address, acc.PrivateKey, overwrite)
+	if err != nil {
+		return "", fmt.Errorf("SetCoinInfo: %v", err)
+	}
+
+	return acc.Address, nil
+}
+
+//ConvertKey converts private key of bitcoin based coin between hex, WIF (compressed/uncompressed) and BIP38
+//input format is detected, passphrase is used for BIP38 on either side
+func ConvertKey(tag, key, format, passphrase string) (string, error) {
+	var err error
+	var wif string
+
+	key = strings.TrimSpace(key)
+
+	switch {
+	case strings.HasPrefix(key, "6P"):
+		wif, err = DecryptBIP38(tag, key, passphrase)
+	case len(strings.TrimPrefix(key, "0x")) == 64:
+		wif, err = HexToWIF(tag, key, true)
+	default:
+		if _, _, perr := parseExtendedKey(key); perr == nil {
+			wif, err = ExtendedKeyToWIF(tag, key)
+		} else {
+			_, _, err = WIFToHex(tag, key)
+			wif = key
+		}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	keyHex, _, err := WIFToHex(tag, wif)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case KeyFormatHex:
+		return keyHex, nil
+	case KeyFormatWIF:
+		return HexToWIF(tag, keyHex, true)
+	case KeyFormatWIFUncompressed:
+		return HexToWIF(tag, keyHex, false)
+	case KeyFormatBIP38:
+		if len(passphrase) == 0 {
+			return "", fmt.Errorf("passphrase required")
+		}
+
+		return EncryptBIP38(tag, wif, passphrase)
+	}
+
+	if _, ok := slip132Versions[format]; ok {
+		return "", fmt.Errorf("extended key can't be built from single key, use ConvertExtendedKey")
+	}
+
+	return "", fmt.Errorf("unknown key format %s", format)
+}
@@ -0,0 +1,173 @@
+package coinapi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBIP38Vectors(t *testing.T) {
+	// BIP-38 no EC multiply vectors
+	tests := []struct {
+		encrypted  string
+		passphrase string
+		wif        string
+	}{
+		{"6PRVWUbkzzsbcVac2qwfssoUJAN1Xhrg6bNk8J7Nzm5H7kxEbn2Nh2ZoGg", "TestingOneTwoThree", "5KN7MzqK5wt2TP1fQCYyHBtDrXdJuXbUzm4A9rKAteGu3Qi5CVR"},
+		{"6PRNFFkZc2NZ6dJqFfhRoFNMR9Lnyj7dYGrzdgXXVMXcxoKTePPX1dWByq", "Satoshi", "5HtasZ6ofTHP6HCwTqTkLDuLQisYPah7aUnSKfC7h4hMUVw2gi5"},
+		{"6PYNKZ1EAgYgmQfmNVamxyXVWHzK5s6DGhwP4J5o44cvXdoY7sRzhtpUeo", "TestingOneTwoThree", "L44B5gGEpqEDRS9vVPz7QT35jcBG2r3CZwSwQ4fCewXAhAhqGVpP"},
+		{"6PYLtMnXvfG3oJde97zRyLYFZCYizPU5T3LwgdYJz1fRhh16bU7u6PPmY7", "Satoshi", "KwYgW8gcxj1JWJXhPSu4Fqwzfhp5Yfi42mdYmMa4XqK7NJxXUSK7"},
+	}
+
+	for _, tt := range tests {
+		wif, err := DecryptBIP38(CoinBTC, tt.encrypted, tt.passphrase)
+		if err != nil || wif != tt.wif {
+			t.Errorf("decrypt %s: %s err %v", tt.encrypted, wif, err)
+		}
+
+		encrypted, err := EncryptBIP38(CoinBTC, tt.wif, tt.passphrase)
+		if err != nil || encrypted != tt.encrypted {
+			t.Errorf("encrypt %s: %s err %v", tt.wif, encrypted, err)
+		}
+
+		_, err = DecryptBIP38(CoinBTC, tt.encrypted, tt.passphrase+"x")
+		if err == nil {
+			t.Errorf("%s: decrypted with wrong passphrase", tt.encrypted)
+		}
+	}
+}
+
+func TestWIF(t *testing.T) {
+	const key = "0c28fca386c7a227600b2fe50b7cae11ec86d3bf1fbe471be89827e19d72aa1d"
+
+	tests := []struct {
+		wif        string
+		compressed bool
+	}{
+		{"5HueCGU8rMjxEXxiPuD5BDku4MkFqeZyd4dZ1jvhTVqvbTLvyTJ", false},
+		{"KwdMAjGmerYanjeui5SHS7JkmpZvVipYvB2LJGU1ZxJwYvP98617", true},
+	}
+
+	for _, tt := range tests {
+		keyHex, compressed, err := WIFToHex(CoinBTC, tt.wif)
+		if err != nil || keyHex != key || compressed != tt.compressed {
+			t.Errorf("%s: key %s compressed %v err %v", tt.wif, keyHex, compressed, err)
+		}
+
+		wif, err := HexToWIF(CoinBTC, key, tt.compressed)
+		if err != nil || wif != tt.wif {
+			t.Errorf("%s: wif %s err %v", key, wif, err)
+		}
+
+		// version byte of another coin
+		_, _, err = WIFToHex(CoinLTC, tt.wif)
+		if err == nil {
+			t.Errorf("%s: accepted as LTC key", tt.wif)
+		}
+	}
+
+	for _, format := range []string{KeyFormatHex, KeyFormatWIF, KeyFormatWIFUncompressed, KeyFormatBIP38} {
+		converted, err := ConvertKey(CoinBTC, "0x"+key, format, "TestingOneTwoThree")
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		back, err := ConvertKey(CoinBTC, converted, KeyFormatHex, "TestingOneTwoThree")
+		if err != nil || back != key {
+			t.Errorf("%s %s: back %s err %v", format, converted, back, err)
+		}
+	}
+}
+
+func TestExtendedKeys(t *testing.T) {
+	// BIP-32 test vector 1
+	const (
+		xprv = "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi"
+		xpub = "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8"
+	)
+
+	tests := []struct {
+		path string
+		prv  string
+		pub  string
+	}{
+		{"m/0'", "xprv9uHRZZhk6KAJC1avXpDAp4MDc3sQKNxDiPvvkX8Br5ngLNv1TxvUxt4cV1rGL5hj6KCesnDYUhd7oWgT11eZG7XnxHrnYeSvkzY7d2bhkJ7",
+			"xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw"},
+		{"m/0h/1", "xprv9wTYmMFdV23N2TdNG573QoEsfRrWKQgWeibmLntzniatZvR9BmLnvSxqu53Kw1UmYPxLgboyZQaXwTCg8MSY3H2EU4pWcQDnRnrVA1xe8fs",
+			"xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ"},
+	}
+
+	pub, err := ConvertExtendedKey(xprv, "xpub")
+	if err != nil || pub != xpub {
+		t.Errorf("master xpub %s err %v", pub, err)
+	}
+
+	for _, tt := range tests {
+		prv, err := DeriveExtendedKey(xprv, tt.path)
+		if err != nil || prv != tt.prv {
+			t.Errorf("%s: %s err %v", tt.path, prv, err)
+		}
+
+		pub, err := ConvertExtendedKey(prv, "xpub")
+		if err != nil || pub != tt.pub {
+			t.Errorf("%s: %s err %v", tt.path, pub, err)
+		}
+	}
+
+	// public parent derives only non hardened children
+	_, err = DeriveExtendedKey(xpub, "m/0'")
+	if err == nil {
+		t.Error("hardened child derived from public key")
+	}
+
+	_, err = ConvertExtendedKey(xpub, "xprv")
+	if err == nil {
+		t.Error("public key converted to private")
+	}
+
+	// SLIP-132 prefixes change version only
+	for _, prefix := range []string{"ypub", "zpub", "Ltub", "Mtub", "dgub"} {
+		converted, err := ConvertExtendedKey(xpub, prefix)
+		if err != nil || !strings.HasPrefix(converted, prefix) {
+			t.Fatalf("%s: %s err %v", prefix, converted, err)
+		}
+
+		back, err := ConvertExtendedKey(converted, "xpub")
+		if err != nil || back != xpub {
+			t.Errorf("%s: back %s err %v", prefix, back, err)
+		}
+	}
+}
+
+func TestBIP84Account(t *testing.T) {
+	// BIP-84 test vector, mnemonic "abandon abandon ... about"
+	const rootPriv = "zprvAWgYBBk7JR8Gjrh4UJQ2uJdG1r3WNRRfURiABBE3RvMXYSrRJL62XuezvGdPvG6GFBZduosCc1YP5wixPox7zhZLfiUm8aunE96BBa4Kei5"
+
+	account, err := DeriveExtendedKey(rootPriv, "m/84'/0'/0'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if account != "zprvAdG4iTXWBoARxkkzNpNh8r6Qag3irQB8PzEMkAFeTRXxHpbF9z4QgEvBRmfvqWvGp42t42nvgGpNgYSJA9iefm1yYNZKEm7z6qUWCroSQnE" {
+		t.Errorf("account xprv %s", account)
+	}
+
+	pub, err := ConvertExtendedKey(account, "zpub")
+	if err != nil || pub != "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs" {
+		t.Errorf("account xpub %s err %v", pub, err)
+	}
+
+	// first receiving address key of account
+	wif, err := ExtendedKeyToWIF(CoinBTC, mustDerive(t, account, "m/0/0"))
+	if err != nil || wif != "KyZpNDKnfs94vbrwhJneDi77V6jF64PWPF8x5cdJb8ifgg2DUc9d" {
+		t.Errorf("m/84'/0'/0'/0/0 wif %s err %v", wif, err)
+	}
+}
+
+func mustDerive(t *testing.T, key, path string) string {
+	derived, err := DeriveExtendedKey(key, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return derived
+}
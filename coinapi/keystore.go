@@ -0,0 +1,277 @@
+package coinapi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/seagiv/common/gutils"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Web3 Secret Storage defaults, same as geth "standard" parameters
+const (
+	keystoreVersion = 3
+	keystoreCipher  = "aes-128-ctr"
+	keystoreScryptN = 1 << 18
+	keystoreScryptR = 8
+	keystoreScryptP = 1
+	keystoreDKLen   = 32
+)
+
+// limits of kdf parameters read from keystore file, scrypt takes 128*N*r bytes of memory
+const (
+	keystoreScryptMaxMem = 1 << 30
+	keystoreScryptMaxP   = 16
+	keystorePBKDF2MaxC   = 10000000
+	keystoreMaxDKLen     = 64
+)
+
+type keystoreKDFParams struct {
+	N     int    `json:"n,omitempty"`
+	R     int    `json:"r,omitempty"`
+	P     int    `json:"p,omitempty"`
+	C     int    `json:"c,omitempty"`
+	PRF   string `json:"prf,omitempty"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+type keystoreCrypto struct {
+	Cipher       string            `json:"cipher"`
+	CipherText   string            `json:"ciphertext"`
+	CipherParams map[string]string `json:"cipherparams"`
+	KDF          string            `json:"kdf"`
+	KDFParams    keystoreKDFParams `json:"kdfparams"`
+	MAC          string            `json:"mac"`
+}
+
+//KeystoreV3 Web3 Secret Storage V3 file
+type KeystoreV3 struct {
+	Address string         `json:"address"`
+	Crypto  keystoreCrypto `json:"crypto"`
+	ID      string         `json:"id"`
+	Version int            `json:"version"`
+}
+
+func (p *keystoreKDFParams) deriveKey(kdf string, password []byte) ([]byte, error) {
+	salt, err := hex.DecodeString(p.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt")
+	}
+
+	if p.DKLen < 32 || p.DKLen > keystoreMaxDKLen {
+		return nil, fmt.Errorf("dklen %d out of range", p.DKLen)
+	}
+
+	switch kdf {
+	case "scrypt":
+		if p.N <= 1 || p.R <= 0 || p.P <= 0 || p.P > keystoreScryptMaxP || p.N > keystoreScryptMaxMem/128/p.R {
+			return nil, fmt.Errorf("scrypt parameters n=%d r=%d p=%d out of range", p.N, p.R, p.P)
+		}
+
+		return scrypt.Key(password, salt, p.N, p.R, p.P, p.DKLen)
+
+	case "pbkdf2":
+		if p.PRF != "hmac-sha256" {
+			return nil, fmt.Errorf("unsupported prf %s", p.PRF)
+		}
+
+		if p.C <= 0 || p.C > keystorePBKDF2MaxC {
+			return nil, fmt.Errorf("pbkdf2 iterations %d out of range", p.C)
+		}
+
+		return pbkdf2.Key(password, salt, p.C, p.DKLen, sha256.New), nil
+	}
+
+	return nil, fmt.Errorf("unsupported kdf %s", kdf)
+}
+
+func aes128CTR(key, iv, in []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(in))
+
+	cipher.NewCTR(block, iv).XORKeyStream(out, in)
+
+	return out, nil
+}
+
+//DecryptKeystoreV3 returns hex private key and 0x address of keystore file
+func DecryptKeystoreV3(keystoreJSON []byte, password string) (string, string, error) {
+	var ks KeystoreV3
+
+	err := json.Unmarshal(keystoreJSON, &ks)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid keystore: %v", err)
+	}
+
+	if ks.Version != keystoreVersion {
+		return "", "", fmt.Errorf("unsupported keystore version %d", ks.Version)
+	}
+
+	if ks.Crypto.Cipher != keystoreCipher {
+		return "", "", fmt.Errorf("unsupported cipher %s", ks.Crypto.Cipher)
+	}
+
+	cipherText, err := hex.DecodeString(ks.Crypto.CipherText)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid ciphertext")
+	}
+
+	iv, err := hex.DecodeString(ks.Crypto.CipherParams["iv"])
+	if err != nil || len(iv) != aes.BlockSize {
+		return "", "", fmt.Errorf("invalid iv")
+	}
+
+	mac, err := hex.DecodeString(ks.Crypto.MAC)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid mac")
+	}
+
+	derivedKey, err := ks.Crypto.KDFParams.deriveKey(ks.Crypto.KDF, []byte(password))
+	if err != nil {
+		return "", "", err
+	}
+
+	if subtle.ConstantTimeCompare(crypto.Keccak256(derivedKey[16:32], cipherText), mac) != 1 {
+		return "", "", fmt.Errorf("wrong password or corrupted keystore")
+	}
+
+	keyB, err := aes128CTR(derivedKey[:16], iv, cipherText)
+	if err != nil {
+		return "", "", err
+	}
+
+	privKey, err := crypto.ToECDSA(keyB)
+	if err != nil {
+		return "", "", fmt.Errorf("ToECDSA: %v", err)
+	}
+
+	address := crypto.PubkeyToAddress(privKey.PublicKey).Hex()
+
+	if len(ks.Address) > 0 && !strings.EqualFold(strings.TrimPrefix(ks.Address, "0x"), address[2:]) {
+		return "", "", fmt.Errorf("keystore address [%s] does not match key address [%s]", ks.Address, address)
+	}
+
+	return hex.EncodeToString(keyB), address, nil
+}
+
+//EncryptKeystoreV3 encrypts hex private key with scrypt and aes-128-ctr
+func EncryptKeystoreV3(key, password string) ([]byte, error) {
+	keyB, err := hex.DecodeString(strings.TrimPrefix(key, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key encoding")
+	}
+	defer gutils.WipeBytes(keyB)
+
+	privKey, err := crypto.ToECDSA(keyB)
+	if err != nil {
+		return nil, fmt.Errorf("ToECDSA: %v", err)
+	}
+	defer wipeECDSA(privKey)
+
+	random, err := gutils.GetRandomBuffer(32 + aes.BlockSize + 16)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, iv, id := random[:32], random[32:32+aes.BlockSize], random[32+aes.BlockSize:]
+
+	// uuid v4
+	id[6] = id[6]&0x0F | 0x40
+	id[8] = id[8]&0x3F | 0x80
+
+	params := keystoreKDFParams{N: keystoreScryptN, R: keystoreScryptR, P: keystoreScryptP, DKLen: keystoreDKLen, Salt: hex.EncodeToString(salt)}
+
+	derivedKey, err := params.deriveKey("scrypt", []byte(password))
+	if err != nil {
+		return nil, err
+	}
+
+	cipherText, err := aes128CTR(derivedKey[:16], iv, keyB)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := KeystoreV3{
+		Address: strings.ToLower(crypto.PubkeyToAddress(privKey.PublicKey).Hex()[2:]),
+		Crypto: keystoreCrypto{
+			Cipher:       keystoreCipher,
+			CipherText:   hex.EncodeToString(cipherText),
+			CipherParams: map[string]string{"iv": hex.EncodeToString(iv)},
+			KDF:          "scrypt",
+			KDFParams:    params,
+			MAC:          hex.EncodeToString(crypto.Keccak256(derivedKey[16:32], cipherText)),
+		},
+		ID:      fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]),
+		Version: keystoreVersion,
+	}
+
+	return json.Marshal(ks)
+}
+
+//CoinInfoExists reports whether service account of coin is already stored
+func CoinInfoExists(s *gutils.Storage, coinTag string) bool {
+	_, ok := s.Meta("Coin." + coinTag + ".JSON")
+
+	return ok
+}
+
+//setCoinInfo stores service account, existing one is replaced only with overwrite
+func setCoinInfo(s *gutils.Storage, coinTag, address, key string, overwrite bool) error {
+	if !overwrite && CoinInfoExists(s, coinTag) {
+		return fmt.Errorf("Coin.%s.JSON already exists", coinTag)
+	}
+
+	return s.SetCoinInfo(coinTag, address, key)
+}
+
+//ImportKeystore decrypts keystore file and stores key as service account of EVM coin in storage, existing service
+//account is replaced only with overwrite
+func ImportKeystore(s *gutils.Storage, coinTag string, keystoreJSON []byte, password string, overwrite bool) (string, error) {
+	if Coins[coinTag] == nil || Coins[coinTag].E.C2C.IsZero() {
+		return "", fmt.Errorf("coin %s is not EVM coin", coinTag)
+	}
+
+	if !overwrite && CoinInfoExists(s, coinTag) {
+		return "", fmt.Errorf("Coin.%s.JSON already exists", coinTag)
+	}
+
+	key, address, err := DecryptKeystoreV3(keystoreJSON, password)
+	if err != nil {
+		return "", err
+	}
+
+	err = setCoinInfo(s, coinTag, address, key, overwrite)
+	if err != nil {
+		return "", fmt.Errorf("SetCoinInfo: %v", err)
+	}
+
+	return address, nil
+}
+
+//ExportKeystore encrypts service account key of EVM coin from storage as keystore file
+func ExportKeystore(s *gutils.Storage, coinTag, password string) ([]byte, error) {
+	if Coins[coinTag] == nil || Coins[coinTag].E.C2C.IsZero() {
+		return nil, fmt.Errorf("coin %s is not EVM coin", coinTag)
+	}
+
+	_, key, err := s.GetCoinInfo(coinTag)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Release()
+
+	return EncryptKeystoreV3(key.UnsafeString(), password)
+}
@@ -0,0 +1,123 @@
+package coinapi
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const (
+	testKeystorePassword = "testpassword"
+	testKeystoreKey      = "7a28b5ba57c53603b0b07b56bba752f7784bf506fa95edc395f5cf6c7514fe9d"
+	testKeystoreAddress  = "0x008AeEda4D805471dF9b2A5B0f38A0C3bCBA786b"
+)
+
+// Web3 Secret Storage definition test vectors
+var testKeystoreVectors = map[string]string{
+	"pbkdf2": `{
+		"crypto": {
+			"cipher": "aes-128-ctr",
+			"cipherparams": {"iv": "6087dab2f9fdbbfaddc31a909735c1e6"},
+			"ciphertext": "5318b4d5bcd28de64ee5559e671353e16f075ecae9f99c7a79a38af5f869aa46",
+			"kdf": "pbkdf2",
+			"kdfparams": {"c": 262144, "dklen": 32, "prf": "hmac-sha256", "salt": "ae3cd4e7013836a3df6bd7241b12db061dbe2c6785853cce422d148a624ce0bd"},
+			"mac": "517ead924a9d0dc3124507e3393d175ce3ff7c1e96529c6c555ce9e51205e9b2"
+		},
+		"id": "3198bc9c-6672-5ab3-d995-4942343ae5b6",
+		"version": 3
+	}`,
+	"scrypt": `{
+		"crypto": {
+			"cipher": "aes-128-ctr",
+			"cipherparams": {"iv": "83dbcc02d8ccb40e466191a123791e0e"},
+			"ciphertext": "d172bf743a674da9cdad04534d56926ef8358534d458fffccd4e6ad2fbde479c",
+			"kdf": "scrypt",
+			"kdfparams": {"dklen": 32, "n": 262144, "p": 8, "r": 1, "salt": "ab0c7876052600dd703518d6fc3fe8984592145b591fc8fb5c6d43190334ba19"},
+			"mac": "2103ac29920d71da29f15d75b4a16dbe95cfd7ff8faea1056c33131d846e3097"
+		},
+		"id": "3198bc9c-6672-5ab3-d995-4942343ae5b6",
+		"version": 3
+	}`,
+}
+
+func TestDecryptKeystoreV3Vectors(t *testing.T) {
+	for kdf, vector := range testKeystoreVectors {
+		key, address, err := DecryptKeystoreV3([]byte(vector), testKeystorePassword)
+		if err != nil {
+			t.Fatalf("%s: %v", kdf, err)
+		}
+
+		if key != testKeystoreKey || address != testKeystoreAddress {
+			t.Errorf("%s: key %s address %s", kdf, key, address)
+		}
+
+		_, _, err = DecryptKeystoreV3([]byte(vector), testKeystorePassword+"x")
+		if err == nil {
+			t.Errorf("%s: decrypted with wrong password", kdf)
+		}
+
+		// keystore address must belong to key
+		var ks map[string]interface{}
+
+		json.Unmarshal([]byte(vector), &ks)
+		ks["address"] = "0000000000000000000000000000000000000001"
+
+		wrongAddress, _ := json.Marshal(ks)
+
+		_, _, err = DecryptKeystoreV3(wrongAddress, testKeystorePassword)
+		if err == nil {
+			t.Errorf("%s: decrypted with wrong address", kdf)
+		}
+	}
+}
+
+func TestKeystoreV3RoundTrip(t *testing.T) {
+	data, err := EncryptKeystoreV3("0x"+testKeystoreKey, testKeystorePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ks KeystoreV3
+
+	err = json.Unmarshal(data, &ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ks.Version != keystoreVersion || ks.Crypto.KDF != "scrypt" || ks.Crypto.KDFParams.N != keystoreScryptN || "0x"+ks.Address != strings.ToLower(testKeystoreAddress) {
+		t.Errorf("unexpected keystore %s", data)
+	}
+
+	key, address, err := DecryptKeystoreV3(data, testKeystorePassword)
+	if err != nil || key != testKeystoreKey || address != testKeystoreAddress {
+		t.Errorf("key %s address %s err %v", key, address, err)
+	}
+
+	_, _, err = DecryptKeystoreV3(data, "")
+	if err == nil {
+		t.Error("decrypted with wrong password")
+	}
+}
+
+func TestKeystoreKDFLimits(t *testing.T) {
+	salt := strings.Repeat("00", 32)
+
+	tests := []struct {
+		kdf    string
+		params keystoreKDFParams
+	}{
+		{"scrypt", keystoreKDFParams{N: 1 << 30, R: 8, P: 1, DKLen: 32, Salt: salt}},
+		{"scrypt", keystoreKDFParams{N: 1 << 10, R: 8, P: 1 << 10, DKLen: 32, Salt: salt}},
+		{"scrypt", keystoreKDFParams{N: 1 << 10, R: 8, P: 1, DKLen: 1 << 20, Salt: salt}},
+		{"pbkdf2", keystoreKDFParams{C: 1 << 30, PRF: "hmac-sha256", DKLen: 32, Salt: salt}},
+		{"pbkdf2", keystoreKDFParams{C: 1, PRF: "hmac-sha512", DKLen: 32, Salt: salt}},
+		{"argon2", keystoreKDFParams{DKLen: 32, Salt: salt}},
+	}
+
+	for _, tt := range tests {
+		_, err := tt.params.deriveKey(tt.kdf, []byte(testKeystorePassword))
+		if err == nil {
+			t.Errorf("%s %+v: accepted", tt.kdf, tt.params)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package coinapi
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seagiv/foreign/decimal"
+	"github.com/seagiv/foreign/jsonrpcf"
+)
+
+//rpc error classes
+const (
+	ErrorClassNone    = ""
+	ErrorClassTimeout = "timeout"
+	ErrorClassNetwork = "network"
+	ErrorClassRPC     = "rpc"
+	ErrorClassOther   = "other"
+)
+
+//Metrics receives coin operation measurements, host program decides how to export them
+type Metrics interface {
+	ObserveRPC(coin, method string, duration time.Duration, errorClass string)
+	TxSent(coin string, amount, fee decimal.Decimal)
+	SetServiceUTXO(coin string, count int, balance decimal.Decimal)
+	SetNonceDrift(coin string, drift int64)
+	SetNodeHeight(coin string, height int64)
+}
+
+type noMetrics struct{}
+
+func (noMetrics) ObserveRPC(coin, method string, duration time.Duration, errorClass string) {}
+func (noMetrics) TxSent(coin string, amount, fee decimal.Decimal)                           {}
+func (noMetrics) SetServiceUTXO(coin string, count int, balance decimal.Decimal)            {}
+func (noMetrics) SetNonceDrift(coin string, drift int64)                                    {}
+func (noMetrics) SetNodeHeight(coin string, height int64)                                   {}
+
+var (
+	metricsLock sync.RWMutex
+	metricsSink Metrics = noMetrics{}
+)
+
+//SetMetrics sets metrics receiver, nil disables metrics, may be called while coins are in use
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = noMetrics{}
+	}
+
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	metricsSink = m
+}
+
+//metrics current metrics receiver
+func metrics() Metrics {
+	metricsLock.RLock()
+	defer metricsLock.RUnlock()
+
+	return metricsSink
+}
+
+//ErrorClass classifies rpc call error
+func ErrorClass(err error) string {
+	if err == nil {
+		return ErrorClassNone
+	}
+
+	if ne, ok := err.(net.Error); ok {
+		if ne.Timeout() {
+			return ErrorClassTimeout
+		}
+
+		return ErrorClassNetwork
+	}
+
+	var rpcError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	// node side errors come as json encoded {code, message}
+	if json.Unmarshal([]byte(err.Error()), &rpcError) == nil && len(rpcError.Message) > 0 {
+		return ErrorClassRPC
+	}
+
+	msg := strings.ToLower(err.Error())
+
+	switch {
+	case strings.Contains(msg, "timeout"), strings.Contains(msg, "deadline exceeded"):
+		return ErrorClassTimeout
+	case strings.Contains(msg, "connection refused"), strings.Contains(msg, "no such host"), strings.Contains(msg, "connection reset"), strings.Contains(msg, "eof"):
+		return ErrorClassNetwork
+	}
+
+	return ErrorClassOther
+}
+
+//rpcClient jsonrpcf client reporting every call to metrics
+type rpcClient struct {
+	*jsonrpcf.Client
+
+	tag string
+}
+
+func newRPCClient(tag, url string) *rpcClient {
+	return &rpcClient{Client: jsonrpcf.NewHTTPClient(url), tag: tag}
+}
+
+//Call -
+func (c *rpcClient) Call(method string, params interface{}, reply interface{}) error {
+	start := time.Now()
+
+	err := c.Client.Call(method, params, reply)
+
+	metrics().ObserveRPC(c.tag, method, time.Since(start), ErrorClass(err))
+
+	return err
+}
+
+//reportServiceUTXOs reports count and balance of service address unspent outputs
+func reportServiceUTXOs(tag string, utxos []UTXO) {
+	balance := decimal.Zero
+
+	for _, u := range utxos {
+		balance = balance.Add(u.Amount)
+	}
+
+	metrics().SetServiceUTXO(tag, len(utxos), balance)
+}
@@ -0,0 +1,128 @@
+package coinapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/seagiv/foreign/decimal"
+)
+
+//PrometheusMetrics Metrics implementation backed by own prometheus registry
+type PrometheusMetrics struct {
+	registry *prometheus.Registry
+
+	rpcDuration *prometheus.HistogramVec
+	rpcErrors   *prometheus.CounterVec
+	txSent      *prometheus.CounterVec
+	feesPaid    *prometheus.CounterVec
+	utxoCount   *prometheus.GaugeVec
+	balance     *prometheus.GaugeVec
+	nonceDrift  *prometheus.GaugeVec
+	nodeHeight  *prometheus.GaugeVec
+}
+
+//NewPrometheusMetrics creates and registers coin metrics under namespace
+func NewPrometheusMetrics(namespace string) *PrometheusMetrics {
+	m := &PrometheusMetrics{registry: prometheus.NewRegistry()}
+
+	m.rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Node RPC call latency.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"coin", "method"})
+
+	m.rpcErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_errors_total",
+		Help:      "Failed node RPC calls by error class.",
+	}, []string{"coin", "method", "class"})
+
+	m.txSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tx_sent_total",
+		Help:      "Broadcast transactions.",
+	}, []string{"coin"})
+
+	m.feesPaid = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fees_paid_total",
+		Help:      "Fees paid in coin units.",
+	}, []string{"coin"})
+
+	m.utxoCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_utxo_count",
+		Help:      "Unspent outputs of service address.",
+	}, []string{"coin"})
+
+	m.balance = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_balance",
+		Help:      "Confirmed balance of service address in coin units.",
+	}, []string{"coin"})
+
+	m.nonceDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "nonce_drift",
+		Help:      "Cached nonce minus node pending nonce.",
+	}, []string{"coin"})
+
+	m.nodeHeight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_height",
+		Help:      "Last seen node block height.",
+	}, []string{"coin"})
+
+	m.registry.MustRegister(m.rpcDuration, m.rpcErrors, m.txSent, m.feesPaid, m.utxoCount, m.balance, m.nonceDrift, m.nodeHeight)
+
+	return m
+}
+
+//Registry returns underlying registry, host may add own collectors
+func (m *PrometheusMetrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+//Handler returns http handler serving metrics in prometheus exposition format
+func (m *PrometheusMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+//ObserveRPC -
+func (m *PrometheusMetrics) ObserveRPC(coin, method string, duration time.Duration, errorClass string) {
+	m.rpcDuration.WithLabelValues(coin, method).Observe(duration.Seconds())
+
+	if errorClass != ErrorClassNone {
+		m.rpcErrors.WithLabelValues(coin, method, errorClass).Inc()
+	}
+}
+
+//TxSent -
+func (m *PrometheusMetrics) TxSent(coin string, amount, fee decimal.Decimal) {
+	m.txSent.WithLabelValues(coin).Inc()
+
+	f, _ := fee.Float64()
+
+	m.feesPaid.WithLabelValues(coin).Add(f)
+}
+
+//SetServiceUTXO -
+func (m *PrometheusMetrics) SetServiceUTXO(coin string, count int, balance decimal.Decimal) {
+	b, _ := balance.Float64()
+
+	m.utxoCount.WithLabelValues(coin).Set(float64(count))
+	m.balance.WithLabelValues(coin).Set(b)
+}
+
+//SetNonceDrift -
+func (m *PrometheusMetrics) SetNonceDrift(coin string, drift int64) {
+	m.nonceDrift.WithLabelValues(coin).Set(float64(drift))
+}
+
+//SetNodeHeight -
+func (m *PrometheusMetrics) SetNodeHeight(coin string, height int64) {
+	m.nodeHeight.WithLabelValues(coin).Set(float64(height))
+}
@@ -0,0 +1,63 @@
+package coinapi
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/seagiv/common/gutils"
+)
+
+//decodeWIFKey parses WIF kept in secure buffer without copying it to string, result must be wiped by wipeWIF
+func decodeWIFKey(key *gutils.SecureBuffer) (*btcutil.WIF, error) {
+	return btcutil.DecodeWIF(key.UnsafeString())
+}
+
+//wipeWIF zeroes private scalar of parsed key
+func wipeWIF(wif *btcutil.WIF) {
+	if wif != nil && wif.PrivKey != nil {
+		gutils.WipeBigInt(wif.PrivKey.D)
+	}
+}
+
+//hexToECDSAKey parses hex key kept in secure buffer, decoded bytes never leave secure memory,
+//result must be wiped by wipeECDSA
+func hexToECDSAKey(key *gutils.SecureBuffer) (*ecdsa.PrivateKey, error) {
+	b, err := gutils.NewSecureBuffer(hex.DecodedLen(key.Len()))
+	if err != nil {
+		return nil, err
+	}
+	defer b.Release()
+
+	_, err = hex.Decode(b.Bytes(), key.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.ToECDSA(b.Bytes())
+}
+
+//wipeECDSA zeroes private scalar of parsed key
+func wipeECDSA(key *ecdsa.PrivateKey) {
+	if key != nil {
+		gutils.WipeBigInt(key.D)
+	}
+}
+
+//releaseKeys releases all keys of map
+func releaseKeys(keys map[string]*gutils.SecureBuffer) {
+	for _, key := range keys {
+		key.Release()
+	}
+}
+
+func isKeyIn(key *gutils.SecureBuffer, keys []*gutils.SecureBuffer) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,267 @@
+package coinapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/seagiv/common/gutils"
+	"github.com/seagiv/foreign/decimal"
+)
+
+// movement kinds
+const (
+	MovementReceived = "received"
+	MovementSpent    = "spent"
+	MovementAccount  = "account"
+)
+
+//SnapshotUTXO unspent output of service address, age is in blocks
+type SnapshotUTXO struct {
+	TxID      string
+	Vout      uint32
+	Amount    decimal.Decimal
+	AgeBlocks int
+}
+
+//SnapshotTx pending outgoing transaction
+type SnapshotTx struct {
+	TxHash string
+	Amount decimal.Decimal
+	Fee    decimal.Decimal
+	Time   int64
+}
+
+//Snapshot state of service address at some point of time
+type Snapshot struct {
+	Coin    string
+	Address string
+	Time    time.Time
+	Height  int64
+
+	Confirmed   decimal.Decimal
+	Unconfirmed decimal.Decimal
+
+	UTXOs   []SnapshotUTXO `json:",omitempty"`
+	Pending []SnapshotTx   `json:",omitempty"`
+
+	// ethereum only, nonces in [NonceLatest, NoncePending) are not mined yet
+	NonceLatest  uint64 `json:",omitempty"`
+	NoncePending uint64 `json:",omitempty"`
+	NonceCached  uint64 `json:",omitempty"`
+}
+
+//SnapshotMovement balance change, received movement is attributed to transaction created outputs, spent movement
+//is one spent output as spending transaction is not known from utxo sets, account movement is whole change of
+//account based coin, TxHash is empty for both
+type SnapshotMovement struct {
+	TxHash  string `json:",omitempty"`
+	Output  string `json:",omitempty"` // txid:vout of spent output
+	Kind    string
+	Amount  decimal.Decimal
+	Outputs int
+}
+
+//SnapshotDiff movements between two snapshots
+type SnapshotDiff struct {
+	Coin string
+
+	From       time.Time
+	To         time.Time
+	HeightFrom int64
+	HeightTo   int64
+
+	ConfirmedChange   decimal.Decimal
+	UnconfirmedChange decimal.Decimal
+
+	Movements []SnapshotMovement
+
+	NoncesUsed uint64 `json:",omitempty"`
+
+	// part of total change not explained by movements, must be zero for utxo coins
+	Unexplained decimal.Decimal
+}
+
+//Total confirmed plus unconfirmed balance
+func (s *Snapshot) Total() decimal.Decimal {
+	return s.Confirmed.Add(s.Unconfirmed)
+}
+
+//Save stores snapshot as JSON file
+func (s *Snapshot) Save(fileName string) error {
+	return gutils.SaveObject(fileName, s)
+}
+
+//LoadSnapshot loads snapshot stored by Save
+func LoadSnapshot(fileName string) (*Snapshot, error) {
+	var s Snapshot
+
+	err := gutils.LoadObject(fileName, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+//WriteJSON -
+func (s *Snapshot) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+
+	return e.Encode(s)
+}
+
+//WriteCSV writes snapshot as flat records: balance, unconfirmed, utxo, pending, nonce
+func (s *Snapshot) WriteCSV(w io.Writer) error {
+	c := csv.NewWriter(w)
+
+	row := func(record, txid string, vout uint32, amount, fee decimal.Decimal, age int64, nonce uint64) {
+		c.Write([]string{record, s.Coin, s.Address, txid, strconv.FormatUint(uint64(vout), 10), amount.String(), fee.String(), strconv.FormatInt(age, 10), strconv.FormatUint(nonce, 10)})
+	}
+
+	c.Write([]string{"record", "coin", "address", "txid", "vout", "amount", "fee", "age", "nonce"})
+
+	row("balance", "", 0, s.Confirmed, decimal.Zero, 0, 0)
+	row("unconfirmed", "", 0, s.Unconfirmed, decimal.Zero, 0, 0)
+
+	for _, u := range s.UTXOs {
+		row("utxo", u.TxID, u.Vout, u.Amount, decimal.Zero, int64(u.AgeBlocks), 0)
+	}
+
+	for _, p := range s.Pending {
+		row("pending", p.TxHash, 0, p.Amount, p.Fee, s.Time.Unix()-p.Time, 0)
+	}
+
+	if s.NoncePending > 0 {
+		row("nonce_latest", "", 0, decimal.Zero, decimal.Zero, 0, s.NonceLatest)
+		row("nonce_pending", "", 0, decimal.Zero, decimal.Zero, 0, s.NoncePending)
+		row("nonce_cached", "", 0, decimal.Zero, decimal.Zero, 0, s.NonceCached)
+	}
+
+	c.Flush()
+
+	return c.Error()
+}
+
+func utxoKey(txID string, vout uint32) string {
+	return fmt.Sprintf("%s:%d", txID, vout)
+}
+
+//DiffSnapshots explains balance change between two snapshots of the same coin by received transactions and
+//spent outputs
+func DiffSnapshots(from, to *Snapshot) (*SnapshotDiff, error) {
+	if from.Coin != to.Coin || from.Address != to.Address {
+		return nil, fmt.Errorf("snapshots of different accounts %s/%s and %s/%s", from.Coin, from.Address, to.Coin, to.Address)
+	}
+
+	d := &SnapshotDiff{
+		Coin:              from.Coin,
+		From:              from.Time,
+		To:                to.Time,
+		HeightFrom:        from.Height,
+		HeightTo:          to.Height,
+		ConfirmedChange:   to.Confirmed.Sub(from.Confirmed),
+		UnconfirmedChange: to.Unconfirmed.Sub(from.Unconfirmed),
+	}
+
+	if to.NonceLatest > from.NonceLatest {
+		d.NoncesUsed = to.NonceLatest - from.NonceLatest
+	}
+
+	oldUTXOs := make(map[string]SnapshotUTXO)
+	newUTXOs := make(map[string]SnapshotUTXO)
+
+	for _, u := range from.UTXOs {
+		oldUTXOs[utxoKey(u.TxID, u.Vout)] = u
+	}
+
+	for _, u := range to.UTXOs {
+		newUTXOs[utxoKey(u.TxID, u.Vout)] = u
+	}
+
+	received := make(map[string]*SnapshotMovement)
+
+	for key, u := range newUTXOs {
+		if _, ok := oldUTXOs[key]; ok {
+			continue
+		}
+
+		if received[u.TxID] == nil {
+			received[u.TxID] = &SnapshotMovement{TxHash: u.TxID, Kind: MovementReceived}
+		}
+
+		received[u.TxID].Amount = received[u.TxID].Amount.Add(u.Amount)
+		received[u.TxID].Outputs++
+	}
+
+	explained := decimal.Zero
+
+	for _, mv := range received {
+		d.Movements = append(d.Movements, *mv)
+
+		explained = explained.Add(mv.Amount)
+	}
+
+	// several outputs may be spent by one transaction or by different ones, each is reported on its own
+	for key, u := range oldUTXOs {
+		if _, ok := newUTXOs[key]; !ok {
+			d.Movements = append(d.Movements, SnapshotMovement{Output: key, Kind: MovementSpent, Amount: u.Amount.Neg(), Outputs: 1})
+
+			explained = explained.Sub(u.Amount)
+		}
+	}
+
+	sort.Slice(d.Movements, func(i, j int) bool {
+		if d.Movements[i].Kind != d.Movements[j].Kind {
+			return d.Movements[i].Kind < d.Movements[j].Kind
+		}
+
+		if d.Movements[i].TxHash != d.Movements[j].TxHash {
+			return d.Movements[i].TxHash < d.Movements[j].TxHash
+		}
+
+		return d.Movements[i].Output < d.Movements[j].Output
+	})
+
+	d.Unexplained = to.Total().Sub(from.Total()).Sub(explained)
+
+	// account based coins have no outputs, whole change is one movement
+	if len(from.UTXOs) == 0 && len(to.UTXOs) == 0 && !d.Unexplained.IsZero() {
+		d.Movements = append(d.Movements, SnapshotMovement{Kind: MovementAccount, Amount: d.Unexplained})
+		d.Unexplained = decimal.Zero
+	}
+
+	return d, nil
+}
+
+//WriteJSON -
+func (d *SnapshotDiff) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+
+	return e.Encode(d)
+}
+
+//WriteCSV writes movements, summary goes as last records
+func (d *SnapshotDiff) WriteCSV(w io.Writer) error {
+	c := csv.NewWriter(w)
+
+	c.Write([]string{"kind", "coin", "txid", "output", "amount", "outputs"})
+
+	for _, m := range d.Movements {
+		c.Write([]string{m.Kind, d.Coin, m.TxHash, m.Output, m.Amount.String(), strconv.Itoa(m.Outputs)})
+	}
+
+	c.Write([]string{"confirmed_change", d.Coin, "", "", d.ConfirmedChange.String(), ""})
+	c.Write([]string{"unconfirmed_change", d.Coin, "", "", d.UnconfirmedChange.String(), ""})
+	c.Write([]string{"unexplained", d.Coin, "", "", d.Unexplained.String(), ""})
+
+	c.Flush()
+
+	return c.Error()
+}
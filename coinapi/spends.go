@@ -0,0 +1,169 @@
+package coinapi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seagiv/foreign/decimal"
+)
+
+// reservation lifetimes, reservation of crashed or hung spend expires after spendReserveTTL,
+// broadcast inputs and nonces stay reserved for spendBroadcastTTL as node may still list them until mined
+const (
+	spendReserveTTL   = 10 * time.Minute
+	spendBroadcastTTL = 6 * time.Hour
+)
+
+var errSpendReserved = errors.New("already reserved by another spend")
+
+//spendCoordinator per coin reservations of UTXOs and nonces, zero value is ready to use
+type spendCoordinator struct {
+	sync.Mutex
+
+	reserved map[string]time.Time // outpoint or nonce key -> expiry
+}
+
+func nonceKey(address string, nonce uint64) string {
+	return fmt.Sprintf("nonce:%s:%d", strings.ToLower(address), nonce)
+}
+
+func utxoKeys(utxos []UTXO) []string {
+	keys := make([]string, 0, len(utxos))
+
+	for _, u := range utxos {
+		keys = append(keys, utxoKey(u.TxID, u.Vout))
+	}
+
+	return keys
+}
+
+//prune drops expired reservations, caller holds lock
+func (s *spendCoordinator) prune() {
+	now := time.Now()
+
+	for k, expires := range s.reserved {
+		if now.After(expires) {
+			delete(s.reserved, k)
+		}
+	}
+}
+
+func (s *spendCoordinator) set(keys []string, ttl time.Duration) {
+	if s.reserved == nil {
+		s.reserved = make(map[string]time.Time)
+	}
+
+	expires := time.Now().Add(ttl)
+
+	for _, k := range keys {
+		s.reserved[k] = expires
+	}
+}
+
+//reserve reserves all keys or none
+func (s *spendCoordinator) reserve(keys []string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.prune()
+
+	for _, k := range keys {
+		if _, ok := s.reserved[k]; ok {
+			return fmt.Errorf("%s %v", k, errSpendReserved)
+		}
+	}
+
+	s.set(keys, spendReserveTTL)
+
+	return nil
+}
+
+//release frees keys of spend that was not broadcast
+func (s *spendCoordinator) release(keys []string) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, k := range keys {
+		delete(s.reserved, k)
+	}
+}
+
+//broadcast keeps keys of sent transaction reserved for spendBroadcastTTL
+func (s *spendCoordinator) broadcast(keys []string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.set(keys, spendBroadcastTTL)
+}
+
+//finish marks keys broadcast if transaction was sent and releases them otherwise,
+//nothing is sent in test mode so keys are released then as well
+func (s *spendCoordinator) finish(keys []string, sent bool) {
+	if sent {
+		s.broadcast(keys)
+	} else {
+		s.release(keys)
+	}
+}
+
+//available returns UTXOs not reserved by other spends
+func (s *spendCoordinator) available(utxos []UTXO) []UTXO {
+	s.Lock()
+	defer s.Unlock()
+
+	s.prune()
+
+	var result []UTXO
+
+	for _, u := range utxos {
+		if _, ok := s.reserved[utxoKey(u.TxID, u.Vout)]; !ok {
+			result = append(result, u)
+		}
+	}
+
+	return result
+}
+
+//selectInputs picks unreserved UTXOs until amount plus fee for outputs is covered and reserves them,
+//selection and reservation are done under one lock so parallel spends never pick the same UTXO
+func (a *BitcoinAPI) selectInputs(utxos []UTXO, amount, baseFee decimal.Decimal, outputs int) ([]UTXO, decimal.Decimal, decimal.Decimal, error) {
+	var inputUTXOs []UTXO
+	var inputAmount decimal.Decimal
+
+	s := &a.Coin.Spends
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.prune()
+
+	fee := baseFee
+	amountFull := amount.Add(fee)
+
+	for _, u := range utxos {
+		if _, ok := s.reserved[utxoKey(u.TxID, u.Vout)]; ok {
+			continue
+		}
+
+		inputUTXOs = append(inputUTXOs, u)
+		inputAmount = inputAmount.Add(u.Amount)
+
+		fee = a.requiredFee(baseFee, len(inputUTXOs), outputs)
+		amountFull = amount.Add(fee)
+
+		if inputAmount.GreaterThanOrEqual(amountFull) {
+			break
+		}
+	}
+
+	if inputAmount.LessThan(amountFull) {
+		return nil, inputAmount, fee, fmt.Errorf("not enough unspent funds (%s < %s)", inputAmount.String(), amountFull.String())
+	}
+
+	s.set(utxoKeys(inputUTXOs), spendReserveTTL)
+
+	return inputUTXOs, inputAmount, fee, nil
+}
@@ -0,0 +1,521 @@
+package coinapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/dchest/blake2b"
+	"github.com/seagiv/common/gutils"
+	"github.com/seagiv/foreign/decimal"
+)
+
+// transparent address prefixes (two bytes, mainnet)
+var (
+	zcashPrefixP2PKH = []byte{0x1C, 0xB8}
+	zcashPrefixP2SH  = []byte{0x1C, 0xBD}
+)
+
+const (
+	zcashVersionGroupV4 = 0x892F2085
+	zcashVersionGroupV5 = 0x26A7270A
+
+	zcashExpiryDelta = 40
+	zcashMaxExpiry   = 499999999
+
+	zcashSigHashAll = 0x01
+)
+
+var errZcashShielded = errors.New("shielded and unified addresses are not supported, transparent (t1/t3) address required")
+
+type zcashUpgrade struct {
+	Height   uint64
+	BranchID uint32
+}
+
+// mainnet network upgrades, newest first
+var zcashUpgrades = []zcashUpgrade{
+	{3146400, 0x4DEC4DF0}, // NU6.1
+	{2726400, 0xC8E71055}, // NU6
+	{1687104, 0xC2D6D0B4}, // NU5
+	{1046400, 0xE9FF75A6}, // Canopy
+	{903000, 0xF5B9230B},  // Heartwood
+	{653600, 0x2BB40E60},  // Blossom
+	{419200, 0x76B809BB},  // Sapling
+}
+
+const zcashNU5Height = 1687104
+
+type zcashInput struct {
+	TxID         []byte // internal byte order
+	Vout         uint32
+	Amount       int64
+	ScriptPubKey []byte
+	ScriptCode   []byte // redeemScript for P2SH, scriptPubKey otherwise, ZIP-243 only
+	Sequence     uint32
+
+	wif *btcutil.WIF
+
+	ScriptSig []byte
+}
+
+type zcashOutput struct {
+	Amount       int64
+	ScriptPubKey []byte
+}
+
+type zcashTx struct {
+	Version      uint32 // 4 or 5
+	BranchID     uint32
+	LockTime     uint32
+	ExpiryHeight uint32
+
+	Inputs  []zcashInput
+	Outputs []zcashOutput
+}
+
+func zcashBranchID(height uint64) (uint32, error) {
+	for _, u := range zcashUpgrades {
+		if height >= u.Height {
+			return u.BranchID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("height %d is before Sapling activation", height)
+}
+
+func zcashIsShielded(address string) bool {
+	a := strings.ToLower(address)
+
+	for _, prefix := range []string{"zs1", "zc", "u1", "zt", "tex1"} {
+		if strings.HasPrefix(a, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//zcashAddressScript decodes transparent address and returns its scriptPubKey
+func zcashAddressScript(address string) ([]byte, error) {
+	if zcashIsShielded(address) {
+		return nil, errZcashShielded
+	}
+
+	decoded, version, err := base58.CheckDecode(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoded) != 1+20 {
+		return nil, fmt.Errorf("invalid address length")
+	}
+
+	payload := append([]byte{version}, decoded...)
+
+	switch {
+	case bytes.Equal(payload[:2], zcashPrefixP2PKH):
+		return zcashP2PKHScript(payload[2:]), nil
+	case bytes.Equal(payload[:2], zcashPrefixP2SH):
+		return zcashP2SHScript(payload[2:]), nil
+	}
+
+	return nil, fmt.Errorf("unknown address prefix %X", payload[:2])
+}
+
+//zcashP2PKHScript OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSIG
+func zcashP2PKHScript(hash []byte) []byte {
+	script := []byte{txscript.OP_DUP, txscript.OP_HASH160, txscript.OP_DATA_20}
+	script = append(script, hash...)
+
+	return append(script, txscript.OP_EQUALVERIFY, txscript.OP_CHECKSIG)
+}
+
+//zcashP2SHScript OP_HASH160 <hash> OP_EQUAL
+func zcashP2SHScript(hash []byte) []byte {
+	script := []byte{txscript.OP_HASH160, txscript.OP_DATA_20}
+	script = append(script, hash...)
+
+	return append(script, txscript.OP_EQUAL)
+}
+
+//zcashInputKey finds key spending scriptPubKey and returns it with scriptCode, P2PKH of key and P2SH with P2PKH
+//of key as redeemScript are supported
+func zcashInputKey(scriptPubKey, redeemScript []byte, wifs []*btcutil.WIF) (*btcutil.WIF, []byte, error) {
+	for _, wif := range wifs {
+		keyScript := zcashP2PKHScript(btcutil.Hash160(wif.SerializePubKey()))
+
+		if bytes.Equal(scriptPubKey, keyScript) {
+			return wif, scriptPubKey, nil
+		}
+
+		if bytes.Equal(scriptPubKey, zcashP2SHScript(btcutil.Hash160(keyScript))) {
+			if !bytes.Equal(redeemScript, keyScript) {
+				return nil, nil, errors.New("redeemScript does not match key")
+			}
+
+			return wif, redeemScript, nil
+		}
+	}
+
+	return nil, nil, errors.New("no key")
+}
+
+func blake2bPersonal(personal []byte, data ...[]byte) []byte {
+	h, _ := blake2b.New(&blake2b.Config{Size: 32, Person: personal})
+
+	for _, d := range data {
+		h.Write(d)
+	}
+
+	return h.Sum(nil)
+}
+
+func zcashBranchPersonal(prefix string, branchID uint32) []byte {
+	p := make([]byte, 16)
+
+	copy(p, prefix)
+	binary.LittleEndian.PutUint32(p[12:], branchID)
+
+	return p
+}
+
+func writeCompactSize(b *bytes.Buffer, n uint64) {
+	switch {
+	case n < 0xFD:
+		b.WriteByte(byte(n))
+	case n <= 0xFFFF:
+		b.WriteByte(0xFD)
+		binary.Write(b, binary.LittleEndian, uint16(n))
+	case n <= 0xFFFFFFFF:
+		b.WriteByte(0xFE)
+		binary.Write(b, binary.LittleEndian, uint32(n))
+	default:
+		b.WriteByte(0xFF)
+		binary.Write(b, binary.LittleEndian, n)
+	}
+}
+
+func writeScript(b *bytes.Buffer, script []byte) {
+	writeCompactSize(b, uint64(len(script)))
+	b.Write(script)
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func le64(v int64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func (in *zcashInput) prevout() []byte {
+	return append(append([]byte{}, in.TxID...), le32(in.Vout)...)
+}
+
+func (out *zcashOutput) serialize(b *bytes.Buffer) {
+	b.Write(le64(out.Amount))
+	writeScript(b, out.ScriptPubKey)
+}
+
+func (tx *zcashTx) header() uint32 {
+	return tx.Version | 1<<31 // fOverwintered
+}
+
+func (tx *zcashTx) versionGroup() uint32 {
+	if tx.Version == 5 {
+		return zcashVersionGroupV5
+	}
+
+	return zcashVersionGroupV4
+}
+
+func (tx *zcashTx) hashOf(personal string, f func(b *bytes.Buffer)) []byte {
+	var b bytes.Buffer
+
+	f(&b)
+
+	return blake2bPersonal([]byte(personal), b.Bytes())
+}
+
+//sigHashV4 ZIP-243 signature hash for transparent input i, SIGHASH_ALL
+func (tx *zcashTx) sigHashV4(i int) []byte {
+	var b bytes.Buffer
+
+	zeros := make([]byte, 32)
+
+	b.Write(le32(tx.header()))
+	b.Write(le32(tx.versionGroup()))
+	b.Write(tx.hashOf("ZcashPrevoutHash", func(h *bytes.Buffer) {
+		for _, in := range tx.Inputs {
+			h.Write(in.prevout())
+		}
+	}))
+	b.Write(tx.hashOf("ZcashSequencHash", func(h *bytes.Buffer) {
+		for _, in := range tx.Inputs {
+			h.Write(le32(in.Sequence))
+		}
+	}))
+	b.Write(tx.hashOf("ZcashOutputsHash", func(h *bytes.Buffer) {
+		for _, out := range tx.Outputs {
+			out.serialize(h)
+		}
+	}))
+	b.Write(zeros) // hashJoinSplits
+	b.Write(zeros) // hashShieldedSpends
+	b.Write(zeros) // hashShieldedOutputs
+	b.Write(le32(tx.LockTime))
+	b.Write(le32(tx.ExpiryHeight))
+	b.Write(le64(0)) // valueBalance
+	b.Write(le32(zcashSigHashAll))
+
+	in := &tx.Inputs[i]
+
+	b.Write(in.prevout())
+	writeScript(&b, in.ScriptCode)
+	b.Write(le64(in.Amount))
+	b.Write(le32(in.Sequence))
+
+	return blake2bPersonal(zcashBranchPersonal("ZcashSigHash", tx.BranchID), b.Bytes())
+}
+
+//sigHashV5 ZIP-244 signature digest for transparent input i, SIGHASH_ALL
+func (tx *zcashTx) sigHashV5(i int) []byte {
+	var header bytes.Buffer
+
+	header.Write(le32(tx.header()))
+	header.Write(le32(tx.versionGroup()))
+	header.Write(le32(tx.BranchID))
+	header.Write(le32(tx.LockTime))
+	header.Write(le32(tx.ExpiryHeight))
+
+	headerDigest := blake2bPersonal([]byte("ZTxIdHeadersHash"), header.Bytes())
+
+	in := &tx.Inputs[i]
+
+	var txIn bytes.Buffer
+
+	txIn.Write(in.prevout())
+	txIn.Write(le64(in.Amount))
+	writeScript(&txIn, in.ScriptPubKey)
+	txIn.Write(le32(in.Sequence))
+
+	transparentDigest := tx.hashOf("ZTxIdTranspaHash", func(h *bytes.Buffer) {
+		h.WriteByte(zcashSigHashAll)
+		h.Write(tx.hashOf("ZTxIdPrevoutHash", func(d *bytes.Buffer) {
+			for _, in := range tx.Inputs {
+				d.Write(in.prevout())
+			}
+		}))
+		h.Write(tx.hashOf("ZTxTrAmountsHash", func(d *bytes.Buffer) {
+			for _, in := range tx.Inputs {
+				d.Write(le64(in.Amount))
+			}
+		}))
+		h.Write(tx.hashOf("ZTxTrScriptsHash", func(d *bytes.Buffer) {
+			for _, in := range tx.Inputs {
+				writeScript(d, in.ScriptPubKey)
+			}
+		}))
+		h.Write(tx.hashOf("ZTxIdSequencHash", func(d *bytes.Buffer) {
+			for _, in := range tx.Inputs {
+				d.Write(le32(in.Sequence))
+			}
+		}))
+		h.Write(tx.hashOf("ZTxIdOutputsHash", func(d *bytes.Buffer) {
+			for _, out := range tx.Outputs {
+				out.serialize(d)
+			}
+		}))
+		h.Write(blake2bPersonal([]byte("Zcash___TxInHash"), txIn.Bytes()))
+	})
+
+	saplingDigest := blake2bPersonal([]byte("ZTxIdSaplingHash"))
+	orchardDigest := blake2bPersonal([]byte("ZTxIdOrchardHash"))
+
+	return blake2bPersonal(zcashBranchPersonal("ZcashTxHash_", tx.BranchID), headerDigest, transparentDigest, saplingDigest, orchardDigest)
+}
+
+func (tx *zcashTx) sign() error {
+	for i := range tx.Inputs {
+		in := &tx.Inputs[i]
+
+		var hash []byte
+
+		if tx.Version == 5 {
+			hash = tx.sigHashV5(i)
+		} else {
+			hash = tx.sigHashV4(i)
+		}
+
+		sig, err := in.wif.PrivKey.Sign(hash)
+		if err != nil {
+			return err
+		}
+
+		builder := txscript.NewScriptBuilder().
+			AddData(append(sig.Serialize(), zcashSigHashAll)).
+			AddData(in.wif.SerializePubKey())
+
+		if !bytes.Equal(in.ScriptCode, in.ScriptPubKey) {
+			builder.AddData(in.ScriptCode)
+		}
+
+		in.ScriptSig, err = builder.Script()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (tx *zcashTx) serialize() []byte {
+	var b bytes.Buffer
+
+	b.Write(le32(tx.header()))
+	b.Write(le32(tx.versionGroup()))
+
+	if tx.Version == 5 {
+		b.Write(le32(tx.BranchID))
+		b.Write(le32(tx.LockTime))
+		b.Write(le32(tx.ExpiryHeight))
+	}
+
+	writeCompactSize(&b, uint64(len(tx.Inputs)))
+
+	for _, in := range tx.Inputs {
+		b.Write(in.prevout())
+		writeScript(&b, in.ScriptSig)
+		b.Write(le32(in.Sequence))
+	}
+
+	writeCompactSize(&b, uint64(len(tx.Outputs)))
+
+	for _, out := range tx.Outputs {
+		out.serialize(&b)
+	}
+
+	if tx.Version == 5 {
+		writeCompactSize(&b, 0) // nSpendsSapling
+		writeCompactSize(&b, 0) // nOutputsSapling
+		writeCompactSize(&b, 0) // nActionsOrchard
+	} else {
+		b.Write(le32(tx.LockTime))
+		b.Write(le32(tx.ExpiryHeight))
+		b.Write(le64(0))        // valueBalance
+		writeCompactSize(&b, 0) // nShieldedSpend
+		writeCompactSize(&b, 0) // nShieldedOutput
+		writeCompactSize(&b, 0) // nJoinSplit
+	}
+
+	return b.Bytes()
+}
+
+func zcashAmount(d decimal.Decimal) int64 {
+	return d.Mul(decimal.New(1, 8)).IntPart()
+}
+
+//buildZcashTx builds and signs transparent transaction to be mined after height
+func buildZcashTx(inputUTXOs []UTXO, vOut map[string]decimal.Decimal, keys []*gutils.SecureBuffer, height uint64) (string, error) {
+	var err error
+
+	nextHeight := height + 1
+
+	tx := zcashTx{Version: 4, ExpiryHeight: uint32(nextHeight + zcashExpiryDelta)}
+
+	if nextHeight+zcashExpiryDelta > zcashMaxExpiry {
+		return "", fmt.Errorf("expiry height out of range")
+	}
+
+	tx.BranchID, err = zcashBranchID(nextHeight)
+	if err != nil {
+		return "", err
+	}
+
+	if nextHeight >= zcashNU5Height {
+		tx.Version = 5
+	}
+
+	var wifs []*btcutil.WIF
+
+	defer func() {
+		for _, wif := range wifs {
+			wipeWIF(wif)
+		}
+	}()
+
+	for _, key := range keys {
+		wif, err := decodeWIFKey(key)
+		if err != nil {
+			return "", fmt.Errorf("DecodeWIF: %v", err)
+		}
+
+		wifs = append(wifs, wif)
+	}
+
+	for _, u := range inputUTXOs {
+		txID, err := hex.DecodeString(u.TxID)
+		if err != nil || len(txID) != 32 {
+			return "", fmt.Errorf("invalid txid [%s]", u.TxID)
+		}
+
+		for i, j := 0, len(txID)-1; i < j; i, j = i+1, j-1 {
+			txID[i], txID[j] = txID[j], txID[i]
+		}
+
+		in := zcashInput{TxID: txID, Vout: u.Vout, Amount: zcashAmount(u.Amount), Sequence: 0xFFFFFFFF}
+
+		in.ScriptPubKey, err = hex.DecodeString(u.ScriptPubKey)
+		if err != nil {
+			return "", fmt.Errorf("invalid scriptPubKey for %s:%d", u.TxID, u.Vout)
+		}
+
+		redeemScript, err := hex.DecodeString(u.RedeemScript)
+		if err != nil {
+			return "", fmt.Errorf("invalid redeemScript for %s:%d", u.TxID, u.Vout)
+		}
+
+		in.wif, in.ScriptCode, err = zcashInputKey(in.ScriptPubKey, redeemScript, wifs)
+		if err != nil {
+			return "", fmt.Errorf("input %s:%d: %v", u.TxID, u.Vout, err)
+		}
+
+		tx.Inputs = append(tx.Inputs, in)
+	}
+
+	addresses := make([]string, 0, len(vOut))
+
+	for address := range vOut {
+		addresses = append(addresses, address)
+	}
+
+	sort.Strings(addresses)
+
+	for _, address := range addresses {
+		script, err := zcashAddressScript(address)
+		if err != nil {
+			return "", fmt.Errorf("[%s]: %v", address, err)
+		}
+
+		tx.Outputs = append(tx.Outputs, zcashOutput{Amount: zcashAmount(vOut[address]), ScriptPubKey: script})
+	}
+
+	err = tx.sign()
+	if err != nil {
+		return "", fmt.Errorf("sign: %v", err)
+	}
+
+	return hex.EncodeToString(tx.serialize()), nil
+}
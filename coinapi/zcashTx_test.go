@@ -0,0 +1,346 @@
+package coinapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+)
+
+func readCompactSize(r *bytes.Reader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch b {
+	case 0xFD:
+		var v uint16
+		err = binary.Read(r, binary.LittleEndian, &v)
+		return uint64(v), err
+	case 0xFE:
+		var v uint32
+		err = binary.Read(r, binary.LittleEndian, &v)
+		return uint64(v), err
+	case 0xFF:
+		var v uint64
+		err = binary.Read(r, binary.LittleEndian, &v)
+		return v, err
+	}
+
+	return uint64(b), nil
+}
+
+func readScript(r *bytes.Reader) ([]byte, error) {
+	n, err := readCompactSize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	script := make([]byte, n)
+	_, err = io.ReadFull(r, script)
+
+	return script, err
+}
+
+//parseZcashTx parses v4/v5 transaction, shielded is true if it has any shielded part which sighash code does
+//not support
+func parseZcashTx(raw []byte) (*zcashTx, bool, error) {
+	var header, versionGroup uint32
+
+	r := bytes.NewReader(raw)
+	tx := &zcashTx{}
+
+	le := func(v interface{}) error { return binary.Read(r, binary.LittleEndian, v) }
+
+	err := le(&header)
+	if err == nil {
+		err = le(&versionGroup)
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	tx.Version = header &^ (1 << 31)
+
+	if tx.Version != 4 && tx.Version != 5 || versionGroup != tx.versionGroup() {
+		return nil, false, errors.New("not v4/v5 transaction")
+	}
+
+	if tx.Version == 5 {
+		for _, v := range []*uint32{&tx.BranchID, &tx.LockTime, &tx.ExpiryHeight} {
+			if err = le(v); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+
+	n, err := readCompactSize(r)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for i := uint64(0); i < n; i++ {
+		in := zcashInput{TxID: make([]byte, 32)}
+
+		_, err = io.ReadFull(r, in.TxID)
+		if err == nil {
+			err = le(&in.Vout)
+		}
+
+		if err == nil {
+			in.ScriptSig, err = readScript(r)
+		}
+
+		if err == nil {
+			err = le(&in.Sequence)
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		tx.Inputs = append(tx.Inputs, in)
+	}
+
+	n, err = readCompactSize(r)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for i := uint64(0); i < n; i++ {
+		var out zcashOutput
+
+		err = le(&out.Amount)
+		if err == nil {
+			out.ScriptPubKey, err = readScript(r)
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		tx.Outputs = append(tx.Outputs, out)
+	}
+
+	if tx.Version == 4 {
+		var valueBalance int64
+
+		err = le(&tx.LockTime)
+		if err == nil {
+			err = le(&tx.ExpiryHeight)
+		}
+
+		if err == nil {
+			err = le(&valueBalance)
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	// v4 sapling spends, sapling outputs and joinsplits, v5 sapling spends, sapling outputs and orchard actions
+	for i := 0; i < 3; i++ {
+		n, err = readCompactSize(r)
+		if err != nil {
+			return nil, false, err
+		}
+
+		// rest of shielded parts is not parsed
+		if n > 0 {
+			return tx, true, nil
+		}
+	}
+
+	if r.Len() > 0 {
+		return nil, false, errors.New("trailing data")
+	}
+
+	return tx, false, nil
+}
+
+// sighash vectors computed by independent implementation of ZIP-243 and ZIP-244 (transparent only, SIGHASH_ALL),
+// v4 vectors take scriptCode and amount of signed input, v5 ones amounts and scriptPubKeys of all inputs
+var zcashSigHashVectors = []struct {
+	tx       string
+	branchID uint32
+	input    int
+	amounts  []int64
+	scripts  []string
+	sighash  string
+}{
+	{
+		tx:       "0400008085202f8901c9ae6862cb9477105a19fcba27d45446e0d1469dc121363af76d3a1d5902655a010000000c361d7525d31364a3f28fa99effffffff01b9ee0b3fef08040017a9143e1c74fbec35d497259eaeb9c3d154cbe2c7e59a87000000007cfb3b190000000000000000000000",
+		branchID: 0x76B809BB,
+		input:    0,
+		amounts:  []int64{31818748638698},
+		scripts:  []string{"76a91442849d6e658af4bfe00aab039f774a92cf9437ad88ac"},
+		sighash:  "7230a6c84cd81551ae6befbbd80f002fa8c8c10ccb4c78d09dff3940251179b6",
+	},
+	{
+		tx:       "0400008085202f8903237558069a5e64d4e129bbbfcc6600fc27873b2b75ab7465c7424bdb854064880100000014a89903ed9a8cfc5b06d666e9c8043c1e1d0b2d900aa160b3bdfb9330613fc66e821599f6bcf32f3c3ebfee21c2e26c7cb8495f5b7fed9163000000000841a580321a5420cdaf187a846a4b2e61ba58794747c31f914360766bc60bf4a4ca171b2ca20b67b30d8bea2b0200000033c3862b6c2c4de4f1c4ff6c892358f96157874bb373b51185f10ff3212701c9f3452eae6fe47eb386128cc8c9a0244a1827cec028cfc2c9029e63a74928a700001976a91464149cee8dad9251ca418521d757c4ce409e19fb88ac19a59303bd89000017a9149ad117c808f3ae585f2ca3d0848d7b8be88c13a6870b69f830076d9d1c0000000000000000000000",
+		branchID: 0xE9FF75A6,
+		input:    2,
+		amounts:  []int64{1059761216378113},
+		scripts:  []string{"5221ca234dcb77ef61cfa2648dca7e96f345bd8b7b139a4f33d23aa069037a3ee7086e2162aa48a17fc2014dd0f0d364681c4c8e0d7735c1944c2ec526b6c592cf5e1990ab52ae"},
+		sighash:  "de0b3bfd4a3fa06c0b2814be805dbb83c9834abbeb3416f7301a7bd4a2e5bcc7",
+	},
+	{
+		tx:       "050000800a27a726b4d0d6c200000000e52de81901b2ce854c2faf56481775ffbcff8840a7defe750956efdd8f9eb9fd0922f1eb1b00000000192e3eaca11eca2e9a951358e07bda6f86e7e9885d5246f94981f230723c02fba9d3f281ad01001976a9147c4983c6e66088930831467b5de8ac61f29132f588ac8d658335a51201001976a914373047d319bfbb765c3ab555a4f794d44b0e9e8f88ac000000",
+		branchID: 0xC2D6D0B4,
+		input:    0,
+		amounts:  []int64{857816206387651},
+		scripts:  []string{"76a914226759cdee656d6d83cc1576cce2894b697d010188ac"},
+		sighash:  "c06f83b1f4c7c526a6a9e75a99ff4a09b4a75c96eed9355d752c5d0461714e9a",
+	},
+	{
+		tx:       "050000800a27a7265510e7c800000000ad8d0713031ca763b549c2aff1a16dd9b16b46c16a84cf4d8aca46fc0c8fe911baf4b2242e07000000562667b240933a15bf255611349c7f3e37e20427eabccdfe1e9f38f851f6f4f4790173923643d2c1791faddd5538a4932fa2394a8123895adca6f5c27ca7da207802ecc21ce31c13a02fa8bb4b7f38765537ebf6d9e561ffffffffccfb228b6a5495762339adcf464e96ce6f430c68ded7ec5cbcf6628683821c910600000011bef96802316fbee09e94fb6dc827e44574feffffff16d75eab53be4bf9d214e171dd6741b8e5ad4254e9cc14f8c779cd9c903ca8960000000069560e70767ccd2285d6737cbbee540f5bbd9bbd70c9e306b648b538b6299c4efbfe196fe303d7dc9e4265115aa83ad7239823485dcd0c84a679ef6321b529552b9bc65dde5ced03b48e552137f531876da5a004d630c5f03937080027564aced001c4747d0db3f4d1b4c2846e760108c5bab7fcde05001976a9147d90269d3ad82012dea0a342d9ab60a1bc6a27c188ac000000",
+		branchID: 0xC8E71055,
+		input:    2,
+		amounts:  []int64{1845713753780917, 1460775350681824, 1106278694940927},
+		scripts:  []string{"76a91443dfdb217770c8a1fd6c422eab3eb484f5d9cf9088ac", "76a914659512006cd518a8c324e0dccf25cf6ed98bdfa988ac", "a914cda940f442672dce5674352c543faea9caef934487"},
+		sighash:  "332d594621372952be0ee514c02e651f0a6091b11fa23eb93fce0d79e07a14aa",
+	},
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestZcashSigHashVectors(t *testing.T) {
+	for i, v := range zcashSigHashVectors {
+		raw := mustHex(t, v.tx)
+
+		tx, shielded, err := parseZcashTx(raw)
+		if err != nil || shielded {
+			t.Fatalf("vector %d: shielded %v, err %v", i, shielded, err)
+		}
+
+		tx.BranchID = v.branchID
+
+		if !bytes.Equal(tx.serialize(), raw) {
+			t.Errorf("vector %d: serialized transaction differs", i)
+		}
+
+		var got []byte
+
+		if tx.Version == 4 {
+			tx.Inputs[v.input].Amount = v.amounts[0]
+			tx.Inputs[v.input].ScriptCode = mustHex(t, v.scripts[0])
+
+			got = tx.sigHashV4(v.input)
+		} else {
+			// scriptCode is not part of v5 digest
+			for j := range tx.Inputs {
+				tx.Inputs[j].Amount = v.amounts[j]
+				tx.Inputs[j].ScriptPubKey = mustHex(t, v.scripts[j])
+			}
+
+			got = tx.sigHashV5(v.input)
+		}
+
+		if hex.EncodeToString(got) != v.sighash {
+			t.Errorf("vector %d input %d: sighash %x, want %s", i, v.input, got, v.sighash)
+		}
+	}
+}
+
+func TestZcashInputKey(t *testing.T) {
+	var wifs []*btcutil.WIF
+
+	for i := byte(1); i <= 2; i++ {
+		privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), bytes.Repeat([]byte{i}, 32))
+
+		wif, err := btcutil.NewWIF(privKey, &chaincfg.MainNetParams, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wifs = append(wifs, wif)
+	}
+
+	keyScript := zcashP2PKHScript(btcutil.Hash160(wifs[1].SerializePubKey()))
+	p2sh := zcashP2SHScript(btcutil.Hash160(keyScript))
+
+	tests := []struct {
+		name         string
+		scriptPubKey []byte
+		redeemScript []byte
+		wif          *btcutil.WIF
+		scriptCode   []byte
+	}{
+		{"p2pkh", keyScript, nil, wifs[1], keyScript},
+		{"p2pkh with redeemScript of another coin", keyScript, []byte{0x00, 0x14}, wifs[1], keyScript},
+		{"p2sh", p2sh, keyScript, wifs[1], keyScript},
+		{"p2sh without redeemScript", p2sh, nil, nil, nil},
+		{"p2sh with other redeemScript", p2sh, append([]byte{0x51}, keyScript...), nil, nil},
+		{"hash inside other script", append([]byte{0x51}, keyScript...), nil, nil, nil},
+		{"unknown key", zcashP2PKHScript(make([]byte, 20)), nil, nil, nil},
+	}
+
+	for _, tt := range tests {
+		wif, scriptCode, err := zcashInputKey(tt.scriptPubKey, tt.redeemScript, wifs)
+
+		if tt.wif == nil {
+			if err == nil {
+				t.Errorf("%s: key found", tt.name)
+			}
+
+			continue
+		}
+
+		if err != nil || wif != tt.wif || !bytes.Equal(scriptCode, tt.scriptCode) {
+			t.Errorf("%s: scriptCode %x, err %v", tt.name, scriptCode, err)
+		}
+	}
+}
+
+func TestZcashParseSerialized(t *testing.T) {
+	tests := []struct {
+		version  uint32
+		branchID uint32
+	}{
+		{4, 0xE9FF75A6},
+		{5, 0xC2D6D0B4},
+	}
+
+	for _, tt := range tests {
+		tx := zcashTx{Version: tt.version, BranchID: tt.branchID, LockTime: 7, ExpiryHeight: 1687144}
+
+		for i := 0; i < 3; i++ {
+			tx.Inputs = append(tx.Inputs, zcashInput{TxID: bytes.Repeat([]byte{byte(i + 1)}, 32), Vout: uint32(i), ScriptSig: []byte{0x51, byte(i)}, Sequence: 0xFFFFFFFE})
+		}
+
+		tx.Outputs = append(tx.Outputs, zcashOutput{Amount: 150000000, ScriptPubKey: bytes.Repeat([]byte{0xAC}, 25)})
+
+		parsed, shielded, err := parseZcashTx(tx.serialize())
+		if err != nil || shielded {
+			t.Fatalf("v%d: shielded %v, err %v", tt.version, shielded, err)
+		}
+
+		if tt.version == 4 {
+			parsed.BranchID = tt.branchID // not serialized in v4
+		}
+
+		if !bytes.Equal(parsed.serialize(), tx.serialize()) || parsed.LockTime != tx.LockTime || parsed.ExpiryHeight != tx.ExpiryHeight {
+			t.Errorf("v%d: parsed transaction differs", tt.version)
+		}
+	}
+}
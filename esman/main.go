@@ -12,10 +12,12 @@ import (
 	"net/rpc/jsonrpc"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"syscall"
 
 	"github.com/dixonwille/wmenu"
+	"github.com/seagiv/common/coinapi"
 	"github.com/seagiv/common/gutils"
 	"golang.org/x/crypto/ssh/terminal"
 
@@ -57,11 +59,26 @@ func keyInit(n, threshold byte, directory string) error {
 
 	//fmt.Printf("!!WARNING!! master key: %s\n", hex.EncodeString(masterKey)) // !!TODO!! must be removed in release !!
 
+	return keySplit(masterKey, n, threshold, directory)
+}
+
+//keySplit splits masterKey into n password protected shares saved to directory
+func keySplit(masterKey []byte, n, threshold byte, directory string) error {
 	shares, err := sss.Split(n, threshold, []byte(masterKey))
 	if err != nil {
 		return err
 	}
 
+	subset := make(map[byte][]byte, threshold)
+
+	for i := byte(1); i <= threshold; i++ {
+		subset[i] = shares[i]
+	}
+
+	if !bytes.Equal(sss.Combine(subset), masterKey) {
+		return gutils.FormatErrorS("Combine", "shares do not restore key")
+	}
+
 	for i := byte(1); i <= n; i++ {
 
 		for {
@@ -202,6 +219,7 @@ func storageCreate(itemsDir, ssDir, storageFile string, n byte) error {
 	if err != nil {
 		return gutils.FormatErrorS("keyGet", "%v", err)
 	}
+	defer gutils.WipeBytes(masterKey)
 
 	s, err := gutils.CreateStorage(storageFile, masterKey)
 	if err != nil {
@@ -258,6 +276,89 @@ func storageCreate(itemsDir, ssDir, storageFile string, n byte) error {
 	return nil
 }*/
 
+//storageRekey generates new master key, saves its shares to directory and re-encrypts storage with it,
+//directory must not hold shares already so current shares stay intact until storage is rekeyed
+func storageRekey(storageFile string, masterKey []byte, n, threshold byte, directory string) ([]byte, error) {
+	var err error
+
+	existing, err := filepath.Glob(directory + "/*.ss")
+	if err != nil {
+		return nil, gutils.FormatErrorS("Glob", "%v", err)
+	}
+
+	if len(existing) > 0 {
+		return nil, gutils.FormatErrorS("checkDir", "%s already contains shares, use empty directory", directory)
+	}
+
+	s, err := gutils.OpenStorage(storageFile, masterKey, gutils.FlagPreserveKey)
+	if err != nil {
+		return nil, gutils.FormatErrorS("OpenStorage", "%v", err)
+	}
+
+	var reply string
+
+	fmt.Printf("Storage %s will be encrypted with new key, %d shares (threshold %d) saved to %s\n", storageFile, n, threshold, directory)
+	fmt.Printf("Type REKEY to continue: ")
+	fmt.Scanln(&reply)
+
+	if reply != "REKEY" {
+		return nil, gutils.FormatErrorS("confirm", "cancelled")
+	}
+
+	newKey, err := gutils.GetRandomBuffer(32)
+	if err != nil {
+		return nil, gutils.FormatErrorS("GetRandomBuffer", "%v", err)
+	}
+
+	err = keySplit(newKey, n, threshold, directory)
+	if err != nil {
+		return nil, gutils.FormatErrorS("keySplit", "%v", err)
+	}
+
+	err = s.Rekey(newKey)
+	if err != nil {
+		return nil, gutils.FormatErrorS("Rekey", "%v", err)
+	}
+
+	s, err = gutils.OpenStorage(storageFile, newKey, 0)
+	if err != nil {
+		return nil, gutils.FormatErrorS("OpenStorage", "rekeyed storage can't be opened: %v", err)
+	}
+
+	fmt.Printf("storage rekeyed, key id %x, old shares may be destroyed now\n", s.Header().KeyID)
+
+	if len(*flagKeyFile) > 0 {
+		err = ioutil.WriteFile(*flagKeyFile, []byte(hex.EncodeToString(newKey)), 0600)
+		if err != nil {
+			return newKey, gutils.FormatErrorS("WriteFile", "%v", err)
+		}
+
+		fmt.Printf("(test) key file %s updated\n", *flagKeyFile)
+	}
+
+	return newKey, nil
+}
+
+func menuStorageRekey(storageFile string, masterKey []byte) ([]byte, error) {
+	var n, threshold int
+	var directory string
+
+	fmt.Printf("Number of shares: ")
+	fmt.Scanln(&n)
+
+	fmt.Printf("Threshold: ")
+	fmt.Scanln(&threshold)
+
+	if (n <= 0) || (n > 255) || (threshold <= 0) || (threshold > n) {
+		return nil, gutils.FormatErrorS("checkParams", "both must be greater then zero, less then 255 and n >= t")
+	}
+
+	fmt.Printf("Directory for new shares: ")
+	fmt.Scanln(&directory)
+
+	return storageRekey(storageFile, masterKey, byte(n), byte(threshold), directory)
+}
+
 func testGenKey() error {
 	var err error
 
@@ -271,18 +372,23 @@ func testGenKey() error {
 	return nil
 }
 
-func menuUnlockKey(keyFile string) ([]byte, error) {
+func menuUnlockKey(keyFile string) (*gutils.SecureBuffer, error) {
 	keyS, err := ioutil.ReadFile(*flagKeyFile)
 	if err != nil {
 		return nil, err
 	}
+	defer gutils.WipeBytes(keyS)
+
+	keyB := make([]byte, hex.DecodedLen(len(keyS)))
 
-	keyB, err := hex.DecodeString(string(keyS))
+	_, err = hex.Decode(keyB, keyS)
 	if err != nil {
+		gutils.WipeBytes(keyB)
+
 		return nil, err
 	}
 
-	return keyB, nil
+	return gutils.NewSecureBufferFrom(keyB)
 }
 
 func menuStorageList(storageFile string, masterKey []byte) error {
@@ -293,7 +399,68 @@ func menuStorageList(storageFile string, masterKey []byte) error {
 		return gutils.FormatErrorS("OpenStorage", "%v", err)
 	}
 
-	s.List()
+	h := s.Header()
+
+	if h.Version == gutils.StorageVersionLegacy {
+		fmt.Printf("format: legacy, converted on next change\n")
+	} else {
+		fmt.Printf("format: v%d, suite %d, key %x, created %s\n", h.Version, h.Suite, h.KeyID, h.Created.Format("2006-01-02 15:04:05"))
+	}
+
+	var filter gutils.StorageFilter
+
+	fmt.Printf("Name prefix (empty for all): ")
+	fmt.Scanln(&filter.Prefix)
+
+	fmt.Printf("Type (empty for any): ")
+	fmt.Scanln(&filter.Type)
+
+	for _, e := range s.Find(filter) {
+		fmt.Printf("[%s]: %d, %s, updated %s by %s", e.Name, e.Size, e.Type, e.Updated.Format("2006-01-02 15:04:05"), e.Creator)
+
+		if len(e.Tags) > 0 {
+			fmt.Printf(", tags %s", strings.Join(e.Tags, ","))
+		}
+
+		if len(e.Description) > 0 {
+			fmt.Printf(", %s", e.Description)
+		}
+
+		fmt.Println()
+	}
+
+	return nil
+}
+
+func menuStorageAudit(storageFile string, masterKey []byte) error {
+	logFile := storageFile + ".audit"
+
+	var reply string
+
+	fmt.Printf("Log file (empty for %s): ", logFile)
+	fmt.Scanln(&reply)
+
+	if len(reply) > 0 {
+		logFile = reply
+	}
+
+	entries, err := gutils.VerifyAuditLog(logFile, masterKey)
+
+	for _, e := range entries {
+		fmt.Printf("%d %s %s [%s] by %s", e.Seq, e.Time.Format("2006-01-02 15:04:05"), e.Op, e.Item, e.Caller)
+
+		if len(e.Result) > 0 {
+			fmt.Printf(": %s", e.Result)
+		}
+
+		fmt.Println()
+	}
+
+	if err != nil {
+		return gutils.FormatErrorS("VerifyAuditLog", "%v", err)
+	}
+
+	fmt.Printf("%d entries verified\n", len(entries))
 
 	return nil
 }
@@ -321,6 +488,382 @@ func menuStorageDelete(storageFile string, masterKey []byte) error {
 	return nil
 }
 
+//confirmOverwrite asks before service account of coin already in storage is replaced, returns overwrite flag
+func confirmOverwrite(s *gutils.Storage, coinTag string) (bool, error) {
+	if !coinapi.CoinInfoExists(s, coinTag) {
+		return false, nil
+	}
+
+	address, key, err := s.GetCoinInfo(coinTag)
+	if err != nil {
+		return false, gutils.FormatErrorS("GetCoinInfo", "%v", err)
+	}
+
+	key.Release()
+
+	var reply string
+
+	fmt.Printf("Coin.%s.JSON already holds key of [%s], it will be lost unless backed up\n", coinTag, address)
+	fmt.Printf("Type OVERWRITE to replace it: ")
+	fmt.Scanln(&reply)
+
+	if reply != "OVERWRITE" {
+		return false, gutils.FormatErrorS("confirm", "cancelled")
+	}
+
+	return true, nil
+}
+
+func menuKeystoreImport(storageFile string, masterKey []byte) error {
+	var err error
+
+	s, err := gutils.OpenStorage(storageFile, masterKey, gutils.FlagPreserveKey)
+	if err != nil {
+		return gutils.FormatErrorS("OpenStorage", "%v", err)
+	}
+
+	var coinTag, fileName string
+
+	fmt.Printf("Enter coin tag: ")
+	fmt.Scanln(&coinTag)
+
+	overwrite, err := confirmOverwrite(s, coinTag)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Enter keystore file name: ")
+	fmt.Scanln(&fileName)
+
+	keystoreJSON, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return gutils.FormatErrorS("ReadFile", "%v", err)
+	}
+
+	fmt.Printf("Keystore password: ")
+	pass, err := terminal.ReadPassword(int(syscall.Stdin))
+
+	fmt.Println()
+
+	if err != nil {
+		return gutils.FormatErrorS("ReadPassword", "%v", err)
+	}
+
+	address, err := coinapi.ImportKeystore(s, coinTag, keystoreJSON, string(pass), overwrite)
+	if err != nil {
+		return gutils.FormatErrorS("ImportKeystore", "%v", err)
+	}
+
+	fmt.Printf("key of [%s] imported as Coin.%s.JSON\n", address, coinTag)
+
+	return nil
+}
+
+func menuKeystoreExport(storageFile string, masterKey []byte) error {
+	var err error
+
+	s, err := gutils.OpenStorage(storageFile, masterKey, gutils.FlagPreserveKey)
+	if err != nil {
+		return gutils.FormatErrorS("OpenStorage", "%v", err)
+	}
+
+	var coinTag, fileName string
+
+	fmt.Printf("Enter coin tag: ")
+	fmt.Scanln(&coinTag)
+
+	fmt.Printf("Enter keystore file name: ")
+	fmt.Scanln(&fileName)
+
+	fmt.Printf("Keystore password (%d symbols or more): ", minPasswordLength)
+	pass1, err := terminal.ReadPassword(int(syscall.Stdin))
+
+	fmt.Println()
+
+	if err != nil {
+		return gutils.FormatErrorS("ReadPassword", "%v", err)
+	}
+
+	if len(pass1) < minPasswordLength {
+		return gutils.FormatErrorS("ReadPassword", "password too short")
+	}
+
+	fmt.Printf("Retype keystore password: ")
+	pass2, err := terminal.ReadPassword(int(syscall.Stdin))
+
+	fmt.Println()
+
+	if err != nil {
+		return gutils.FormatErrorS("ReadPassword", "%v", err)
+	}
+
+	if !bytes.Equal(pass1, pass2) {
+		return gutils.FormatErrorS("ReadPassword", "passwords mismatch")
+	}
+
+	keystoreJSON, err := coinapi.ExportKeystore(s, coinTag, string(pass1))
+	if err != nil {
+		return gutils.FormatErrorS("ExportKeystore", "%v", err)
+	}
+
+	err = ioutil.WriteFile(fileName, keystoreJSON, 0600)
+	if err != nil {
+		return gutils.FormatErrorS("WriteFile", "%v", err)
+	}
+
+	fmt.Printf("Coin.%s.JSON exported to %s\n", coinTag, fileName)
+
+	return nil
+}
+
+func menuStorageExport(storageFile string, masterKey []byte) error {
+	var err error
+
+	s, err := gutils.OpenStorage(storageFile, masterKey, 0)
+	if err != nil {
+		return gutils.FormatErrorS("OpenStorage", "%v", err)
+	}
+
+	var filter gutils.StorageFilter
+	var recipientFile, signerName, fileName string
+
+	fmt.Printf("Name prefix of items to export (empty for all): ")
+	fmt.Scanln(&filter.Prefix)
+
+	fmt.Printf("Recipient public key or certificate PEM file: ")
+	fmt.Scanln(&recipientFile)
+
+	fmt.Printf("Storage item with signing RSA key: ")
+	fmt.Scanln(&signerName)
+
+	fmt.Printf("Bundle file name: ")
+	fmt.Scanln(&fileName)
+
+	recipientPEM, err := ioutil.ReadFile(recipientFile)
+	if err != nil {
+		return gutils.FormatErrorS("ReadFile", "%v", err)
+	}
+
+	recipient, err := gutils.LoadPublicKeyFromPEM(recipientPEM)
+	if err != nil {
+		return gutils.FormatErrorS("LoadPublicKeyFromPEM", "%v", err)
+	}
+
+	signer, err := gutils.LoadPrivateKeyFromStorage(s, signerName)
+	if err != nil {
+		return gutils.FormatErrorS("LoadPrivateKeyFromStorage", "%v", err)
+	}
+
+	var names []string
+
+	// signing key stays here
+	for _, e := range s.Find(filter) {
+		if e.Name != signerName {
+			names = append(names, e.Name)
+		}
+	}
+
+	if len(names) == 0 {
+		return gutils.FormatErrorS("Find", "no items match [%s]", filter.Prefix)
+	}
+
+	bundle, err := s.Export(names, recipient, signer)
+	if err != nil {
+		return gutils.FormatErrorS("Export", "%v", err)
+	}
+
+	err = ioutil.WriteFile(fileName, bundle, 0600)
+	if err != nil {
+		return gutils.FormatErrorS("WriteFile", "%v", err)
+	}
+
+	fmt.Printf("%d items exported to %s\n", len(names), fileName)
+
+	return nil
+}
+
+func menuStorageImport(storageFile string, masterKey []byte) error {
+	var err error
+
+	s, err := gutils.OpenStorage(storageFile, masterKey, gutils.FlagPreserveKey)
+	if err != nil {
+		return gutils.FormatErrorS("OpenStorage", "%v", err)
+	}
+
+	var fileName, senderFile, recipientName, mode string
+
+	fmt.Printf("Bundle file name: ")
+	fmt.Scanln(&fileName)
+
+	fmt.Printf("Sender public key or certificate PEM file: ")
+	fmt.Scanln(&senderFile)
+
+	fmt.Printf("Storage item with decryption RSA key: ")
+	fmt.Scanln(&recipientName)
+
+	fmt.Printf("Existing items: skip, overwrite, newer or fail (default skip): ")
+	fmt.Scanln(&mode)
+
+	conflicts := map[string]int{"": gutils.ImportSkip, "skip": gutils.ImportSkip, "overwrite": gutils.ImportOverwrite, "newer": gutils.ImportNewer, "fail": gutils.ImportFail}
+
+	conflict, ok := conflicts[mode]
+	if !ok {
+		return gutils.FormatErrorS("mode", "unknown mode [%s]", mode)
+	}
+
+	bundle, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return gutils.FormatErrorS("ReadFile", "%v", err)
+	}
+
+	senderPEM, err := ioutil.ReadFile(senderFile)
+	if err != nil {
+		return gutils.FormatErrorS("ReadFile", "%v", err)
+	}
+
+	sender, err := gutils.LoadPublicKeyFromPEM(senderPEM)
+	if err != nil {
+		return gutils.FormatErrorS("LoadPublicKeyFromPEM", "%v", err)
+	}
+
+	recipient, err := gutils.LoadPrivateKeyFromStorage(s, recipientName)
+	if err != nil {
+		return gutils.FormatErrorS("LoadPrivateKeyFromStorage", "%v", err)
+	}
+
+	entries, err := s.Import(bundle, recipient, sender, conflict, true)
+
+	for _, e := range entries {
+		fmt.Printf("%-9s [%s]: %d, %s, updated %s by %s\n", e.Action, e.Name, e.Size, e.Type, e.Updated.Format("2006-01-02 15:04:05"), e.Creator)
+	}
+
+	if err != nil {
+		return gutils.FormatErrorS("Import", "%v", err)
+	}
+
+	var reply string
+
+	fmt.Printf("Type IMPORT to apply: ")
+	fmt.Scanln(&reply)
+
+	if reply != "IMPORT" {
+		return gutils.FormatErrorS("confirm", "cancelled")
+	}
+
+	_, err = s.Import(bundle, recipient, sender, conflict, false)
+	if err != nil {
+		return gutils.FormatErrorS("Import", "%v", err)
+	}
+
+	fmt.Printf("bundle %s imported\n", fileName)
+
+	return nil
+}
+
+func menuKeyImport(storageFile string, masterKey []byte) error {
+	var err error
+
+	s, err := gutils.OpenStorage(storageFile, masterKey, gutils.FlagPreserveKey)
+	if err != nil {
+		return gutils.FormatErrorS("OpenStorage", "%v", err)
+	}
+
+	var coinTag, path string
+
+	fmt.Printf("Enter coin tag: ")
+	fmt.Scanln(&coinTag)
+
+	overwrite, err := confirmOverwrite(s, coinTag)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Key (hex, WIF, BIP38 or extended private key): ")
+	key, err := terminal.ReadPassword(int(syscall.Stdin))
+
+	fmt.Println()
+
+	if err != nil {
+		return gutils.FormatErrorS("ReadPassword", "%v", err)
+	}
+
+	var pass []byte
+
+	if strings.HasPrefix(string(key), "6P") {
+		fmt.Printf("BIP38 passphrase: ")
+		pass, err = terminal.ReadPassword(int(syscall.Stdin))
+
+		fmt.Println()
+
+		if err != nil {
+			return gutils.FormatErrorS("ReadPassword", "%v", err)
+		}
+	} else {
+		fmt.Printf("Derivation path for extended key (empty if none): ")
+		fmt.Scanln(&path)
+	}
+
+	address, err := coinapi.ImportKey(s, coinTag, string(key), string(pass), path, overwrite)
+	if err != nil {
+		return gutils.FormatErrorS("ImportKey", "%v", err)
+	}
+
+	fmt.Printf("key of [%s] imported as Coin.%s.JSON\n", address, coinTag)
+
+	return nil
+}
+
+func menuKeyConvert() error {
+	var err error
+
+	var coinTag, format string
+
+	fmt.Printf("Enter coin tag: ")
+	fmt.Scanln(&coinTag)
+
+	fmt.Printf("Key (hex, WIF, BIP38, xprv/xpub): ")
+	key, err := terminal.ReadPassword(int(syscall.Stdin))
+
+	fmt.Println()
+
+	if err != nil {
+		return gutils.FormatErrorS("ReadPassword", "%v", err)
+	}
+
+	fmt.Printf("Target format (hex, wif, wifu, bip38, xprv, xpub, yprv, ypub, zprv, zpub, Ltpv, Ltub, Mtpv, Mtub, dgpv, dgub): ")
+	fmt.Scanln(&format)
+
+	var pass []byte
+
+	if strings.HasPrefix(string(key), "6P") || format == coinapi.KeyFormatBIP38 {
+		fmt.Printf("BIP38 passphrase: ")
+		pass, err = terminal.ReadPassword(int(syscall.Stdin))
+
+		fmt.Println()
+
+		if err != nil {
+			return gutils.FormatErrorS("ReadPassword", "%v", err)
+		}
+	}
+
+	var result string
+
+	switch format {
+	case coinapi.KeyFormatHex, coinapi.KeyFormatWIF, coinapi.KeyFormatWIFUncompressed, coinapi.KeyFormatBIP38:
+		result, err = coinapi.ConvertKey(coinTag, string(key), format, string(pass))
+	default:
+		result, err = coinapi.ConvertExtendedKey(string(key), format)
+	}
+
+	if err != nil {
+		return gutils.FormatErrorS("ConvertKey", "%v", err)
+	}
+
+	fmt.Printf("[%s]\n", result)
+
+	return nil
+}
+
 func managerRun(masterKey []byte) error {
 	var err error
 
@@ -383,7 +926,7 @@ func doMenu(keyFile, storageFile string) error {
 	for {
 		menu := wmenu.NewMenu("Select action:")
 
-		if len(masterKey) == 0 {
+		if masterKey.Len() == 0 {
 			menu.Option("Unlock masterKey", nil, false,
 				func(opt wmenu.Opt) error {
 					masterKey, err = menuUnlockKey(keyFile)
@@ -398,25 +941,84 @@ func doMenu(keyFile, storageFile string) error {
 		} else {
 			menu.Option("Storage List", nil, false,
 				func(opt wmenu.Opt) error {
-					return menuStorageList(storageFile, masterKey)
+					return menuStorageList(storageFile, masterKey.Bytes())
 				},
 			)
 
 			menu.Option("Storage Delete", nil, false,
 				func(opt wmenu.Opt) error {
-					return menuStorageDelete(storageFile, masterKey)
+					return menuStorageDelete(storageFile, masterKey.Bytes())
+				},
+			)
+
+			menu.Option("Storage Audit", nil, false,
+				func(opt wmenu.Opt) error {
+					return menuStorageAudit(storageFile, masterKey.Bytes())
+				},
+			)
+
+			menu.Option("Storage Rekey", nil, false,
+				func(opt wmenu.Opt) error {
+					newKey, err := menuStorageRekey(storageFile, masterKey.Bytes())
+					if newKey != nil {
+						key, errS := gutils.NewSecureBufferFrom(newKey)
+						if errS != nil {
+							return errS
+						}
+
+						masterKey.Release()
+						masterKey = key
+					}
+
+					return err
+				},
+			)
+
+			menu.Option("Storage Export", nil, false,
+				func(opt wmenu.Opt) error {
+					return menuStorageExport(storageFile, masterKey.Bytes())
+				},
+			)
+
+			menu.Option("Storage Import", nil, false,
+				func(opt wmenu.Opt) error {
+					return menuStorageImport(storageFile, masterKey.Bytes())
+				},
+			)
+
+			menu.Option("Keystore Import", nil, false,
+				func(opt wmenu.Opt) error {
+					return menuKeystoreImport(storageFile, masterKey.Bytes())
+				},
+			)
+
+			menu.Option("Keystore Export", nil, false,
+				func(opt wmenu.Opt) error {
+					return menuKeystoreExport(storageFile, masterKey.Bytes())
+				},
+			)
+
+			menu.Option("Key Import", nil, false,
+				func(opt wmenu.Opt) error {
+					return menuKeyImport(storageFile, masterKey.Bytes())
 				},
 			)
 
 			menu.Option("Execute and authenticate PayServ", nil, false,
 				func(opt wmenu.Opt) error {
-					err = menuExecPayServ(masterKey)
+					err = menuExecPayServ(masterKey.Bytes())
 
 					return err
 				},
 			)
 		}
 
+		menu.Option("Key Convert", nil, false,
+			func(opt wmenu.Opt) error {
+				return menuKeyConvert()
+			},
+		)
+
 		menu.Option("Exit", nil, false,
 			func(opt wmenu.Opt) error {
 				os.Exit(0)
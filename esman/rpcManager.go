@@ -7,7 +7,7 @@ import (
 	"github.com/seagiv/common/gutils"
 )
 
-var masterKey []byte
+var masterKey *gutils.SecureBuffer
 
 //Manager -
 type Manager struct {
@@ -22,7 +22,7 @@ func (m *Manager) onDisconnect() {
 
 //GetKey -
 func (m *Manager) GetKey(args int, reply *string) error {
-	*reply = hex.EncodeToString(masterKey)
+	*reply = hex.EncodeToString(masterKey.Bytes())
 
 	fmt.Println(gutils.FormatInfoS("", "storage key sent"))
 
@@ -40,6 +40,42 @@ func LoadPublicKey(fileName string) (*rsa.PublicKey, error) {
 	return rsaPublicKey, nil
 }
 
+//LoadPublicKeyFromPEM loads RSA public key from CERTIFICATE, PUBLIC KEY or RSA PUBLIC KEY block
+func LoadPublicKeyFromPEM(PEM []byte) (*rsa.PublicKey, error) {
+	var key interface{}
+	var err error
+
+	blockPEM, _ := pem.Decode(PEM)
+	if blockPEM == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+
+	switch blockPEM.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+
+		cert, err = x509.ParseCertificate(blockPEM.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(blockPEM.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(blockPEM.Bytes)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+
+	return rsaKey, nil
+}
+
 // LoadPrivateKeyFromPEM -TODO-
 func LoadPrivateKeyFromPEM(PEM []byte) (*rsa.PrivateKey, error) {
 	blockPEM, _ := pem.Decode([]byte(PEM))
@@ -72,8 +108,9 @@ func LoadPrivateKeyFromStorage(s *Storage, name string) (*rsa.PrivateKey, error)
 	if !ok {
 		return nil, fmt.Errorf("%s not found in storage", name)
 	}
+	defer PEM.Release()
 
-	return LoadPrivateKeyFromPEM(PEM)
+	return LoadPrivateKeyFromPEM(PEM.Bytes())
 }
 
 //LoadCertificateFromPEM -TODO-
@@ -107,8 +144,9 @@ func LoadCertificateFromStorage(s *Storage, name string) (*x509.Certificate, err
 	if !ok {
 		return nil, fmt.Errorf("%s not found in storage", name)
 	}
+	defer PEM.Release()
 
-	return LoadCertificateFromPEM(PEM)
+	return LoadCertificateFromPEM(PEM.Bytes())
 }
 
 //LoadX509KeyPairFromFile -TODO-
@@ -135,14 +173,16 @@ func LoadX509KeyPairFromStorage(s *Storage, certName, keyName string) (tls.Certi
 	if !ok {
 		return tls.Certificate{}, fmt.Errorf("%s not found in storage", certName)
 	}
+	defer certPEM.Release()
 
 	keyPEM, ok := s.Get(keyName)
 
 	if !ok {
 		return tls.Certificate{}, fmt.Errorf("%s not found in storage", keyName)
 	}
+	defer keyPEM.Release()
 
-	return tls.X509KeyPair(certPEM, keyPEM)
+	return tls.X509KeyPair(certPEM.Bytes(), keyPEM.Bytes())
 }
 
 //InitTLS -TODO-
@@ -206,6 +246,11 @@ func GetRandomBuffer(n int) ([]byte, error) {
 
 //EncryptGCM -TODO-
 func EncryptGCM(plaintext []byte, key []byte) ([]byte, error) {
+	return EncryptGCMAD(plaintext, key, nil)
+}
+
+//EncryptGCMAD encrypts plaintext authenticating additionalData along with it, nonce is prepended
+func EncryptGCMAD(plaintext, key, additionalData []byte) ([]byte, error) {
 	c, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -221,11 +266,16 @@ func EncryptGCM(plaintext []byte, key []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
 }
 
 //DecryptGCM -TODO-
 func DecryptGCM(ciphertext []byte, key []byte) ([]byte, error) {
+	return DecryptGCMAD(ciphertext, key, nil)
+}
+
+//DecryptGCMAD decrypts output of EncryptGCMAD, fails if additionalData differs
+func DecryptGCMAD(ciphertext, key, additionalData []byte) ([]byte, error) {
 	c, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -242,5 +292,5 @@ func DecryptGCM(ciphertext []byte, key []byte) ([]byte, error) {
 	}
 
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	return gcm.Open(nil, nonce, ciphertext, nil)
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
 }
@@ -0,0 +1,166 @@
+package gutils
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+	"unsafe"
+)
+
+var errSecureBufferReleased = errors.New("secure buffer released")
+
+//SecureBuffer holds secret outside of Go heap so it is never copied by GC, pages are locked in memory
+//and excluded from core dumps when system allows, on systems without mmap and mlock it falls back to
+//heap memory that is only wiped, Release zeroes and unmaps it, lock serializes Release with Use and Clone
+type SecureBuffer struct {
+	sync.Mutex
+
+	mem  []byte // whole allocation, whole pages if mapped
+	data []byte // secret, prefix of mem
+}
+
+//NewSecureBuffer allocates zeroed buffer of size bytes
+func NewSecureBuffer(size int) (*SecureBuffer, error) {
+	mem, err := allocSecure(size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecureBuffer{mem: mem, data: mem[:size]}, nil
+}
+
+//NewSecureBufferCopy copies data to new buffer, data is left as is
+func NewSecureBufferCopy(data []byte) (*SecureBuffer, error) {
+	b, err := NewSecureBuffer(len(data))
+	if err != nil {
+		return nil, err
+	}
+
+	copy(b.data, data)
+
+	return b, nil
+}
+
+//NewSecureBufferFrom moves data to new buffer, data is wiped
+func NewSecureBufferFrom(data []byte) (*SecureBuffer, error) {
+	defer WipeBytes(data)
+
+	return NewSecureBufferCopy(data)
+}
+
+//NewSecureBufferString copies s to new buffer, s itself can't be wiped so secrets should not be kept as strings
+func NewSecureBufferString(s string) (*SecureBuffer, error) {
+	b, err := NewSecureBuffer(len(s))
+	if err != nil {
+		return nil, err
+	}
+
+	copy(b.data, s)
+
+	return b, nil
+}
+
+//Bytes returns secret in place, nil after Release, slice must not be retained or appended to, owner
+//must not Release buffer while slice is in use, Use protects buffer released by another goroutine
+func (b *SecureBuffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+
+	return b.data
+}
+
+//Use calls f with secret in place, Release waits until f returns, slice must not be retained
+func (b *SecureBuffer) Use(f func(data []byte) error) error {
+	if b == nil {
+		return errSecureBufferReleased
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.mem == nil {
+		return errSecureBufferReleased
+	}
+
+	return f(b.data)
+}
+
+//UnsafeString returns secret as string sharing buffer memory for APIs accepting strings only,
+//string is valid until Release and must not be retained, logged or printed
+func (b *SecureBuffer) UnsafeString() string {
+	data := b.Bytes()
+
+	if len(data) == 0 {
+		return ""
+	}
+
+	return *(*string)(unsafe.Pointer(&data))
+}
+
+//Len -
+func (b *SecureBuffer) Len() int {
+	return len(b.Bytes())
+}
+
+//Clone copies secret to new buffer
+func (b *SecureBuffer) Clone() (*SecureBuffer, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.mem == nil {
+		return nil, errSecureBufferReleased
+	}
+
+	c, err := NewSecureBuffer(len(b.data))
+	if err != nil {
+		return nil, err
+	}
+
+	copy(c.data, b.data)
+
+	return c, nil
+}
+
+//Release zeroes and unmaps buffer, safe to call more then once and on nil buffer
+func (b *SecureBuffer) Release() {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.mem == nil {
+		return
+	}
+
+	WipeBytes(b.mem)
+
+	freeSecure(b.mem)
+
+	b.mem = nil
+	b.data = nil
+}
+
+//WipeBytes zeroes b
+func WipeBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+//WipeBigInt zeroes words of x, for private scalars of parsed keys
+func WipeBigInt(x *big.Int) {
+	if x == nil {
+		return
+	}
+
+	words := x.Bits()
+
+	for i := range words {
+		words[i] = 0
+	}
+
+	x.SetInt64(0)
+}
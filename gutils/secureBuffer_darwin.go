@@ -0,0 +1,6 @@
+package gutils
+
+//adviseNoDump does nothing as darwin has no advice excluding pages from core dumps
+func adviseNoDump(mem []byte) error {
+	return nil
+}
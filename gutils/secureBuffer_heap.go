@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package gutils
+
+//allocSecure falls back to heap where mmap and mlock are not available, Go GC does not move heap objects
+//so buffer is not copied, but it can be swapped out or dumped
+func allocSecure(size int) ([]byte, error) {
+	return make([]byte, size), nil
+}
+
+func freeSecure(mem []byte) {}
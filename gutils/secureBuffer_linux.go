@@ -0,0 +1,10 @@
+package gutils
+
+import "syscall"
+
+// madvise advice missing from syscall, excludes pages from core dumps
+const madvDontDump = 0x10
+
+func adviseNoDump(mem []byte) error {
+	return syscall.Madvise(mem, madvDontDump)
+}
@@ -0,0 +1,42 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package gutils
+
+import (
+	"os"
+	"syscall"
+)
+
+//allocSecure maps whole pages for size bytes, failing mlock or madvise is logged but not fatal
+//as RLIMIT_MEMLOCK is often low
+func allocSecure(size int) ([]byte, error) {
+	page := os.Getpagesize()
+
+	mapped := (size + page - 1) / page * page
+	if mapped == 0 {
+		mapped = page
+	}
+
+	mem, err := syscall.Mmap(-1, 0, mapped, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Mlock(mem)
+	if err != nil {
+		RemoteLog.PutWarningS("SecureBuffer", "mlock: %v", err)
+	}
+
+	err = adviseNoDump(mem)
+	if err != nil {
+		RemoteLog.PutWarningS("SecureBuffer", "madvise: %v", err)
+	}
+
+	return mem, nil
+}
+
+func freeSecure(mem []byte) {
+	syscall.Munlock(mem)
+	syscall.Munmap(mem)
+}
@@ -2,14 +2,17 @@ package gutils
 
 import (
 	"bytes"
+	"crypto/rand"
 	"errors"
 	"fmt"
 
-	"encoding/gob"
 	"encoding/json"
 
-	"io/ioutil"
 	"sync"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 //FlagPreserveKey -TODO-
@@ -21,101 +24,413 @@ type Storage struct {
 
 	flags int64
 
-	fileName  string
-	masterKey []byte
+	backend   StorageBackend
+	masterKey *SecureBuffer // released by WipeKey
 
-	items map[string][]byte
+	header StorageHeader
+
+	sessionKey *SecureBuffer // wraps data keys in memory so masterKey may be wiped
+
+	creator string
+
+	items   map[string]*storageItem
+	records map[string][]byte // as written to backend, sealed by masterKey
+	convert bool              // loaded from older format, everything is rewritten on next save
+
+	generation []byte // of state loaded or saved last, save is rejected if backend holds another one
+
+	auditLog *auditLog // nil if backend has no place for it
+}
+
+var errStorageEmpty = errors.New("storage not found")
+
+//newStorage copies masterKey to secure buffer, caller still owns and should wipe its own copy
+func newStorage(backend StorageBackend, masterKey []byte) (*Storage, error) {
+	var key *SecureBuffer
+	var err error
+
+	if masterKey != nil {
+		key, err = NewSecureBufferCopy(masterKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sessionKey, err := NewSecureBuffer(32)
+	if err != nil {
+		key.Release()
+
+		return nil, err
+	}
+
+	_, err = rand.Read(sessionKey.Bytes())
+	if err != nil {
+		key.Release()
+		sessionKey.Release()
+
+		return nil, err
+	}
+
+	return &Storage{backend: backend, masterKey: key, sessionKey: sessionKey, creator: defaultCreator(), items: make(map[string]*storageItem), records: make(map[string][]byte)}, nil
 }
 
-//OpenStorage -
+//OpenStorage opens storage file, files in older formats are converted to current format on next save
 func OpenStorage(fileName string, masterKey []byte, flags int64) (*Storage, error) {
-	s := &Storage{fileName: fileName, masterKey: masterKey, items: make(map[string][]byte)}
+	return OpenStorageBackend(NewFileBackend(fileName), masterKey, flags)
+}
+
+//OpenStorageBackend opens storage kept by backend
+func OpenStorageBackend(backend StorageBackend, masterKey []byte, flags int64) (*Storage, error) {
+	s, err := newStorage(backend, masterKey)
+	if err != nil {
+		return nil, err
+	}
 
 	if (flags & FlagPreserveKey) == 0 {
 		defer s.WipeKey()
 	}
 
-	return s, s.load()
+	err = s.load()
+	if err != nil {
+		return s, err
+	}
+
+	s.initAudit(false)
+	s.audit(AuditOpen, "", nil)
+
+	return s, nil
 }
 
 //CreateStorage -
 func CreateStorage(fileName string, masterKey []byte) (*Storage, error) {
-	s := &Storage{fileName: fileName, masterKey: masterKey, items: make(map[string][]byte)}
-
-	return s, s.save()
+	return CreateStorageBackend(NewFileBackend(fileName), masterKey)
 }
 
-func (s *Storage) encrypt() ([]byte, error) {
-	decBuffer := new(bytes.Buffer)
+//CreateStorageBackend creates empty storage, records backend already holds are removed
+func CreateStorageBackend(backend StorageBackend, masterKey []byte) (*Storage, error) {
+	s, err := newStorage(backend, masterKey)
+	if err != nil {
+		return nil, err
+	}
 
-	encoder := gob.NewEncoder(decBuffer)
-	encoder.Encode(&s.items)
+	var deleted []string
 
-	encBuffer, err := EncryptGCM(decBuffer.Bytes(), s.masterKey)
+	unlock, err := s.lockBackend(true)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
+
+	existing, _ := backend.Load(func(map[string][]byte) error { return nil })
+
+	for name := range existing {
+		deleted = append(deleted, name)
+	}
+
+	s.header = StorageHeader{Version: StorageVersionCurrent, Suite: StorageSuiteAESGCMEnvelope, Created: time.Now()}
+
+	err = s.write(nil, deleted)
+	if err != nil {
+		return s, err
+	}
+
+	s.initAudit(true)
+	s.audit(AuditCreate, "", nil)
+
+	return s, nil
+}
+
+//Header returns header of loaded file, older Version is reported until converted file is saved
+func (s *Storage) Header() StorageHeader {
+	s.Lock()
+	defer s.Unlock()
 
-	return encBuffer, nil
+	return s.header
 }
 
-func (s *Storage) decrypt(encBuffer []byte) error {
-	d, err := DecryptGCM(encBuffer, s.masterKey)
+//accept decodes records of one backend generation, storage is changed only if all of them are valid
+func (s *Storage) accept(records map[string][]byte) error {
+	var header *StorageHeader
+	var items map[string]*storageItem
+	var err error
+
+	if len(records) == 0 {
+		return errStorageEmpty
+	}
+
+	raw, convert := records[storageRecordRaw]
+
+	if convert {
+		header, items, err = decodeBlob(raw, s.masterKey.Bytes(), s.sessionKey.Bytes())
+	} else {
+		header, items, err = decodeRecords(records, s.masterKey.Bytes(), s.sessionKey.Bytes())
+	}
+
 	if err != nil {
 		return err
 	}
 
-	decBuffer := bytes.NewBuffer(d)
+	s.header = *header
+	s.items = items
+	s.convert = convert
+
+	s.records = make(map[string][]byte)
 
-	decoder := gob.NewDecoder(decBuffer)
-	decoder.Decode(&s.items)
+	if !convert {
+		for name, record := range records {
+			s.records[name] = record
+		}
+	}
 
 	return nil
 }
 
 func (s *Storage) load() error {
-	var err error
+	if s.masterKey == nil {
+		return FormatErrorS("", "masterKey not set")
+	}
+
+	unlock, err := s.lockBackend(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.loadLocked()
+}
+
+//loadLocked loads storage, caller holds backend lock
+func (s *Storage) loadLocked() error {
+	records, err := s.backend.Load(s.accept)
+	if err != nil {
+		return err
+	}
+
+	s.generation = recordsGeneration(records)
+
+	return nil
+}
+
+func (s *Storage) itemNames() []string {
+	names := make([]string, 0, len(s.items))
+
+	for name := range s.items {
+		names = append(names, name)
+	}
+
+	return names
+}
 
+//save writes changes under exclusive backend lock unless backend was changed by another process since load
+func (s *Storage) save(changed, deleted []string) error {
 	if s.masterKey == nil {
 		return FormatErrorS("", "masterKey not set")
 	}
 
-	encBuffer, err := ioutil.ReadFile(s.fileName)
+	unlock, err := s.lockBackend(true)
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	return s.decrypt(encBuffer)
+	err = s.checkStale()
+	if err != nil {
+		return err
+	}
+
+	return s.write(changed, deleted)
 }
 
-//Save -
-func (s *Storage) save() error {
-	var err error
+//checkStale fails if backend was changed by another process since load, caller holds exclusive backend lock
+func (s *Storage) checkStale() error {
+	generation, err := s.peekGeneration()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(generation, s.generation) {
+		return errStorageStale
+	}
+
+	return nil
+}
+
+//write seals changed items and writes them along with new header and manifest, other records are kept as is
+func (s *Storage) write(changed, deleted []string) error {
+	if s.convert {
+		changed = s.itemNames()
+		deleted = append(deleted, storageRecordRaw)
+	}
+
+	records := make(map[string][]byte, len(s.records)+len(changed))
+
+	for name, record := range s.records {
+		records[name] = record
+	}
+
+	for _, name := range deleted {
+		delete(records, name)
+	}
+
+	out := make(map[string][]byte, len(changed)+2)
+
+	for _, name := range changed {
+		fileIt, err := s.items[name].rewrap(name, s.sessionKey.Bytes(), s.masterKey.Bytes())
+		if err != nil {
+			return fmt.Errorf("rewrap [%s]: %v", name, err)
+		}
+
+		out[name], err = sealRecord(name, fileIt, s.masterKey.Bytes())
+		if err != nil {
+			return err
+		}
+
+		records[name] = out[name]
+	}
+
+	header := StorageHeader{
+		Version: StorageVersionCurrent,
+		Suite:   StorageSuiteAESGCMEnvelope,
+		KeyID:   storageKeyID(s.masterKey.Bytes()),
+		Created: s.header.Created,
+	}
+
+	if header.Created.IsZero() {
+		header.Created = time.Now()
+	}
+
+	out[storageRecordHeader] = header.marshal()
+	records[storageRecordHeader] = out[storageRecordHeader]
+
+	out[storageRecordManifest] = storageManifest(s.masterKey.Bytes(), records)
+	records[storageRecordManifest] = out[storageRecordManifest]
+
+	err := s.backend.Save(out, deleted)
+	if err != nil {
+		return err
+	}
+
+	s.header = header
+	s.records = records
+	s.convert = false
+	s.generation = records[storageRecordManifest]
+
+	return nil
+}
+
+//SetBackups sets number of backup generations kept on save if backend keeps them, 0 disables backups
+func (s *Storage) SetBackups(n int) {
+	s.Lock()
+	defer s.Unlock()
+
+	if b, ok := s.backend.(*FileBackend); ok {
+		b.SetBackups(n)
+	}
+}
+
+//Close closes backend and releases keys, storage can't be used after that
+func (s *Storage) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.masterKey.Release()
+	s.masterKey = nil
+
+	s.sessionKey.Release()
+
+	if s.auditLog != nil {
+		err := s.auditLog.flush()
+		if err != nil {
+			RemoteLog.PutWarningS("audit", "can't write audit log [%s]: %v", s.auditLog.fileName, err)
+		}
+	}
+
+	return s.backend.Close()
+}
+
+//Rekey re-encrypts storage under newKey, on success backups made with previous key are removed
+//as rotation is pointless while they remain, storage must be opened with FlagPreserveKey
+func (s *Storage) Rekey(newKey []byte) error {
+	s.Lock()
+	defer s.Unlock()
 
 	if s.masterKey == nil {
 		return FormatErrorS("", "masterKey not set")
 	}
 
-	buffer, err := s.encrypt()
+	switch len(newKey) {
+	case 16, 24, 32:
+	default:
+		return FormatErrorS("KeyLength", "invalid key length %d", len(newKey))
+	}
+
+	if bytes.Equal(newKey, s.masterKey.Bytes()) {
+		return FormatErrorS("", "new key is the same as current one")
+	}
+
+	key, err := NewSecureBufferCopy(newKey)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := s.lockBackend(true)
+	if err != nil {
+		key.Release()
+
+		return err
+	}
+	defer unlock()
+
+	oldKey := s.masterKey
+
+	// saved state is checked under current key before records are sealed by new one
+	err = s.checkStale()
+	if err == nil {
+		s.masterKey = key
+
+		err = s.write(s.itemNames(), nil)
+		if err != nil {
+			s.masterKey = oldKey
+		}
+	}
+
 	if err != nil {
+		key.Release()
+
+		s.audit(AuditRekey, "", err)
+
 		return err
 	}
 
-	return ioutil.WriteFile(s.fileName, buffer, 0644)
+	s.rekeyAudit(storageKeyID(oldKey.Bytes()))
+
+	oldKey.Release()
+
+	if p, ok := s.backend.(storageBackendPurger); ok {
+		p.PurgeBackups()
+	}
+
+	return nil
 }
 
-//WipeKey -
+//WipeKey zeroes master key, storage stays readable through session key
 func (s *Storage) WipeKey() {
+	s.masterKey.Release()
 	s.masterKey = nil
 }
 
-//Set -
+//Set sets item value keeping its metadata, type is detected for new items
 func (s *Storage) Set(name string, data []byte) error {
 	s.Lock()
 	defer s.Unlock()
 
-	if s.masterKey == nil {
-		return FormatErrorS("", "masterKey not set")
+	return s.set(name, data, nil)
+}
+
+//checkItemLimits checks item to be added to storage holding count items
+func checkItemLimits(name string, data []byte, count int) error {
+	if isReservedRecord(name) {
+		return FormatErrorS("Name", "reserved name")
 	}
 
 	if len(name) > 255 {
@@ -126,13 +441,66 @@ func (s *Storage) Set(name string, data []byte) error {
 		return FormatErrorS("DataLength", "data too large")
 	}
 
-	if len(s.items) > 65523 {
+	if count > 65523 {
 		return FormatErrorS("ItemsLength", "too many items")
 	}
 
-	s.items[name] = data
+	return nil
+}
+
+//set stores data under new data key, Type, Description and Tags are taken from meta if given
+func (s *Storage) set(name string, data []byte, meta *ItemMeta) error {
+	if s.masterKey == nil {
+		return FormatErrorS("", "masterKey not set")
+	}
+
+	err := checkItemLimits(name, data, len(s.items))
+	if err != nil {
+		return err
+	}
+
+	it, err := sealItem(name, data, s.sessionKey.Bytes())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	if prev, ok := s.items[name]; ok {
+		it.Meta = prev.Meta
+	} else {
+		it.Meta = ItemMeta{Created: now}
+	}
+
+	if meta != nil {
+		it.Meta.Type = meta.Type
+		it.Meta.Description = meta.Description
+		it.Meta.Tags = meta.Tags
+	}
+
+	if len(it.Meta.Type) == 0 {
+		it.Meta.Type = DetectItemType(name, data)
+	}
+
+	it.Meta.Updated = now
+	it.Meta.Creator = s.creator
+
+	old, had := s.items[name]
+
+	s.items[name] = it
+
+	err = s.save([]string{name}, nil)
+	if err != nil {
+		if had {
+			s.items[name] = old
+		} else {
+			delete(s.items, name)
+		}
+	}
+
+	s.audit(AuditSet, name, err)
 
-	return s.save()
+	return err
 }
 
 //Delete -
@@ -145,58 +513,241 @@ func (s *Storage) Delete(name string) error {
 	}
 
 	if s.items[name] == nil {
-		return fmt.Errorf("item [%s] not found", name)
+		err := fmt.Errorf("item [%s] not found", name)
+
+		s.audit(AuditDelete, name, err)
+
+		return err
 	}
 
+	old := s.items[name]
+
 	delete(s.items, name)
 
-	return s.save()
+	err := s.save(nil, []string{name})
+	if err != nil {
+		s.items[name] = old
+	}
+
+	s.audit(AuditDelete, name, err)
+
+	return err
 }
 
-//Get -
-func (s *Storage) Get(name string) ([]byte, bool) {
+//Get returns decrypted item in secure buffer, caller must Release it
+func (s *Storage) Get(name string) (*SecureBuffer, bool) {
 	s.Lock()
 	defer s.Unlock()
 
-	if value, ok := s.items[name]; ok {
-		return value, true
+	it, ok := s.items[name]
+	if !ok {
+		s.audit(AuditGet, name, errors.New("not found"))
+
+		return nil, false
 	}
 
-	return []byte{}, false
-}
+	value, err := it.open(name, s.sessionKey.Bytes())
+	if err == nil {
+		var b *SecureBuffer
 
-//List -TODO-
-func (s *Storage) List() {
-	s.Lock()
-	defer s.Unlock()
+		b, err = NewSecureBufferFrom(value)
+		if err == nil {
+			s.audit(AuditGet, name, nil)
 
-	for k, v := range s.items {
-		fmt.Printf("[%s]: %d\n", k, len(v))
+			return b, true
+		}
 	}
+
+	s.audit(AuditGet, name, err)
+
+	RemoteLog.PutWarningS("Get", "item [%s] can't be decrypted: %v", name, err)
+
+	return nil, false
 }
 
+//coinInfo as stored, Key is kept raw so it is decoded straight to secure buffer and never becomes string
 type coinInfo struct {
-	Address string `json:"address"`
-	Key     string `json:"key"`
+	Address string          `json:"address"`
+	Key     json.RawMessage `json:"key"`
 }
 
-//GetCoinInfo return address, privKey, ok
-func (s *Storage) GetCoinInfo(coinTag string) (string, string, error) {
-	var err error
+//decodeCoinInfo parses item value and moves key to secure buffer, raw copies are wiped
+func decodeCoinInfo(data []byte) (string, *SecureBuffer, error) {
+	var ci coinInfo
+
+	err := json.Unmarshal(data, &ci)
+	if err != nil {
+		return "", nil, err
+	}
+	defer WipeBytes(ci.Key)
+
+	data, err = unquoteJSON(ci.Key)
+	if err != nil {
+		return "", nil, fmt.Errorf("key: %v", err)
+	}
+
+	key, err := NewSecureBufferFrom(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return ci.Address, key, nil
+}
+
+//unquoteJSON decodes JSON string literal to new slice without passing it through string as
+//strconv.Unquote would, decoded literal is never longer so slice is not reallocated, caller wipes it
+func unquoteJSON(raw []byte) ([]byte, error) {
+	if len(raw) < 2 || raw[0] != '"' || raw[len(raw)-1] != '"' {
+		return nil, errors.New("not a string")
+	}
+
+	raw = raw[1 : len(raw)-1]
+	out := make([]byte, 0, len(raw))
+
+	var buf [utf8.UTFMax]byte
+	defer WipeBytes(buf[:])
+
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' {
+			out = append(out, raw[i])
+
+			continue
+		}
+
+		i++
+
+		if i == len(raw) {
+			WipeBytes(out)
+
+			return nil, errors.New("string truncated")
+		}
+
+		switch raw[i] {
+		case '"', '\\', '/':
+			out = append(out, raw[i])
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'u':
+			r, ok := hexRune(raw[i+1:])
+			if !ok {
+				WipeBytes(out)
+
+				return nil, errors.New("invalid \\u escape")
+			}
+
+			i += 4
+
+			if utf16.IsSurrogate(r) {
+				// pair is written as two escapes, lone half decodes to replacement char as in encoding/json
+				r2, ok := rune(0), false
+
+				if i+2 < len(raw) && raw[i+1] == '\\' && raw[i+2] == 'u' {
+					r2, ok = hexRune(raw[i+3:])
+				}
+
+				if ok && utf16.DecodeRune(r, r2) != unicode.ReplacementChar {
+					r = utf16.DecodeRune(r, r2)
+					i += 6
+				} else {
+					r = unicode.ReplacementChar
+				}
+			}
+
+			out = append(out, buf[:utf8.EncodeRune(buf[:], r)]...)
+		default:
+			WipeBytes(out)
+
+			return nil, fmt.Errorf("invalid escape \\%c", raw[i])
+		}
+	}
+
+	return out, nil
+}
+
+//hexRune decodes 4 hex digits of \u escape
+func hexRune(b []byte) (rune, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+
+	var r rune
+
+	for _, c := range b[:4] {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, false
+		}
+
+		r = r<<4 | rune(c)
+	}
+
+	return r, true
+}
 
+//GetCoinInfo return address and privKey of service address, caller must Release key
+func (s *Storage) GetCoinInfo(coinTag string) (string, *SecureBuffer, error) {
 	scid := "Coin." + coinTag + ".JSON"
 
 	keyJSON, ok := s.Get(scid)
 	if !ok {
-		return "", "", errors.New("coin info not found in storage")
+		return "", nil, errors.New("coin info not found in storage")
 	}
+	defer keyJSON.Release()
 
-	var ci coinInfo
+	return decodeCoinInfo(keyJSON.Bytes())
+}
+
+//SetCoinInfo stores service address and privKey as Coin.<TAG>.JSON
+func (s *Storage) SetCoinInfo(coinTag, address, key string) error {
+	keyJSON, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	defer WipeBytes(keyJSON)
+
+	data, err := json.Marshal(coinInfo{Address: address, Key: keyJSON})
+	if err != nil {
+		return err
+	}
+	defer WipeBytes(data)
+
+	return s.Set("Coin."+coinTag+".JSON", data)
+}
+
+//GetAddressKey return privKey for deposit address stored as Coin.<TAG>.<address>.JSON, caller must Release it
+func (s *Storage) GetAddressKey(coinTag, address string) (*SecureBuffer, error) {
+	scid := "Coin." + coinTag + "." + address + ".JSON"
 
-	err = json.Unmarshal(keyJSON, &ci)
+	keyJSON, ok := s.Get(scid)
+	if !ok {
+		return nil, fmt.Errorf("key for [%s] not found in storage", address)
+	}
+	defer keyJSON.Release()
+
+	ciAddress, key, err := decodeCoinInfo(keyJSON.Bytes())
 	if err != nil {
-		return "", "", err
+		return nil, err
+	}
+
+	if ciAddress != address {
+		key.Release()
+
+		return nil, fmt.Errorf("address mismatch [%s] != [%s]", ciAddress, address)
 	}
 
-	return ci.Address, ci.Key, nil
+	return key, nil
 }
@@ -0,0 +1,444 @@
+package gutils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// audited operations
+const (
+	AuditOpen   = "open"
+	AuditCreate = "create"
+	AuditGet    = "get"
+	AuditSet    = "set"
+	AuditMeta   = "meta"
+	AuditDelete = "delete"
+	AuditRekey  = "rekey"
+	AuditExport = "export"
+	AuditImport = "import"
+)
+
+// longest line looked at when previous entry is read
+const auditTailSize = 64 * 1024
+
+// reads are buffered and written with next change or after this interval, so lookups don't wait for fsync
+const auditFlushInterval = time.Second
+
+//AuditEntry single line of audit log, Prev is hash of previous line and MAC covers all other fields
+type AuditEntry struct {
+	Seq    uint64
+	Time   time.Time
+	Op     string
+	Item   string `json:",omitempty"`
+	Caller string
+	Result string `json:",omitempty"` // error of failed operation
+	Prev   string
+	MAC    string
+}
+
+//auditTail MAC'd position of last entry kept in <log>.tail, truncated log no longer reaches it
+type auditTail struct {
+	Seq  uint64
+	Hash string
+	MAC  string
+}
+
+//storageBackendAudit implemented by backends that have a place for audit log next to storage
+type storageBackendAudit interface {
+	AuditFile() string
+}
+
+//AuditFile -
+func (b *FileBackend) AuditFile() string {
+	return b.fileName + ".audit"
+}
+
+//AuditFile -
+func (b *DirBackend) AuditFile() string {
+	return filepath.Join(b.dir, "audit.log")
+}
+
+type auditLog struct {
+	sync.Mutex
+
+	fileName string
+	key      []byte
+
+	pending []AuditEntry // buffered reads, chained and MAC'd when written
+	timer   *time.Timer  // pending flush of reads
+}
+
+func auditKey(masterKey []byte) []byte {
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte("gutils.Storage audit"))
+
+	return mac.Sum(nil)
+}
+
+func auditCaller(creator string) string {
+	exe, _ := os.Executable()
+
+	return fmt.Sprintf("%s pid %d %s", creator, os.Getpid(), filepath.Base(exe))
+}
+
+func (e *AuditEntry) mac(key []byte) string {
+	c := *e
+	c.MAC = ""
+
+	b, _ := json.Marshal(&c)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (t *auditTail) mac(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "tail %d %s", t.Seq, t.Hash)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func auditTailFile(fileName string) string {
+	return fileName + ".tail"
+}
+
+func auditHash(line []byte) string {
+	sum := sha256.Sum256(line)
+
+	return hex.EncodeToString(sum[:])
+}
+
+//lastAuditLine returns last complete line of log without reading whole file
+func lastAuditLine(f *os.File) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := fi.Size() - auditTailSize
+	if offset < 0 {
+		offset = 0
+	}
+
+	tail := make([]byte, fi.Size()-offset)
+
+	_, err = f.ReadAt(tail, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	tail = bytes.TrimRight(tail, "\n")
+
+	if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+
+	return tail, nil
+}
+
+//add queues entry, reads are written later by timer unless flush is requested
+func (l *auditLog) add(e AuditEntry, flush bool) error {
+	l.Lock()
+	defer l.Unlock()
+
+	l.pending = append(l.pending, e)
+
+	if !flush {
+		if l.timer == nil {
+			l.timer = time.AfterFunc(auditFlushInterval, l.flushTimer)
+		}
+
+		return nil
+	}
+
+	return l.flushLocked()
+}
+
+func (l *auditLog) flushTimer() {
+	err := l.flush()
+	if err != nil {
+		RemoteLog.PutWarningS("audit", "can't write audit log [%s]: %v", l.fileName, err)
+	}
+}
+
+func (l *auditLog) flush() error {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.flushLocked()
+}
+
+//flushLocked writes pending entries, entries are dropped if write fails so log does not grow in memory
+func (l *auditLog) flushLocked() error {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+
+	if len(l.pending) == 0 {
+		return nil
+	}
+
+	entries := l.pending
+	l.pending = nil
+
+	return l.append(entries)
+}
+
+//append adds entries chained to last line with single fsync, log is locked as several processes may share it
+func (l *auditLog) append(entries []AuditEntry) error {
+	unlock, err := lockFile(l.fileName+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(l.fileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, storageFileMode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	last, err := lastAuditLine(f)
+	if err != nil {
+		return err
+	}
+
+	var prev AuditEntry
+
+	if len(last) > 0 {
+		err = json.Unmarshal(last, &prev)
+		if err != nil {
+			return fmt.Errorf("last entry: %v", err)
+		}
+	}
+
+	var lines []byte
+
+	for _, e := range entries {
+		if len(last) > 0 {
+			e.Seq = prev.Seq + 1
+			e.Prev = auditHash(last)
+		}
+
+		e.MAC = e.mac(l.key)
+
+		last, err = json.Marshal(&e)
+		if err != nil {
+			return err
+		}
+
+		lines = append(append(lines, last...), '\n')
+		prev = e
+	}
+
+	_, err = f.Write(lines)
+	if err != nil {
+		return err
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+
+	t := auditTail{Seq: prev.Seq, Hash: auditHash(last)}
+	t.MAC = t.mac(l.key)
+
+	data, err := json.Marshal(&t)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(auditTailFile(l.fileName), data, 0)
+}
+
+//archive moves log aside as <log>.<suffix>, next entry starts new log
+func (l *auditLog) archive(suffix string) error {
+	unlock, err := lockFile(l.fileName+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = os.Rename(l.fileName, l.fileName+"."+suffix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.Rename(auditTailFile(l.fileName), auditTailFile(l.fileName+"."+suffix))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+//rekeyAudit closes log of previous key with rekey entry and archives it as <log>.<old key id>, log of
+//new key starts with another rekey entry
+func (s *Storage) rekeyAudit(oldKeyID []byte) {
+	if s.auditLog == nil {
+		return
+	}
+
+	s.audit(AuditRekey, "", nil)
+
+	s.auditLog.Lock()
+
+	err := s.auditLog.archive(hex.EncodeToString(oldKeyID))
+	if err != nil {
+		RemoteLog.PutWarningS("audit", "can't archive audit log [%s]: %v", s.auditLog.fileName, err)
+	}
+
+	s.auditLog.key = auditKey(s.masterKey.Bytes())
+
+	s.auditLog.Unlock()
+
+	s.audit(AuditRekey, "", nil)
+}
+
+//audit records operation, failure to write log does not fail operation itself, reads are written along
+//with next change, by Close or within auditFlushInterval, so crash may lose the last of them
+func (s *Storage) audit(op, item string, result error) {
+	if s.auditLog == nil {
+		return
+	}
+
+	e := AuditEntry{Time: time.Now().UTC(), Op: op, Item: item, Caller: auditCaller(s.creator)}
+
+	if result != nil {
+		e.Result = result.Error()
+	}
+
+	err := s.auditLog.add(e, op != AuditGet)
+	if err != nil {
+		RemoteLog.PutWarningS("audit", "can't write audit log [%s]: %v", s.auditLog.fileName, err)
+	}
+}
+
+//initAudit derives log key while masterKey is known, log of storage being created replaces one left by previous storage
+func (s *Storage) initAudit(create bool) {
+	a, ok := s.backend.(storageBackendAudit)
+	if !ok || s.masterKey == nil {
+		return
+	}
+
+	s.auditLog = &auditLog{fileName: a.AuditFile(), key: auditKey(s.masterKey.Bytes())}
+
+	if create {
+		err := s.auditLog.archive(time.Now().UTC().Format("20060102150405"))
+		if err != nil {
+			RemoteLog.PutWarningS("audit", "can't archive audit log [%s]: %v", s.auditLog.fileName, err)
+		}
+	}
+}
+
+//readAuditTail reads and authenticates tail of log
+func readAuditTail(fileName string, key []byte) (*auditTail, error) {
+	var t auditTail
+
+	data, err := ioutil.ReadFile(auditTailFile(fileName))
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &t)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal([]byte(t.MAC), []byte(t.mac(key))) {
+		return nil, errors.New("MAC mismatch, tail changed or written with other key")
+	}
+
+	return &t, nil
+}
+
+//VerifyAuditLog checks MACs, hash chain and sequence of log written under masterKey, log must start with
+//entry 0 and reach last entry recorded in tail file so removed head or tail is detected, entries read before
+//first broken one are returned along with error describing it, removing log together with tail file or
+//restoring both from older copy is not detected
+func VerifyAuditLog(fileName string, masterKey []byte) ([]AuditEntry, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	key := auditKey(masterKey)
+
+	tail, err := readAuditTail(fileName, key)
+	if err != nil {
+		return nil, fmt.Errorf("tail: %v", err)
+	}
+
+	var entries []AuditEntry
+	var prevLine []byte
+
+	tailFound := false
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, auditTailSize), auditTailSize)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		var e AuditEntry
+
+		err = json.Unmarshal(line, &e)
+		if err != nil {
+			return entries, fmt.Errorf("entry %d: %v", len(entries), err)
+		}
+
+		if !hmac.Equal([]byte(e.MAC), []byte(e.mac(key))) {
+			return entries, fmt.Errorf("entry %d: MAC mismatch, entry changed or written with other key", e.Seq)
+		}
+
+		if prevLine == nil {
+			if e.Seq != 0 || len(e.Prev) > 0 {
+				return entries, fmt.Errorf("entry %d: log does not start with entry 0, head removed", e.Seq)
+			}
+		} else {
+			if e.Prev != auditHash(prevLine) {
+				return entries, fmt.Errorf("entry %d: previous entry removed or changed", e.Seq)
+			}
+
+			if e.Seq != entries[len(entries)-1].Seq+1 {
+				return entries, fmt.Errorf("entry %d: sequence gap after %d", e.Seq, entries[len(entries)-1].Seq)
+			}
+		}
+
+		if e.Seq == tail.Seq && auditHash(line) == tail.Hash {
+			tailFound = true
+		}
+
+		entries = append(entries, e)
+		prevLine = append(prevLine[:0], line...)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return entries, err
+	}
+
+	// entries past the tail are accepted as crash may come between writing entry and its tail
+	if !tailFound {
+		return entries, fmt.Errorf("entry %d recorded in tail not found, tail of log removed", tail.Seq)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,136 @@
+package gutils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+//testAuditLog writes log of storage with a few changes and reads, returns log file and master key
+func testAuditLog(t *testing.T) (string, []byte) {
+	fileName := filepath.Join(t.TempDir(), "storage.dat")
+	key := testKey(t)
+
+	s, err := CreateStorage(fileName, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range testStorageItems {
+		err = s.Set(name, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, ok := s.Get(name)
+		if !ok {
+			t.Fatalf("item [%s] not found", name)
+		}
+
+		b.Release()
+	}
+
+	// reads only, written by Close
+	for name := range testStorageItems {
+		b, _ := s.Get(name)
+		b.Release()
+	}
+
+	s.Close()
+
+	return NewFileBackend(fileName).AuditFile(), key
+}
+
+func TestAuditLogBatchedReads(t *testing.T) {
+	logName, key := testAuditLog(t)
+
+	entries, err := VerifyAuditLog(logName, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create, set and get of every item, then get of every item
+	want := 1 + 3*len(testStorageItems)
+
+	if len(entries) != want {
+		t.Fatalf("%d entries, want %d", len(entries), want)
+	}
+
+	for i, e := range entries {
+		if e.Seq != uint64(i) {
+			t.Errorf("entry %d has Seq %d", i, e.Seq)
+		}
+	}
+
+	if entries[0].Op != AuditCreate || entries[len(entries)-1].Op != AuditGet {
+		t.Errorf("log starts with %s and ends with %s", entries[0].Op, entries[len(entries)-1].Op)
+	}
+
+	_, err = VerifyAuditLog(logName, testKey(t))
+	if err == nil {
+		t.Error("log verified with other key")
+	}
+}
+
+func TestAuditLogTampered(t *testing.T) {
+	logName, key := testAuditLog(t)
+
+	data, err := ioutil.ReadFile(logName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tail, err := ioutil.ReadFile(auditTailFile(logName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	lines = lines[:len(lines)-1] // empty after last newline
+
+	join := func(parts ...[][]byte) []byte {
+		var b []byte
+
+		for _, p := range parts {
+			b = append(b, bytes.Join(p, nil)...)
+		}
+
+		return b
+	}
+
+	edited := append([]byte{}, lines[2]...)
+	edited = bytes.Replace(edited, []byte(`"Op":"`), []byte(`"Op":"x`), 1)
+
+	tests := []struct {
+		name string
+		log  []byte
+	}{
+		{"edited entry", join(lines[:2], [][]byte{edited}, lines[3:])},
+		{"removed entry", join(lines[:2], lines[3:])},
+		{"swapped entries", join(lines[:2], [][]byte{lines[3], lines[2]}, lines[4:])},
+		{"removed head", join(lines[1:])},
+		{"removed tail", join(lines[:len(lines)-1])},
+		{"truncated", join(lines[:3])},
+		{"torn last line", data[:len(data)-5]},
+		{"empty", nil},
+	}
+
+	for _, tt := range tests {
+		tamperedName := filepath.Join(t.TempDir(), "audit.log")
+
+		err = ioutil.WriteFile(tamperedName, tt.log, storageFileMode)
+		if err == nil {
+			err = ioutil.WriteFile(auditTailFile(tamperedName), tail, storageFileMode)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = VerifyAuditLog(tamperedName, key)
+		if err == nil {
+			t.Errorf("%s: log verified", tt.name)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package gutils
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//StorageBackend persists storage records, records are encrypted and authenticated by Storage before they
+//reach backend, Storage serializes calls
+type StorageBackend interface {
+	//Load returns all records, empty map for new storage, accept validates records of one generation,
+	//backend keeping several generations may try older one if accept fails
+	Load(accept func(records map[string][]byte) error) (map[string][]byte, error)
+
+	//Save writes changed records and removes deleted ones, atomically if backend allows
+	Save(changed map[string][]byte, deleted []string) error
+
+	Close() error
+}
+
+//storageBackendPurger implemented by backends keeping previous generations, called after Rekey
+type storageBackendPurger interface {
+	PurgeBackups()
+}
+
+func copyRecords(records map[string][]byte) map[string][]byte {
+	c := make(map[string][]byte, len(records))
+
+	for k, v := range records {
+		c[k] = append([]byte{}, v...)
+	}
+
+	return c
+}
+
+func acceptRecords(records map[string][]byte, accept func(records map[string][]byte) error) (map[string][]byte, error) {
+	err := accept(records)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+//MemoryBackend keeps records in memory, for tests
+type MemoryBackend struct {
+	sync.Mutex
+
+	records map[string][]byte
+}
+
+//NewMemoryBackend -
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{records: make(map[string][]byte)}
+}
+
+//Load -
+func (b *MemoryBackend) Load(accept func(records map[string][]byte) error) (map[string][]byte, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	return acceptRecords(copyRecords(b.records), accept)
+}
+
+//Save -
+func (b *MemoryBackend) Save(changed map[string][]byte, deleted []string) error {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, name := range deleted {
+		delete(b.records, name)
+	}
+
+	for name, record := range copyRecords(changed) {
+		b.records[name] = record
+	}
+
+	return nil
+}
+
+//Close -
+func (b *MemoryBackend) Close() error {
+	return nil
+}
+
+//DirBackend keeps every record in its own file, saved state is generation directory gen.<N> pointed to
+//by symlink current, save links unchanged records into next generation, writes changed ones and switches
+//link by rename, so either old or new state survives a crash, previous generation is kept for load fallback
+type DirBackend struct {
+	dir string
+}
+
+const (
+	dirBackendExt     = ".rec"
+	dirBackendCurrent = "current"
+	dirBackendGen     = "gen."
+)
+
+//NewDirBackend creates directory if missing
+func NewDirBackend(dir string) (*DirBackend, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DirBackend{dir: dir}, nil
+}
+
+//dirRecordFile file name of record, names are hashed as up to 255 bytes long item names don't fit NAME_MAX
+func dirRecordFile(name string) string {
+	sum := sha256.Sum256([]byte(name))
+
+	return hex.EncodeToString(sum[:]) + dirBackendExt
+}
+
+//encodeDirRecord prefixes record with its name
+func encodeDirRecord(name string, record []byte) []byte {
+	b := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(name)+len(record))
+
+	b = b[:binary.PutUvarint(b, uint64(len(name)))]
+	b = append(b, name...)
+
+	return append(b, record...)
+}
+
+func decodeDirRecord(data []byte) (string, []byte, error) {
+	n, l := binary.Uvarint(data)
+	if l <= 0 || uint64(len(data)-l) < n {
+		return "", nil, errors.New("record truncated")
+	}
+
+	return string(data[l : l+int(n)]), data[l+int(n):], nil
+}
+
+func (b *DirBackend) genDir(gen int) string {
+	return filepath.Join(b.dir, dirBackendGen+strconv.Itoa(gen))
+}
+
+//current returns generation pointed to by current link, 0 if storage was never saved in generations
+func (b *DirBackend) current() (int, error) {
+	target, err := os.Readlink(filepath.Join(b.dir, dirBackendCurrent))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	gen, err := strconv.Atoi(strings.TrimPrefix(target, dirBackendGen))
+	if err != nil || gen <= 0 {
+		return 0, fmt.Errorf("unexpected link target [%s]", target)
+	}
+
+	return gen, nil
+}
+
+//generations returns existing generations up to last, newest first
+func (b *DirBackend) generations(last int) ([]int, error) {
+	dirs, err := filepath.Glob(filepath.Join(b.dir, dirBackendGen+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var gens []int
+
+	for _, dir := range dirs {
+		gen, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), dirBackendGen))
+		if err == nil && gen <= last {
+			gens = append(gens, gen)
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(gens)))
+
+	return gens, nil
+}
+
+//readGeneration reads records of generation directory
+func readGeneration(dir string) (map[string][]byte, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+dirBackendExt))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string][]byte)
+
+	for _, fileName := range files {
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		name, record, err := decodeDirRecord(data)
+		if err == nil && dirRecordFile(name) != filepath.Base(fileName) {
+			err = errors.New("name mismatch")
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("[%s]: %v", fileName, err)
+		}
+
+		records[name] = record
+	}
+
+	return records, nil
+}
+
+//Load falls back to previous generation if current one is damaged or rejected by accept
+func (b *DirBackend) Load(accept func(records map[string][]byte) error) (map[string][]byte, error) {
+	current, err := b.current()
+	if err != nil {
+		return nil, err
+	}
+
+	if current == 0 {
+		return acceptRecords(map[string][]byte{}, accept)
+	}
+
+	gens, err := b.generations(current)
+	if err != nil {
+		return nil, err
+	}
+
+	var errFirst error
+
+	for _, gen := range gens {
+		records, err := readGeneration(b.genDir(gen))
+		if err == nil {
+			err = accept(records)
+		}
+
+		if err == nil {
+			if gen != current {
+				RemoteLog.PutWarningS("OpenStorage", "[%s] generation %d can't be loaded (%v), recovered from generation %d", b.dir, current, errFirst, gen)
+			}
+
+			return records, nil
+		}
+
+		if errFirst == nil {
+			errFirst = err
+		}
+
+		if err == errStorageWrongKey {
+			break // previous generation is encrypted with the same key
+		}
+	}
+
+	if errFirst == nil {
+		errFirst = fmt.Errorf("generation %d of [%s] not found", current, b.dir)
+	}
+
+	return nil, errFirst
+}
+
+//linkOrCopy hard links record file of previous generation, copies it where links are not supported
+func linkOrCopy(oldName, newName string) error {
+	if os.Link(oldName, newName) == nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(oldName)
+	if err != nil {
+		return err
+	}
+
+	return writeSynced(newName, data)
+}
+
+//writeSynced writes new file and flushes it to disk
+func writeSynced(fileName string, data []byte) error {
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, storageFileMode)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if errC := f.Close(); err == nil {
+		err = errC
+	}
+
+	return err
+}
+
+//Save builds next generation and switches current link to it, generations older than previous one are removed
+func (b *DirBackend) Save(changed map[string][]byte, deleted []string) error {
+	current, err := b.current()
+	if err != nil {
+		return err
+	}
+
+	next := current + 1
+	nextDir := b.genDir(next)
+
+	// leftover of interrupted save
+	err = os.RemoveAll(nextDir)
+	if err != nil {
+		return err
+	}
+
+	err = os.Mkdir(nextDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	if current > 0 {
+		skip := make(map[string]bool)
+
+		for _, name := range deleted {
+			skip[dirRecordFile(name)] = true
+		}
+
+		for name := range changed {
+			skip[dirRecordFile(name)] = true
+		}
+
+		files, err := filepath.Glob(filepath.Join(b.genDir(current), "*"+dirBackendExt))
+		if err != nil {
+			return err
+		}
+
+		for _, fileName := range files {
+			if skip[filepath.Base(fileName)] {
+				continue
+			}
+
+			err = linkOrCopy(fileName, filepath.Join(nextDir, filepath.Base(fileName)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, record := range changed {
+		err = writeSynced(filepath.Join(nextDir, dirRecordFile(name)), encodeDirRecord(name, record))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = syncDir(nextDir)
+	if err != nil {
+		return err
+	}
+
+	link := filepath.Join(b.dir, dirBackendCurrent)
+
+	os.Remove(link + ".tmp")
+
+	err = os.Symlink(filepath.Base(nextDir), link+".tmp")
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(link+".tmp", link)
+	if err != nil {
+		return err
+	}
+
+	err = syncDir(b.dir)
+	if err != nil {
+		return err
+	}
+
+	if current == 0 {
+		files, _ := filepath.Glob(filepath.Join(b.dir, "*"+dirBackendExt))
+
+		for _, fileName := range files {
+			os.Remove(fileName)
+		}
+	}
+
+	b.removeGenerations(current)
+
+	return nil
+}
+
+//removeGenerations removes generations older than keep, errors are ignored as they are retried on next save
+func (b *DirBackend) removeGenerations(keep int) {
+	gens, err := b.generations(keep - 1)
+	if err != nil {
+		return
+	}
+
+	for _, gen := range gens {
+		os.RemoveAll(b.genDir(gen))
+	}
+}
+
+//PurgeBackups removes previous generation
+func (b *DirBackend) PurgeBackups() {
+	current, err := b.current()
+	if err == nil {
+		b.removeGenerations(current)
+	}
+}
+
+//Close -
+func (b *DirBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,353 @@
+package gutils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const storageBundleVersion = 1
+
+var storageBundleLabel = []byte("gutils.Storage bundle")
+
+// conflict handling of Import for items already in storage
+const (
+	ImportSkip      = iota // existing item is kept
+	ImportOverwrite        // existing item is replaced
+	ImportNewer            // existing item is replaced if bundle item was updated later
+	ImportFail             // nothing is imported if any item exists
+)
+
+// actions reported by Import
+const (
+	ImportActionAdd       = "add"
+	ImportActionOverwrite = "overwrite"
+	ImportActionSkip      = "skip"
+	ImportActionConflict  = "conflict"
+)
+
+//StorageBundle portable export of items, items are encrypted by random bundle key wrapped to recipient
+//by RSA-OAEP, whole bundle is signed by sender
+type StorageBundle struct {
+	Version int
+	Created time.Time
+	Creator string
+
+	Recipient []byte // sha256 of recipient public key
+	Signer    []byte // sha256 of sender public key
+
+	Key   []byte // bundle key wrapped by recipient public key
+	Items []byte // gob of items encrypted by bundle key
+
+	Signature []byte // RSA-PSS over bundle with empty Signature
+}
+
+//ImportEntry action planned or taken by Import for bundle item
+type ImportEntry struct {
+	StorageEntry // as in bundle
+
+	Action string
+}
+
+type bundleItem struct {
+	Name string
+	Data []byte
+	Meta ItemMeta
+}
+
+func publicKeyID(key *rsa.PublicKey) []byte {
+	sum := sha256.Sum256(x509.MarshalPKCS1PublicKey(key))
+
+	return sum[:]
+}
+
+func (b *StorageBundle) signedPart() ([]byte, error) {
+	c := *b
+	c.Signature = nil
+
+	return json.Marshal(&c)
+}
+
+func wipeBundleItems(items []bundleItem) {
+	for i := range items {
+		WipeBytes(items[i].Data)
+	}
+}
+
+//Export encrypts items to recipient and signs bundle by signer, result is JSON
+func (s *Storage) Export(names []string, recipient *rsa.PublicKey, signer *rsa.PrivateKey) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	items := make([]bundleItem, 0, len(names))
+	defer func() { wipeBundleItems(items) }()
+
+	exported := make(map[string]bool, len(names))
+
+	for _, name := range names {
+		it, ok := s.items[name]
+		if !ok {
+			return nil, fmt.Errorf("item [%s] not found", name)
+		}
+
+		if exported[name] {
+			return nil, fmt.Errorf("item [%s] is duplicated", name)
+		}
+
+		exported[name] = true
+
+		data, err := it.open(name, s.sessionKey.Bytes())
+
+		s.audit(AuditExport, name, err)
+
+		if err != nil {
+			return nil, fmt.Errorf("item [%s]: %v", name, err)
+		}
+
+		items = append(items, bundleItem{Name: name, Data: data, Meta: it.Meta})
+	}
+
+	return sealBundle(items, s.creator, recipient, signer)
+}
+
+//sealBundle encrypts items by new bundle key and signs bundle
+func sealBundle(items []bundleItem, creator string, recipient *rsa.PublicKey, signer *rsa.PrivateKey) ([]byte, error) {
+	var plain bytes.Buffer
+
+	err := gob.NewEncoder(&plain).Encode(items)
+	defer WipeBytes(plain.Bytes())
+
+	if err != nil {
+		return nil, err
+	}
+
+	bundleKey, err := GetRandomBuffer(32)
+	if err != nil {
+		return nil, err
+	}
+	defer WipeBytes(bundleKey)
+
+	b := StorageBundle{
+		Version:   storageBundleVersion,
+		Created:   time.Now().UTC(),
+		Creator:   creator,
+		Recipient: publicKeyID(recipient),
+		Signer:    publicKeyID(&signer.PublicKey),
+	}
+
+	b.Items, err = EncryptGCMAD(plain.Bytes(), bundleKey, storageBundleLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Key, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, recipient, bundleKey, storageBundleLabel)
+	if err != nil {
+		return nil, fmt.Errorf("EncryptOAEP: %v", err)
+	}
+
+	signed, err := b.signedPart()
+	if err != nil {
+		return nil, err
+	}
+
+	b.Signature, err = GetSignature(signed, signer)
+	if err != nil {
+		return nil, fmt.Errorf("GetSignature: %v", err)
+	}
+
+	return json.Marshal(&b)
+}
+
+//openBundle verifies signature of sender and decrypts items by recipient key, items must be wiped by caller
+func openBundle(data []byte, recipient *rsa.PrivateKey, sender *rsa.PublicKey) (*StorageBundle, []bundleItem, error) {
+	var b StorageBundle
+
+	err := json.Unmarshal(data, &b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bundle: %v", err)
+	}
+
+	if b.Version != storageBundleVersion {
+		return nil, nil, fmt.Errorf("bundle version %d not supported", b.Version)
+	}
+
+	if !hmac.Equal(b.Signer, publicKeyID(sender)) {
+		return nil, nil, errors.New("bundle is signed by another key")
+	}
+
+	signed, err := b.signedPart()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = VerifySignature(signed, b.Signature, sender)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bundle signature: %v", err)
+	}
+
+	if !hmac.Equal(b.Recipient, publicKeyID(&recipient.PublicKey)) {
+		return nil, nil, errors.New("bundle is encrypted to another key")
+	}
+
+	bundleKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, recipient, b.Key, storageBundleLabel)
+	if err != nil {
+		return nil, nil, fmt.Errorf("DecryptOAEP: %v", err)
+	}
+	defer WipeBytes(bundleKey)
+
+	plain, err := DecryptGCMAD(b.Items, bundleKey, storageBundleLabel)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bundle items: %v", err)
+	}
+	defer WipeBytes(plain)
+
+	var items []bundleItem
+
+	err = gob.NewDecoder(bytes.NewBuffer(plain)).Decode(&items)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bundle items: %v", err)
+	}
+
+	// Import keeps one previous value per name to revert
+	names := make(map[string]bool, len(items))
+
+	for _, it := range items {
+		if names[it.Name] {
+			wipeBundleItems(items)
+
+			return nil, nil, fmt.Errorf("bundle item [%s] is duplicated", it.Name)
+		}
+
+		names[it.Name] = true
+	}
+
+	return &b, items, nil
+}
+
+//importAction decides what happens to bundle item under conflict mode
+func (s *Storage) importAction(it *bundleItem, conflict int) string {
+	existing, ok := s.items[it.Name]
+	if !ok {
+		return ImportActionAdd
+	}
+
+	switch conflict {
+	case ImportOverwrite:
+		return ImportActionOverwrite
+	case ImportNewer:
+		if it.Meta.Updated.After(existing.Meta.Updated) {
+			return ImportActionOverwrite
+		}
+	case ImportFail:
+		return ImportActionConflict
+	}
+
+	return ImportActionSkip
+}
+
+//Import verifies bundle made by sender for recipient and merges its items, with dryRun storage is not changed,
+//entries report action for every item, all changes are saved at once
+func (s *Storage) Import(data []byte, recipient *rsa.PrivateKey, sender *rsa.PublicKey, conflict int, dryRun bool) ([]ImportEntry, error) {
+	_, items, err := openBundle(data, recipient, sender)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBundleItems(items)
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+
+	s.Lock()
+	defer s.Unlock()
+
+	if !dryRun && s.masterKey == nil {
+		return nil, FormatErrorS("", "masterKey not set")
+	}
+
+	entries := make([]ImportEntry, 0, len(items))
+	conflicts := 0
+
+	for i := range items {
+		it := &items[i]
+
+		e := ImportEntry{StorageEntry: StorageEntry{Name: it.Name, Size: len(it.Data), ItemMeta: it.Meta}, Action: s.importAction(it, conflict)}
+
+		if e.Action == ImportActionConflict {
+			conflicts++
+		}
+
+		entries = append(entries, e)
+	}
+
+	if conflicts > 0 {
+		return entries, fmt.Errorf("%d items already exist", conflicts)
+	}
+
+	if dryRun {
+		return entries, nil
+	}
+
+	var changed []string
+
+	old := make(map[string]*storageItem)
+
+	for i, e := range entries {
+		if e.Action != ImportActionAdd && e.Action != ImportActionOverwrite {
+			continue
+		}
+
+		err = checkItemLimits(e.Name, items[i].Data, len(s.items))
+		if err == nil {
+			var it *storageItem
+
+			it, err = sealItem(e.Name, items[i].Data, s.sessionKey.Bytes())
+			if err == nil {
+				it.Meta = items[i].Meta
+
+				old[e.Name] = s.items[e.Name]
+				s.items[e.Name] = it
+
+				changed = append(changed, e.Name)
+
+				continue
+			}
+		}
+
+		s.revertImport(old)
+
+		return entries, fmt.Errorf("item [%s]: %v", e.Name, err)
+	}
+
+	if len(changed) == 0 {
+		return entries, nil
+	}
+
+	err = s.save(changed, nil)
+	if err != nil {
+		s.revertImport(old)
+	}
+
+	for _, name := range changed {
+		s.audit(AuditImport, name, err)
+	}
+
+	return entries, err
+}
+
+func (s *Storage) revertImport(old map[string]*storageItem) {
+	for name, it := range old {
+		if it == nil {
+			delete(s.items, name)
+		} else {
+			s.items[name] = it
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package gutils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+)
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func testMemoryStorage(t *testing.T) *Storage {
+	s, err := CreateStorageBackend(NewMemoryBackend(), testKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestStorageBundleRoundTrip(t *testing.T) {
+	sender, recipient := testRSAKey(t), testRSAKey(t)
+
+	src := testMemoryStorage(t)
+	defer src.Close()
+
+	names := make([]string, 0, len(testStorageItems))
+
+	for name, data := range testStorageItems {
+		err := src.SetWithMeta(name, data, ItemMeta{Type: "key", Description: name, Tags: []string{"export"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names = append(names, name)
+	}
+
+	bundle, err := src.Export(names, &recipient.PublicKey, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := testMemoryStorage(t)
+	defer dst.Close()
+
+	entries, err := dst.Import(bundle, recipient, &sender.PublicKey, ImportFail, true)
+	if err != nil || len(entries) != len(names) {
+		t.Fatalf("dry run: %d entries err %v", len(entries), err)
+	}
+
+	if len(dst.List()) != 0 {
+		t.Fatal("dry run changed storage")
+	}
+
+	_, err = dst.Import(bundle, recipient, &sender.PublicKey, ImportFail, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range testStorageItems {
+		b, ok := dst.Get(name)
+		if !ok || !bytes.Equal(b.Bytes(), data) {
+			t.Errorf("item [%s] not imported", name)
+
+			continue
+		}
+
+		b.Release()
+
+		meta, _ := dst.Meta(name)
+		srcMeta, _ := src.Meta(name)
+
+		if meta.Description != name || meta.Type != "key" || !meta.Updated.Equal(srcMeta.Updated) {
+			t.Errorf("item [%s] meta %+v, want %+v", name, meta, srcMeta)
+		}
+	}
+
+	// second import meets the same items
+	tests := []struct {
+		conflict int
+		action   string
+		wantErr  bool
+	}{
+		{ImportFail, ImportActionConflict, true},
+		{ImportSkip, ImportActionSkip, false},
+		{ImportNewer, ImportActionSkip, false},
+		{ImportOverwrite, ImportActionOverwrite, false},
+	}
+
+	for _, tt := range tests {
+		entries, err := dst.Import(bundle, recipient, &sender.PublicKey, tt.conflict, false)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("conflict %d: err %v", tt.conflict, err)
+		}
+
+		for _, e := range entries {
+			if e.Action != tt.action {
+				t.Errorf("conflict %d: item [%s] action %s, want %s", tt.conflict, e.Name, e.Action, tt.action)
+			}
+		}
+	}
+}
+
+func TestStorageBundleRejected(t *testing.T) {
+	sender, recipient, other := testRSAKey(t), testRSAKey(t), testRSAKey(t)
+
+	src := testMemoryStorage(t)
+	defer src.Close()
+
+	err := src.Set("item", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := src.Export([]string{"item"}, &recipient.PublicKey, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tamper := func(change func(b *StorageBundle)) []byte {
+		var b StorageBundle
+
+		err := json.Unmarshal(bundle, &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		change(&b)
+
+		data, err := json.Marshal(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return data
+	}
+
+	duplicated, err := sealBundle([]bundleItem{{Name: "item", Data: []byte("a")}, {Name: "item", Data: []byte("b")}}, "test", &recipient.PublicKey, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		bundle    []byte
+		recipient *rsa.PrivateKey
+		sender    *rsa.PublicKey
+	}{
+		{"items", tamper(func(b *StorageBundle) { b.Items[len(b.Items)-1] ^= 1 }), recipient, &sender.PublicKey},
+		{"key", tamper(func(b *StorageBundle) { b.Key[0] ^= 1 }), recipient, &sender.PublicKey},
+		{"creator", tamper(func(b *StorageBundle) { b.Creator = "someone" }), recipient, &sender.PublicKey},
+		{"signature", tamper(func(b *StorageBundle) { b.Signature[0] ^= 1 }), recipient, &sender.PublicKey},
+		{"version", tamper(func(b *StorageBundle) { b.Version++ }), recipient, &sender.PublicKey},
+		{"wrong recipient", bundle, other, &sender.PublicKey},
+		{"wrong sender", bundle, recipient, &other.PublicKey},
+		{"duplicated", duplicated, recipient, &sender.PublicKey},
+		{"not json", bundle[1:], recipient, &sender.PublicKey},
+	}
+
+	dst := testMemoryStorage(t)
+	defer dst.Close()
+
+	for _, tt := range tests {
+		_, err := dst.Import(tt.bundle, tt.recipient, tt.sender, ImportOverwrite, false)
+		if err == nil {
+			t.Errorf("%s: bundle imported", tt.name)
+		}
+	}
+
+	if len(dst.List()) != 0 {
+		t.Error("rejected bundle changed storage")
+	}
+
+	_, err = dst.Import(bundle, recipient, &sender.PublicKey, ImportOverwrite, false)
+	if err != nil {
+		t.Errorf("untouched bundle: %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package gutils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//StorageBackupsDefault backup generations kept next to storage file as <file>.1 (newest) .. <file>.N
+const StorageBackupsDefault = 3
+
+const storageFileMode = 0600
+
+func storageTempName(fileName string) string {
+	return fileName + ".tmp"
+}
+
+func storageBackupName(fileName string, n int) string {
+	return fmt.Sprintf("%s.%d", fileName, n)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+//writeTemp writes data to temp file next to fileName and flushes it to disk
+func writeTemp(fileName string, data []byte) (string, error) {
+	tmpName := storageTempName(fileName)
+
+	f, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, storageFileMode)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if errC := f.Close(); err == nil {
+		err = errC
+	}
+
+	if err != nil {
+		os.Remove(tmpName)
+
+		return "", err
+	}
+
+	return tmpName, nil
+}
+
+//rotateBackups shifts <file>.1 .. <file>.N-1 one generation up and moves current file to <file>.1
+func rotateBackups(fileName string, backups int) error {
+	if backups <= 0 {
+		return nil
+	}
+
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	for n := backups - 1; n >= 1; n-- {
+		err := os.Rename(storageBackupName(fileName, n), storageBackupName(fileName, n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Rename(fileName, storageBackupName(fileName, 1))
+}
+
+//writeFileAtomic replaces fileName with data so that either old or new content survives a crash,
+//old content is kept as backup generation
+func writeFileAtomic(fileName string, data []byte, backups int) error {
+	tmpName, err := writeTemp(fileName, data)
+	if err != nil {
+		return err
+	}
+
+	err = rotateBackups(fileName, backups)
+	if err != nil {
+		os.Remove(tmpName)
+
+		return fmt.Errorf("rotateBackups: %v", err)
+	}
+
+	err = os.Rename(tmpName, fileName)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(fileName))
+}
+
+//removeBackups deletes all backup generations, errors are ignored as backups may be missing
+func removeBackups(fileName string, backups int) {
+	for n := 1; n <= backups; n++ {
+		os.Remove(storageBackupName(fileName, n))
+	}
+
+	os.Remove(storageTempName(fileName))
+
+	syncDir(filepath.Dir(fileName))
+}
+
+//storageCandidates files load tries in order: current file, complete temp file left by interrupted save, backups newest first
+func storageCandidates(fileName string, backups int) []string {
+	names := []string{fileName, storageTempName(fileName)}
+
+	for n := 1; n <= backups; n++ {
+		names = append(names, storageBackupName(fileName, n))
+	}
+
+	return names
+}
+
+//parseStorageFile splits file into records, legacy file is returned whole as storageRecordRaw
+func parseStorageFile(data []byte) (map[string][]byte, error) {
+	header, err := parseStorageHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if header == nil {
+		return map[string][]byte{storageRecordRaw: data}, nil
+	}
+
+	if len(data) < storageHeaderSize+sha256.Size {
+		return nil, errStorageCorrupt
+	}
+
+	body, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+
+	if check := sha256.Sum256(body); !bytes.Equal(check[:], sum) {
+		return nil, errStorageCorrupt
+	}
+
+	records := make(map[string][]byte)
+
+	err = gob.NewDecoder(bytes.NewBuffer(body[storageHeaderSize:])).Decode(&records)
+	if err != nil {
+		return nil, err
+	}
+
+	records[storageRecordHeader] = body[:storageHeaderSize]
+
+	return records, nil
+}
+
+//marshalStorageFile header record goes first so file stays self-describing, checksum detects torn writes
+func marshalStorageFile(records map[string][]byte) ([]byte, error) {
+	header, ok := records[storageRecordHeader]
+	if !ok || len(header) != storageHeaderSize {
+		return nil, fmt.Errorf("header record missing")
+	}
+
+	rest := make(map[string][]byte, len(records))
+
+	for name, record := range records {
+		if name != storageRecordHeader {
+			rest[name] = record
+		}
+	}
+
+	b := bytes.NewBuffer(append([]byte{}, header...))
+
+	err := gob.NewEncoder(b).Encode(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(b.Bytes())
+
+	return append(b.Bytes(), sum[:]...), nil
+}
+
+//FileBackend keeps all records in single file replaced atomically, previous versions are kept as backups
+type FileBackend struct {
+	fileName string
+	backups  int
+
+	records map[string][]byte // as last loaded or saved
+}
+
+//NewFileBackend -
+func NewFileBackend(fileName string) *FileBackend {
+	return &FileBackend{fileName: fileName, backups: StorageBackupsDefault, records: make(map[string][]byte)}
+}
+
+//SetBackups sets number of backup generations kept on save, 0 disables backups
+func (b *FileBackend) SetBackups(n int) {
+	b.backups = n
+}
+
+//Load falls back to temp file and backups if current file is damaged or rejected by accept
+func (b *FileBackend) Load(accept func(records map[string][]byte) error) (map[string][]byte, error) {
+	var errFirst error
+
+	for _, name := range storageCandidates(b.fileName, b.backups) {
+		var records map[string][]byte
+
+		data, err := ioutil.ReadFile(name)
+		if err == nil {
+			records, err = parseStorageFile(data)
+		}
+
+		if err == nil {
+			err = accept(records)
+		}
+
+		if err == nil {
+			if name != b.fileName {
+				RemoteLog.PutWarningS("OpenStorage", "[%s] can't be loaded (%v), recovered from [%s]", b.fileName, errFirst, name)
+			}
+
+			b.records = records
+
+			return records, nil
+		}
+
+		if errFirst == nil {
+			errFirst = err
+		}
+
+		if err == errStorageWrongKey {
+			break // backups are encrypted with the same key
+		}
+	}
+
+	return nil, errFirst
+}
+
+//Save -
+func (b *FileBackend) Save(changed map[string][]byte, deleted []string) error {
+	records := make(map[string][]byte, len(b.records)+len(changed))
+
+	for name, record := range b.records {
+		records[name] = record
+	}
+
+	for _, name := range deleted {
+		delete(records, name)
+	}
+
+	for name, record := range changed {
+		records[name] = record
+	}
+
+	data, err := marshalStorageFile(records)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(b.fileName, data, b.backups)
+	if err != nil {
+		return err
+	}
+
+	b.records = records
+
+	return nil
+}
+
+//PurgeBackups removes all backup generations
+func (b *FileBackend) PurgeBackups() {
+	removeBackups(b.fileName, b.backups)
+}
+
+//Close -
+func (b *FileBackend) Close() error {
+	return nil
+}
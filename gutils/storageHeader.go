@@ -0,0 +1,90 @@
+package gutils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// storage starts with header authenticated by manifest, files without magic are legacy headerless ones
+// rewritten in current format on next save
+const (
+	storageMagic = "GSTR"
+
+	StorageVersionLegacy   = 0
+	StorageVersionSingle   = 1 // all items under master key, development format never released and not readable
+	StorageVersionEnvelope = 2 // items under own data keys wrapped by master key, development format as well
+	StorageVersionRecords  = 3 // envelope items as separate backend records authenticated by manifest
+	StorageVersionCurrent  = StorageVersionRecords
+
+	StorageSuiteAESGCMGob      = 1 // AES-GCM over gob encoded map of items
+	StorageSuiteAESGCMEnvelope = 2 // AES-GCM over gob encoded map of items sealed with AES-GCM data keys
+
+	storageKeyIDSize  = 8
+	storageHeaderSize = 4 + 2 + 2 + storageKeyIDSize + 8
+)
+
+var (
+	errStorageWrongKey = errors.New("storage is encrypted with different master key")
+	errStorageCorrupt  = errors.New("storage is corrupted or tampered")
+)
+
+//StorageHeader describes storage file
+type StorageHeader struct {
+	Version uint16
+	Suite   uint16
+	KeyID   []byte // identifies master key without revealing it
+	Created time.Time
+}
+
+//storageKeyID short identifier of masterKey
+func storageKeyID(masterKey []byte) []byte {
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte("gutils.Storage key id"))
+
+	return mac.Sum(nil)[:storageKeyIDSize]
+}
+
+func (h *StorageHeader) marshal() []byte {
+	b := make([]byte, storageHeaderSize)
+
+	copy(b[0:4], storageMagic)
+	binary.BigEndian.PutUint16(b[4:6], h.Version)
+	binary.BigEndian.PutUint16(b[6:8], h.Suite)
+	copy(b[8:8+storageKeyIDSize], h.KeyID)
+	binary.BigEndian.PutUint64(b[8+storageKeyIDSize:], uint64(h.Created.Unix()))
+
+	return b
+}
+
+//parseStorageHeader returns nil header for legacy headerless data
+func parseStorageHeader(data []byte) (*StorageHeader, error) {
+	if len(data) < storageHeaderSize || !bytes.Equal(data[0:4], []byte(storageMagic)) {
+		return nil, nil
+	}
+
+	h := &StorageHeader{
+		Version: binary.BigEndian.Uint16(data[4:6]),
+		Suite:   binary.BigEndian.Uint16(data[6:8]),
+		KeyID:   append([]byte{}, data[8:8+storageKeyIDSize]...),
+		Created: time.Unix(int64(binary.BigEndian.Uint64(data[8+storageKeyIDSize:storageHeaderSize])), 0),
+	}
+
+	if h.Version > StorageVersionCurrent {
+		return nil, fmt.Errorf("storage format version %d is newer then supported %d", h.Version, StorageVersionCurrent)
+	}
+
+	if h.Version < StorageVersionRecords {
+		return nil, fmt.Errorf("storage format version %d is not supported", h.Version)
+	}
+
+	if h.Suite != StorageSuiteAESGCMEnvelope {
+		return nil, fmt.Errorf("storage cipher suite %d not supported by version %d", h.Suite, h.Version)
+	}
+
+	return h, nil
+}
@@ -0,0 +1,68 @@
+package gutils
+
+// nonce and tag added by GCM to every sealed item
+const storageItemOverhead = 12 + 16
+
+//storageItem value encrypted with its own random data key, name is bound to both as associated data,
+//Key is data key wrapped by master key in file and by session key in memory
+type storageItem struct {
+	Key  []byte
+	Data []byte
+
+	Meta ItemMeta
+}
+
+//sealItem encrypts data with new data key wrapped by wrapKey
+func sealItem(name string, data, wrapKey []byte) (*storageItem, error) {
+	dataKey, err := GetRandomBuffer(32)
+	if err != nil {
+		return nil, err
+	}
+	defer WipeBytes(dataKey)
+
+	it := &storageItem{}
+
+	it.Data, err = EncryptGCMAD(data, dataKey, []byte(name))
+	if err != nil {
+		return nil, err
+	}
+
+	it.Key, err = EncryptGCMAD(dataKey, wrapKey, []byte(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return it, nil
+}
+
+//open decrypts item, data key is wiped right after use
+func (it *storageItem) open(name string, wrapKey []byte) ([]byte, error) {
+	dataKey, err := DecryptGCMAD(it.Key, wrapKey, []byte(name))
+	if err != nil {
+		return nil, err
+	}
+	defer WipeBytes(dataKey)
+
+	return DecryptGCMAD(it.Data, dataKey, []byte(name))
+}
+
+//rewrap returns item with data key wrapped by to instead of from, data itself is not re-encrypted
+func (it *storageItem) rewrap(name string, from, to []byte) (*storageItem, error) {
+	dataKey, err := DecryptGCMAD(it.Key, from, []byte(name))
+	if err != nil {
+		return nil, err
+	}
+	defer WipeBytes(dataKey)
+
+	key, err := EncryptGCMAD(dataKey, to, []byte(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return &storageItem{Key: key, Data: it.Data, Meta: it.Meta}, nil
+}
+
+//size plaintext length of item
+func (it *storageItem) size() int {
+	return len(it.Data) - storageItemOverhead
+}
@@ -0,0 +1,193 @@
+package gutils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"time"
+)
+
+const (
+	storageLockTimeout = 10 * time.Second
+	storageLockRetry   = 50 * time.Millisecond
+)
+
+var (
+	errStorageLocked = errors.New("storage is locked by another process")
+	errStorageStale  = errors.New("storage changed since load, reload and retry")
+	errStorageNoKey  = errors.New("masterKey not set, storage must be opened with FlagPreserveKey to reload")
+)
+
+//storageBackendLocker implemented by backends shared between processes, load takes shared lock and save exclusive one
+type storageBackendLocker interface {
+	Lock(exclusive bool) (func(), error)
+}
+
+//lockFile takes advisory lock on fileName, created if missing, returns unlock function
+func lockFile(fileName string, exclusive bool) (func(), error) {
+	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, storageFileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(storageLockTimeout)
+
+	for {
+		locked, err := tryLockFile(f, exclusive)
+		if err != nil {
+			f.Close()
+
+			return nil, err
+		}
+
+		if locked {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			f.Close()
+
+			return nil, errStorageLocked
+		}
+
+		time.Sleep(storageLockRetry)
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+//Lock locks <file>.lock as storage file itself is replaced on every save
+func (b *FileBackend) Lock(exclusive bool) (func(), error) {
+	return lockFile(b.fileName+".lock", exclusive)
+}
+
+//Lock -
+func (b *DirBackend) Lock(exclusive bool) (func(), error) {
+	return lockFile(b.dir+"/.lock", exclusive)
+}
+
+//recordsGeneration identifies saved state of storage, manifest changes on every save
+func recordsGeneration(records map[string][]byte) []byte {
+	if manifest, ok := records[storageRecordManifest]; ok {
+		return manifest
+	}
+
+	if raw, ok := records[storageRecordRaw]; ok {
+		sum := sha256.Sum256(raw)
+
+		return sum[:]
+	}
+
+	return nil
+}
+
+func (s *Storage) lockBackend(exclusive bool) (func(), error) {
+	if l, ok := s.backend.(storageBackendLocker); ok {
+		return l.Lock(exclusive)
+	}
+
+	return func() {}, nil
+}
+
+//verify accepts the same records as accept without changing storage, records format is checked by manifest only
+func (s *Storage) verify(records map[string][]byte) error {
+	if len(records) == 0 {
+		return errStorageEmpty
+	}
+
+	if raw, ok := records[storageRecordRaw]; ok {
+		_, _, err := decodeBlob(raw, s.masterKey.Bytes(), s.sessionKey.Bytes())
+
+		return err
+	}
+
+	_, err := checkRecords(records, s.masterKey.Bytes())
+
+	return err
+}
+
+//peekGeneration reads generation of saved state load would pick, so damaged current generation
+//skipped by load is skipped here too instead of being reported as change, caller holds backend lock
+func (s *Storage) peekGeneration() ([]byte, error) {
+	records, err := s.backend.Load(s.verify)
+	if err != nil {
+		return nil, err
+	}
+
+	return recordsGeneration(records), nil
+}
+
+//Reload loads storage again if it was changed by another process, records are sealed by masterKey so storage
+//must be opened with FlagPreserveKey and keep key in memory, service wiping key has to reopen storage instead
+func (s *Storage) Reload() (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.reload()
+}
+
+func (s *Storage) reload() (bool, error) {
+	if s.masterKey == nil {
+		return false, errStorageNoKey
+	}
+
+	unlock, err := s.lockBackend(false)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	generation, err := s.peekGeneration()
+	if err != nil {
+		return false, err
+	}
+
+	if bytes.Equal(generation, s.generation) {
+		return false, nil
+	}
+
+	return true, s.loadLocked()
+}
+
+//Watch checks storage every interval and reloads it when changed by another process, notify is called after
+//every reload attempt with its result, returns stop function, fails if masterKey is not kept as for Reload,
+//watcher stops itself after notifying error if key is wiped later
+func (s *Storage) Watch(interval time.Duration, notify func(err error)) (func(), error) {
+	s.Lock()
+	noKey := s.masterKey == nil
+	s.Unlock()
+
+	if noKey {
+		return nil, errStorageNoKey
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				changed, err := s.Reload()
+
+				if (changed || err != nil) && notify != nil {
+					notify(err)
+				}
+
+				if err == errStorageNoKey {
+					return
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }, nil
+}
@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package gutils
+
+import "os"
+
+//tryLockFile does nothing where advisory locks are not available, processes sharing storage are not
+//excluded then and only stale generation check of save protects changes made by another process
+func tryLockFile(f *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(f *os.File) {}
@@ -0,0 +1,104 @@
+package gutils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorageSaveAfterRecovery(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "storage.dat")
+	key := testKey(t)
+
+	s, err := CreateStorage(fileName, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// twice so that newest backup holds all items too
+	for i := 0; i < 2; i++ {
+		for name, data := range testStorageItems {
+			err = s.Set(name, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	s.Close()
+
+	// current file stays well formed but one of its records does not match manifest
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := parseStorageFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := append([]byte{}, records["service.pem"]...)
+	record[len(record)-1] ^= 1
+	records["service.pem"] = record
+
+	data, err = marshalStorageFile(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(fileName, data, storageFileMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenStorage(fileName, key, FlagPreserveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := s.Reload()
+	if err != nil || changed {
+		t.Errorf("reload of recovered storage: changed %v err %v", changed, err)
+	}
+
+	err = s.Set("added", []byte("added"))
+	if err != nil {
+		t.Fatalf("save after recovery: %v", err)
+	}
+
+	s.Close()
+
+	s, err = OpenStorage(fileName, key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkStorageItems(t, s, "added")
+	s.Close()
+
+	// another process replacing current file is still detected
+	s, err = OpenStorage(fileName, key, FlagPreserveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := OpenStorage(fileName, key, FlagPreserveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = other.Set("other", []byte("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other.Close()
+
+	err = s.Set("added", []byte("again"))
+	if err != errStorageStale {
+		t.Errorf("save over newer file: err %v, want %v", err, errStorageStale)
+	}
+
+	s.Close()
+}
@@ -0,0 +1,180 @@
+package gutils
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+)
+
+// item content types, detected on Set when not given explicitly
+const (
+	ItemTypeRaw      = "raw"
+	ItemTypePEM      = "pem"
+	ItemTypeCertPEM  = "cert-pem"
+	ItemTypeRSAKey   = "rsa-key-pem"
+	ItemTypeCoinJSON = "coin-json"
+	ItemTypeJSON     = "json"
+)
+
+//ItemMeta item description kept next to encrypted value
+type ItemMeta struct {
+	Type string
+
+	Created time.Time
+	Updated time.Time
+	Creator string
+
+	Description string
+	Tags        []string
+}
+
+//StorageEntry item as returned by List and Find, value is not included
+type StorageEntry struct {
+	Name string
+	Size int
+
+	ItemMeta
+}
+
+//StorageFilter conditions for Find, empty fields match any item
+type StorageFilter struct {
+	Prefix string
+	Type   string
+	Tag    string
+}
+
+func defaultCreator() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+
+	return u.Username
+}
+
+//DetectItemType guesses content type by name and value
+func DetectItemType(name string, data []byte) string {
+	if strings.HasPrefix(name, "Coin.") && strings.HasSuffix(name, ".JSON") {
+		return ItemTypeCoinJSON
+	}
+
+	if block, _ := pem.Decode(data); block != nil {
+		switch block.Type {
+		case "CERTIFICATE":
+			return ItemTypeCertPEM
+		case "RSA PRIVATE KEY":
+			return ItemTypeRSAKey
+		}
+
+		return ItemTypePEM
+	}
+
+	if json.Valid(data) {
+		return ItemTypeJSON
+	}
+
+	return ItemTypeRaw
+}
+
+func (f *StorageFilter) match(e *StorageEntry) bool {
+	if !strings.HasPrefix(e.Name, f.Prefix) {
+		return false
+	}
+
+	if len(f.Type) > 0 && e.Type != f.Type {
+		return false
+	}
+
+	if len(f.Tag) > 0 && !IsIn(f.Tag, e.Tags) {
+		return false
+	}
+
+	return true
+}
+
+//SetCreator sets name recorded as Creator of items set from now on, current OS user by default
+func (s *Storage) SetCreator(creator string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.creator = creator
+}
+
+//SetWithMeta sets item with given Type, Description and Tags, timestamps and Creator are maintained by storage
+func (s *Storage) SetWithMeta(name string, data []byte, meta ItemMeta) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.set(name, data, &meta)
+}
+
+//Meta returns metadata of item
+func (s *Storage) Meta(name string) (ItemMeta, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	it, ok := s.items[name]
+	if !ok {
+		return ItemMeta{}, false
+	}
+
+	return it.Meta, true
+}
+
+//SetMeta changes Type, Description and Tags of item without touching its value
+func (s *Storage) SetMeta(name string, meta ItemMeta) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.masterKey == nil {
+		return FormatErrorS("", "masterKey not set")
+	}
+
+	it, ok := s.items[name]
+	if !ok {
+		return fmt.Errorf("item [%s] not found", name)
+	}
+
+	if len(meta.Type) > 0 {
+		it.Meta.Type = meta.Type
+	}
+
+	it.Meta.Description = meta.Description
+	it.Meta.Tags = meta.Tags
+	it.Meta.Updated = time.Now()
+
+	err := s.save([]string{name}, nil)
+
+	s.audit(AuditMeta, name, err)
+
+	return err
+}
+
+//Find returns entries matching filter sorted by name
+func (s *Storage) Find(filter StorageFilter) []StorageEntry {
+	s.Lock()
+	defer s.Unlock()
+
+	entries := []StorageEntry{}
+
+	for name, it := range s.items {
+		e := StorageEntry{Name: name, Size: it.size(), ItemMeta: it.Meta}
+
+		if filter.match(&e) {
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	return entries
+}
+
+//List returns all entries sorted by name
+func (s *Storage) List() []StorageEntry {
+	return s.Find(StorageFilter{})
+}
@@ -0,0 +1,157 @@
+package gutils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// reserved record names, item names are never empty so they can't collide
+const (
+	storageRecordHeader   = "\x00header"
+	storageRecordManifest = "\x00manifest"
+	storageRecordRaw      = "\x00raw" // whole headerless legacy file, as read by FileBackend
+)
+
+func isReservedRecord(name string) bool {
+	return len(name) > 0 && name[0] == 0
+}
+
+//sealRecord encrypts item, already wrapped by masterKey, as backend record bound to its name
+func sealRecord(name string, fileIt *storageItem, masterKey []byte) ([]byte, error) {
+	var b bytes.Buffer
+
+	err := gob.NewEncoder(&b).Encode(fileIt)
+	if err != nil {
+		return nil, err
+	}
+
+	return EncryptGCMAD(b.Bytes(), masterKey, []byte(name))
+}
+
+func openRecord(name string, record, masterKey []byte) (*storageItem, error) {
+	d, err := DecryptGCMAD(record, masterKey, []byte(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIt storageItem
+
+	err = gob.NewDecoder(bytes.NewBuffer(d)).Decode(&fileIt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileIt, nil
+}
+
+//storageManifest authenticates header and set of item records so records can't be removed, added or rolled back one by one
+func storageManifest(masterKey []byte, records map[string][]byte) []byte {
+	keyMac := hmac.New(sha256.New, masterKey)
+	keyMac.Write([]byte("gutils.Storage manifest"))
+
+	mac := hmac.New(sha256.New, keyMac.Sum(nil))
+
+	names := make([]string, 0, len(records))
+
+	for name := range records {
+		if name != storageRecordManifest {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		sum := sha256.Sum256(records[name])
+
+		mac.Write([]byte(name))
+		mac.Write([]byte{0})
+		mac.Write(sum[:])
+	}
+
+	return mac.Sum(nil)
+}
+
+//decodeRecords checks and decrypts records of StorageVersionRecords format, items are rewrapped by sessionKey
+func decodeRecords(records map[string][]byte, masterKey, sessionKey []byte) (*StorageHeader, map[string]*storageItem, error) {
+	header, err := checkRecords(records, masterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items := make(map[string]*storageItem)
+
+	for name, record := range records {
+		if isReservedRecord(name) {
+			continue
+		}
+
+		fileIt, err := openRecord(name, record, masterKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("item [%s]: %v", name, errStorageCorrupt)
+		}
+
+		items[name], err = fileIt.rewrap(name, masterKey, sessionKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("item [%s]: %v", name, errStorageCorrupt)
+		}
+	}
+
+	return header, items, nil
+}
+
+//checkRecords verifies header and manifest, items are covered by manifest so they are not decrypted
+func checkRecords(records map[string][]byte, masterKey []byte) (*StorageHeader, error) {
+	header, err := parseStorageHeader(records[storageRecordHeader])
+	if err != nil {
+		return nil, err
+	}
+
+	if header == nil {
+		return nil, errStorageCorrupt
+	}
+
+	if !hmac.Equal(header.KeyID, storageKeyID(masterKey)) {
+		return nil, errStorageWrongKey
+	}
+
+	if !hmac.Equal(records[storageRecordManifest], storageManifest(masterKey, records)) {
+		return nil, fmt.Errorf("manifest: %v", errStorageCorrupt)
+	}
+
+	return header, nil
+}
+
+//decodeBlob decrypts headerless legacy file, its plain items get own data keys wrapped by sessionKey
+func decodeBlob(encBuffer, masterKey, sessionKey []byte) (*StorageHeader, map[string]*storageItem, error) {
+	d, err := DecryptGCM(encBuffer, masterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer WipeBytes(d)
+
+	var plainItems map[string][]byte
+
+	gob.NewDecoder(bytes.NewBuffer(d)).Decode(&plainItems)
+
+	created := time.Now()
+	items := make(map[string]*storageItem)
+
+	for name, data := range plainItems {
+		items[name], err = sealItem(name, data, sessionKey)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		items[name].Meta = ItemMeta{Type: DetectItemType(name, data), Created: created, Updated: created}
+
+		WipeBytes(data)
+	}
+
+	return &StorageHeader{Version: StorageVersionLegacy}, items, nil
+}
@@ -0,0 +1,125 @@
+package gutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	key, err := GetRandomBuffer(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestStorageRekey(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "storage.dat")
+	key1, key2 := testKey(t), testKey(t)
+
+	s, err := CreateStorage(fileName, key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = s.Set("item", []byte("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = os.Stat(storageBackupName(fileName, 1)); err != nil {
+		t.Fatalf("no backup before rekey: %v", err)
+	}
+
+	for _, newKey := range [][]byte{key1, key2[:5]} {
+		err = s.Rekey(newKey)
+		if err == nil {
+			t.Errorf("rekey to %x accepted", newKey)
+		}
+	}
+
+	err = s.Rekey(key2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// backups are sealed by old key
+	if _, err = os.Stat(storageBackupName(fileName, 1)); !os.IsNotExist(err) {
+		t.Errorf("backup left after rekey: %v", err)
+	}
+
+	_, err = OpenStorage(fileName, key1, 0)
+	if err == nil {
+		t.Error("opened by old key")
+	}
+
+	s, err = OpenStorage(fileName, key2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(s.Header().KeyID, storageKeyID(key2)) {
+		t.Errorf("KeyID %x, want %x", s.Header().KeyID, storageKeyID(key2))
+	}
+
+	b, ok := s.Get("item")
+	if !ok || !bytes.Equal(b.Bytes(), []byte("data")) {
+		t.Fatalf("item after rekey: %v", ok)
+	}
+
+	b.Release()
+}
+
+func TestDecodeCoinInfo(t *testing.T) {
+	for _, key := range []string{
+		`"5HueCGU8rMjxEXxiPuD5BDku4MkFqeZyd4dZ1jvhTVqvbTLvyTJ"`,
+		`""`,
+		`"a\"b\\c\/d\be\ff\ng\rh\ti"`,
+		`"Aé€😀"`,
+		`"\u0041\u00e9\u20AC\ud83d\ude00"`,
+		`"\ud800x\udc00\ud800A"`,
+		`"\\u0041"`,
+	} {
+		data := []byte(`{"address":"1BoatSLRHtKNngkdXEeobR76b53LETtpyT","key":` + key + `}`)
+
+		var want struct {
+			Address string `json:"address"`
+			Key     string `json:"key"`
+		}
+
+		err := json.Unmarshal(data, &want)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		address, b, err := decodeCoinInfo(data)
+		if err != nil {
+			t.Errorf("%s: %v", key, err)
+
+			continue
+		}
+
+		if address != want.Address || string(b.Bytes()) != want.Key {
+			t.Errorf("%s: decoded %q, want %q", key, b.Bytes(), want.Key)
+		}
+
+		b.Release()
+	}
+
+	for _, data := range []string{
+		`{"address":"1BoatSLRHtKNngkdXEeobR76b53LETtpyT","key":5}`,
+		`{"address":"1BoatSLRHtKNngkdXEeobR76b53LETtpyT"}`,
+		`{"address":"1BoatSLRHtKNngkdXEeobR76b53LETtpyT","key":"\x"}`,
+	} {
+		_, _, err := decodeCoinInfo([]byte(data))
+		if err == nil {
+			t.Errorf("%s: decoded", data)
+		}
+	}
+}
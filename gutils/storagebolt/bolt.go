@@ -0,0 +1,88 @@
+package storagebolt
+
+import (
+	"time"
+
+	"github.com/seagiv/common/gutils"
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucketRecords = []byte("storage")
+
+//Backend keeps storage records in bbolt database, every save is one transaction
+type Backend struct {
+	db *bolt.DB
+}
+
+//Open opens or creates database file
+func Open(fileName string) (*Backend, error) {
+	db, err := bolt.Open(fileName, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketRecords)
+		return err
+	})
+	if err != nil {
+		db.Close()
+
+		return nil, err
+	}
+
+	return &Backend{db: db}, nil
+}
+
+//Load -
+func (b *Backend) Load(accept func(records map[string][]byte) error) (map[string][]byte, error) {
+	records := make(map[string][]byte)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRecords).ForEach(func(k, v []byte) error {
+			records[string(k)] = append([]byte{}, v...) // values are valid only during transaction
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = accept(records)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+//Save -
+func (b *Backend) Save(changed map[string][]byte, deleted []string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketRecords)
+
+		for _, name := range deleted {
+			err := bucket.Delete([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+
+		for name, record := range changed {
+			err := bucket.Put([]byte(name), record)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+//Close -
+func (b *Backend) Close() error {
+	return b.db.Close()
+}
+
+var _ gutils.StorageBackend = (*Backend)(nil)
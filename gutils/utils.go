@@ -32,6 +32,7 @@ type CoinConfig struct {
 	Signer          string
 	URL             string
 	TestTransaction string
+	GasMargin       int64 // percent of eth_estimateGas result used as gas limit of contract calls, EVM coins only
 }
 
 //IndexOf -TODO-